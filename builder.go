@@ -0,0 +1,220 @@
+package session
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/8i8/session/ram"
+)
+
+// StoreBuilder collects the settings of a session manager such that they
+// are all in effect before its store is started, avoiding the races of
+// setting options upon a running store.
+type StoreBuilder struct {
+	mem MemType
+	cfg ram.Config
+}
+
+// Builder returns a StoreBuilder for a RAM store with default settings.
+func Builder() *StoreBuilder {
+	return &StoreBuilder{mem: RAM}
+}
+
+// WithMemType sets the type of memory that the store is to use.
+func (b *StoreBuilder) WithMemType(mem MemType) *StoreBuilder {
+	b.mem = mem
+	return b
+}
+
+// WithPeriod sets the interval of the stores timeout verification.
+func (b *StoreBuilder) WithPeriod(d time.Duration) *StoreBuilder {
+	b.cfg.Period = d
+	return b
+}
+
+// WithMaxSessions sets the maximum number of sessions in the store.
+func (b *StoreBuilder) WithMaxSessions(n int) *StoreBuilder {
+	b.cfg.MaxSessions = n
+	return b
+}
+
+// WithPolicy sets the action taken when the store is at capacity.
+func (b *StoreBuilder) WithPolicy(p ram.CapacityPolicy) *StoreBuilder {
+	b.cfg.Policy = p
+	return b
+}
+
+// WithMaxBytes sets the total size of the data that the store may hold.
+func (b *StoreBuilder) WithMaxBytes(n int) *StoreBuilder {
+	b.cfg.MaxBytes = n
+	return b
+}
+
+// WithGracePeriod sets the time that sessions are kept beyond expiry.
+func (b *StoreBuilder) WithGracePeriod(d time.Duration) *StoreBuilder {
+	b.cfg.GracePeriod = d
+	return b
+}
+
+// WithSweepBatch sets the number of sessions examined per sweep.
+func (b *StoreBuilder) WithSweepBatch(n int) *StoreBuilder {
+	b.cfg.SweepBatch = n
+	return b
+}
+
+// WithSweepSink sets the SweepSink that receives the SIDs removed by
+// each sweep.
+func (b *StoreBuilder) WithSweepSink(sink ram.SweepSink) *StoreBuilder {
+	b.cfg.SweepSink = sink
+	return b
+}
+
+// WithOrder sets the order in which expired sessions are destroyed.
+func (b *StoreBuilder) WithOrder(o ram.SweepOrder) *StoreBuilder {
+	b.cfg.Order = o
+	return b
+}
+
+// WithStatsInterval sets the interval of the stores stats summary.
+func (b *StoreBuilder) WithStatsInterval(d time.Duration) *StoreBuilder {
+	b.cfg.StatsInterval = d
+	return b
+}
+
+// WithClock sets the clock by which the store times its sessions.
+func (b *StoreBuilder) WithClock(c ram.Clock) *StoreBuilder {
+	b.cfg.Clock = c
+	return b
+}
+
+// WithIDSource sets the source of the ids minted by the store.
+func (b *StoreBuilder) WithIDSource(src ram.IDSource) *StoreBuilder {
+	b.cfg.IDSource = src
+	return b
+}
+
+// WithLogger sets the Logger of the store.
+func (b *StoreBuilder) WithLogger(l ram.Logger) *StoreBuilder {
+	b.cfg.Logger = l
+	return b
+}
+
+// WithDiagnostics sets the Diagnostics of the store, by default its
+// debug and error events are discarded.
+func (b *StoreBuilder) WithDiagnostics(d ram.Diagnostics) *StoreBuilder {
+	b.cfg.Diagnostics = d
+	return b
+}
+
+// WithPersister puts the store in write-behind mode, flushing changed
+// sessions to p every interval and on Close.
+func (b *StoreBuilder) WithPersister(p ram.Persister, interval time.Duration) *StoreBuilder {
+	b.cfg.Persister = p
+	b.cfg.FlushInterval = interval
+	return b
+}
+
+// WithWAL has the store keep a write-ahead log at path, recovering its
+// sessions from it as it starts and compacting it every compactAt
+// entries. Should the recovery fail Open returns the error and Build
+// panics.
+func (b *StoreBuilder) WithWAL(path string, compactAt int) *StoreBuilder {
+	b.cfg.WAL = path
+	b.cfg.WALCompact = compactAt
+	return b
+}
+
+// WithTracer sets the Tracer of the store.
+func (b *StoreBuilder) WithTracer(t ram.Tracer) *StoreBuilder {
+	b.cfg.Tracer = t
+	return b
+}
+
+// WithLabelKeys sets the keys of the request labels by which the store
+// counts its calls, and the number of values counted for each key.
+func (b *StoreBuilder) WithLabelKeys(maxValues int, keys ...string) *StoreBuilder {
+	b.cfg.LabelKeys = keys
+	b.cfg.MaxLabelValues = maxValues
+	return b
+}
+
+// WithNormalizeKey sets the function applied to every session key.
+func (b *StoreBuilder) WithNormalizeKey(fn func(key string) string) *StoreBuilder {
+	b.cfg.NormalizeKey = fn
+	return b
+}
+
+// WithCompactAt sets the number of holes left in the array of sessions
+// by destroyed sessions before it is compacted.
+func (b *StoreBuilder) WithCompactAt(n int) *StoreBuilder {
+	b.cfg.CompactAt = n
+	return b
+}
+
+// WithDefaultMaxAge sets the maxage of sessions created without one.
+func (b *StoreBuilder) WithDefaultMaxAge(d time.Duration) *StoreBuilder {
+	b.cfg.DefaultMaxAge = d
+	return b
+}
+
+// WithMaxValueBytes sets the greatest length of a string or byte slice
+// value.
+func (b *StoreBuilder) WithMaxValueBytes(n int) *StoreBuilder {
+	b.cfg.MaxValueBytes = n
+	return b
+}
+
+// WithRecordOps sets the number of recent operations that are kept.
+func (b *StoreBuilder) WithRecordOps(n int) *StoreBuilder {
+	b.cfg.RecordOps = n
+	return b
+}
+
+// WithName sets the name that labels the log events of the store.
+func (b *StoreBuilder) WithName(name string) *StoreBuilder {
+	b.cfg.Name = name
+	return b
+}
+
+// WithMaxCreates limits the number of concurrent calls to Create.
+func (b *StoreBuilder) WithMaxCreates(n int) *StoreBuilder {
+	b.cfg.MaxCreates = n
+	return b
+}
+
+// WithRand sets the source that decides the time of the first sweep.
+func (b *StoreBuilder) WithRand(r *rand.Rand) *StoreBuilder {
+	b.cfg.Rand = r
+	return b
+}
+
+// WithQueueSize sets the number of commands that may wait upon the store.
+func (b *StoreBuilder) WithQueueSize(n int) *StoreBuilder {
+	b.cfg.QueueSize = n
+	return b
+}
+
+// Build starts the store with all of the collected settings applied and
+// returns its manager.
+func (b *StoreBuilder) Build() Manager {
+	m, err := b.Open()
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Open is Build, returning the error should the store fail to start, as
+// when its write-ahead log can not be recovered.
+func (b *StoreBuilder) Open() (Manager, error) {
+	m := &manager{}
+	switch b.mem {
+	case RAM:
+		s, err := ram.OpenWith(b.cfg)
+		if err != nil {
+			return nil, err
+		}
+		m.Manager = s
+	}
+	return m, nil
+}
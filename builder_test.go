@@ -0,0 +1,57 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// countLogger counts the events logged to it.
+type countLogger struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *countLogger) Info(pkg, fname, event string, args ...interface{}) {
+	c.mu.Lock()
+	c.n++
+	c.mu.Unlock()
+}
+
+func (c *countLogger) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+func TestBuilder(t *testing.T) {
+	const fname = "TestBuilder"
+	l := &countLogger{}
+	m := Builder().
+		WithPeriod(time.Hour).
+		WithMaxSessions(1).
+		WithLogger(l).
+		WithStatsInterval(10 * time.Millisecond).
+		Build()
+
+	if _, err := m.Create(uuid.New(), 0); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err := m.Create(uuid.New(), 0)
+	if !errors.Is(err, ErrCapacity) {
+		t.Errorf("%s: want ErrCapacity got (%T, %+v)", fname, err, err)
+	}
+	if p := m.Period(time.Minute); p != time.Hour {
+		t.Errorf("%s: want %v got %v", fname, time.Hour, p)
+	}
+	deadline := time.Now().Add(time.Second)
+	for l.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l.count() == 0 {
+		t.Errorf("%s: want logged events got none", fname)
+	}
+}
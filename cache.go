@@ -0,0 +1,119 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// CachedManager is a session manager that keeps the sessions restored
+// from an underlying, typically remote, manager for a short time, such
+// that repeated restores of a session within a burst of requests are
+// served locally. Creation and destruction are passed straight through.
+//
+// A cached session is not touched in the underlying manager, as such an
+// entry is kept for no longer than the lesser of the cache TTL and the
+// maxage of the session, by which time the underlying session may have
+// expired.
+type CachedManager struct {
+	Manager
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[uuid.UUID]cacheEntry
+	// pruneAt is the number of entries at which stale entries are
+	// next pruned.
+	pruneAt int
+}
+
+// cacheEntry is a cached session and the time at which it goes stale.
+type cacheEntry struct {
+	se    ram.Session
+	stale time.Time
+}
+
+// NewCachedManager returns a CachedManager that caches the sessions of m
+// for up to ttl.
+func NewCachedManager(m Manager, ttl time.Duration) *CachedManager {
+	return &CachedManager{
+		Manager: m,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[uuid.UUID]cacheEntry),
+		pruneAt: minPrune,
+	}
+}
+
+// Create makes the session in the underlying manager and caches it.
+func (c *CachedManager) Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error) {
+	se, err := c.Manager.Create(sid, maxage, opts...)
+	if err != nil {
+		return se, err
+	}
+	c.put(se)
+	return se, nil
+}
+
+// Restore returns the cached session if it is fresh, otherwise it
+// restores the session from the underlying manager and caches it.
+func (c *CachedManager) Restore(sid uuid.UUID) (ram.Session, error) {
+	c.mu.Lock()
+	e, ok := c.entries[sid]
+	if ok && c.now().Before(e.stale) {
+		c.mu.Unlock()
+		return e.se, nil
+	}
+	delete(c.entries, sid)
+	c.mu.Unlock()
+	se, err := c.Manager.Restore(sid)
+	if err != nil {
+		return se, err
+	}
+	c.put(se)
+	return se, nil
+}
+
+// Destroy removes the session from the cache and then from the
+// underlying manager.
+func (c *CachedManager) Destroy(sid uuid.UUID) error {
+	c.Invalidate(sid)
+	return c.Manager.Destroy(sid)
+}
+
+// Invalidate removes the session from the cache, such that it is next
+// restored from the underlying manager.
+func (c *CachedManager) Invalidate(sid uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, sid)
+}
+
+// minPrune is the least number of entries at which the cache is pruned.
+const minPrune = 64
+
+// put caches the session, pruning the stale entries of sessions that
+// have not been restored since whenever the cache doubles in size.
+func (c *CachedManager) put(se ram.Session) {
+	life := c.ttl
+	if age := se.MaxAge(); age < life {
+		life = age
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.entries[se.ID()] = cacheEntry{se: se, stale: now.Add(life)}
+	if len(c.entries) < c.pruneAt {
+		return
+	}
+	for id, e := range c.entries {
+		if !now.Before(e.stale) {
+			delete(c.entries, id)
+		}
+	}
+	c.pruneAt = 2 * len(c.entries)
+	if c.pruneAt < minPrune {
+		c.pruneAt = minPrune
+	}
+}
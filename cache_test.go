@@ -0,0 +1,73 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// countingManager counts the restores that reach the manager.
+type countingManager struct {
+	Manager
+	restores int
+}
+
+func (m *countingManager) Restore(sid uuid.UUID) (ram.Session, error) {
+	m.restores++
+	return m.Manager.Restore(sid)
+}
+
+func TestCachedManager(t *testing.T) {
+	const fname = "TestCachedManager"
+	back := &countingManager{Manager: NewManager(RAM)}
+	c := NewCachedManager(back, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	id := uuid.New()
+	if _, err := back.Create(id, 3600); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// The first restore reaches the backing manager, the rest do not.
+	for i := 0; i < 3; i++ {
+		if _, err := c.Restore(id); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if back.restores != 1 {
+		t.Errorf("%s: want 1 restore got %d", fname, back.restores)
+	}
+	// Once stale the session is restored again.
+	now = now.Add(time.Minute)
+	if _, err := c.Restore(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if back.restores != 2 {
+		t.Errorf("%s: want 2 restores got %d", fname, back.restores)
+	}
+
+	// Destroy invalidates the local entry.
+	if err := c.Destroy(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err := c.Restore(id)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+
+	// A session is cached for no longer than its maxage, by which time
+	// the backing session may have expired.
+	se, err := c.Create(uuid.New(), 10)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	back.restores = 0
+	now = now.Add(10 * time.Second)
+	c.Restore(se.ID())
+	if back.restores != 1 {
+		t.Errorf("%s: want 1 restore got %d", fname, back.restores)
+	}
+}
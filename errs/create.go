@@ -0,0 +1,26 @@
+package errs
+
+import "fmt"
+
+// ErrCollision is returned by CreateIfAbsent when a session of the same
+// id was created concurrently, as by another node sharing the backend.
+var ErrCollision = New(Exists, "session created concurrently")
+
+// CreateIfAbsent gives every provider the same create if absent
+// semantics. It runs create, and should create fail with an error for
+// which conflict returns true, the uniqueness violation of the
+// providers backend, the error is replaced by ErrCollision which
+// matches the Exists sentinel of every provider. If restore is given it
+// is then run in its place, the concurrent create having most likely
+// just made the session, and its result returned. Other errors are
+// returned as they are.
+func CreateIfAbsent(create func() error, conflict func(error) bool, restore func() error) error {
+	err := create()
+	if err == nil || !conflict(err) {
+		return err
+	}
+	if restore == nil {
+		return fmt.Errorf("%w: %v", ErrCollision, err)
+	}
+	return restore()
+}
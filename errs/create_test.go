@@ -0,0 +1,66 @@
+package errs
+
+import (
+	"errors"
+	"testing"
+)
+
+// backend mocks the store of a remote provider, inserts of a key that
+// is present violate its uniqueness constraint.
+type backend struct {
+	rows map[string]int
+}
+
+var errDuplicate = errors.New("duplicate key value violates unique constraint")
+
+func (b *backend) insert(key string, v int) error {
+	if _, ok := b.rows[key]; ok {
+		return errDuplicate
+	}
+	b.rows[key] = v
+	return nil
+}
+
+func isDuplicate(err error) bool {
+	return errors.Is(err, errDuplicate)
+}
+
+func TestCreateIfAbsent(t *testing.T) {
+	const fname = "TestCreateIfAbsent"
+	b := &backend{rows: map[string]int{}}
+	create := func(v int) func() error {
+		return func() error { return b.insert("sid", v) }
+	}
+
+	if err := CreateIfAbsent(create(1), isDuplicate, nil); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// Another node creates the same session.
+	err := CreateIfAbsent(create(2), isDuplicate, nil)
+	if !errors.Is(err, New(Exists, "")) {
+		t.Errorf("%s: want Exists got (%T, %+v)", fname, err, err)
+	}
+	if !errors.Is(err, ErrCollision) {
+		t.Errorf("%s: want ErrCollision got (%T, %+v)", fname, err, err)
+	}
+
+	// With restore given the existing session is returned instead.
+	var got int
+	restore := func() error {
+		got = b.rows["sid"]
+		return nil
+	}
+	if err := CreateIfAbsent(create(3), isDuplicate, restore); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if got != 1 {
+		t.Errorf("%s: want 1 got %d", fname, got)
+	}
+
+	// Errors other than a conflict are untouched.
+	broken := errors.New("connection reset")
+	err = CreateIfAbsent(func() error { return broken }, isDuplicate, restore)
+	if err != broken {
+		t.Errorf("%s: want %v got (%T, %+v)", fname, broken, err, err)
+	}
+}
@@ -0,0 +1,125 @@
+// Package errs defines the error type shared by the session providers,
+// errors carry a code by which they are compared, such that an error
+// from any provider matches the public sentinel of the same code.
+package errs
+
+// Code identifies the kind of failure.
+type Code int
+
+const (
+	Unknown Code = iota
+	// NotFound the session does not exist.
+	NotFound
+	// Exists the session already exists.
+	Exists
+	// TimedOut the session has expired.
+	TimedOut
+	// InvalidID the session id is poorly formed.
+	InvalidID
+	// NoData the key is not in the session.
+	NoData
+	// Capacity the store is full.
+	Capacity
+	// Conflict the operation would overwrite existing data.
+	Conflict
+	// Rejected the operation was vetoed.
+	Rejected
+	// InvalidSession the session was never valid.
+	InvalidSession
+	// Destroyed the session has been destroyed.
+	Destroyed
+	// NotOwner the session belongs to another user.
+	NotOwner
+	// Busy the store is at its limit of concurrent operations.
+	Busy
+	// Internal the store failed unexpectedly.
+	Internal
+	// NotSerializable the value may not be persisted.
+	NotSerializable
+	// Unavailable the backend of the store cannot be reached.
+	Unavailable
+	// Closed the store has been closed.
+	Closed
+	// Cycle the link would make a session its own ancestor.
+	Cycle
+	// NotWindow the value is not a window counter.
+	NotWindow
+	// Unresponsive the store did not answer in time.
+	Unresponsive
+	// OtherStore the sessions belong to different stores.
+	OtherStore
+	// Reserved the key is reserved for the use of the store.
+	Reserved
+)
+
+// String returns the name of the code.
+func (c Code) String() string {
+	switch c {
+	case NotFound:
+		return "NotFound"
+	case Exists:
+		return "Exists"
+	case TimedOut:
+		return "TimedOut"
+	case InvalidID:
+		return "InvalidID"
+	case NoData:
+		return "NoData"
+	case Capacity:
+		return "Capacity"
+	case Conflict:
+		return "Conflict"
+	case Rejected:
+		return "Rejected"
+	case InvalidSession:
+		return "InvalidSession"
+	case Destroyed:
+		return "Destroyed"
+	case NotOwner:
+		return "NotOwner"
+	case Busy:
+		return "Busy"
+	case Internal:
+		return "Internal"
+	case NotSerializable:
+		return "NotSerializable"
+	case Unavailable:
+		return "Unavailable"
+	case Closed:
+		return "Closed"
+	case Cycle:
+		return "Cycle"
+	case NotWindow:
+		return "NotWindow"
+	case Unresponsive:
+		return "Unresponsive"
+	case OtherStore:
+		return "OtherStore"
+	case Reserved:
+		return "Reserved"
+	}
+	return "Unknown"
+}
+
+// Error is a session error carrying a code.
+type Error struct {
+	Code Code
+	Msg  string
+}
+
+// New returns an error with the given code and message.
+func New(c Code, msg string) *Error {
+	return &Error{Code: c, Msg: msg}
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Is reports whether target is an Error with the same code, allowing
+// errors.Is to match errors from differing providers.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
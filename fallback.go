@@ -0,0 +1,305 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// DegradePolicy defines how a FallbackManager behaves whilst its remote
+// manager cannot be reached.
+type DegradePolicy int
+
+const (
+	// FailOpen serves sessions from the local store during an outage,
+	// such that users remain logged in, and writes the changes made
+	// meanwhile back to the remote manager once it recovers.
+	FailOpen DegradePolicy = iota
+	// FailClosed returns an error matching ErrUnavailable during an
+	// outage.
+	FailClosed
+)
+
+// FallbackManager is a session manager that serves sessions from a
+// remote manager, such as a Redis or SQL backend, degrading to a local
+// RAM store when the remote cannot be reached.
+//
+// An error that carries no session Error, see the errs package, is
+// taken for an outage; a remote provider is to return errors matching
+// the sentinels of this package for all else. Whilst the remote is up
+// and the policy is FailOpen, each session that is created or restored
+// is copied to the local store, such that it may be restored during an
+// outage as it was when last restored.
+//
+// Once the retry interval has passed the next call tries the remote
+// again, first writing to it the sessions created, restored or
+// destroyed locally during the outage, those of the outage replacing
+// any of the same SID. For this the remote provider is to be a
+// Replacer, until it is the manager remains degraded. A session
+// restored before an outage belongs to the remote, as such its use
+// fails until it is restored again.
+type FallbackManager struct {
+	Manager
+	local  *ram.Store
+	policy DegradePolicy
+	retry  time.Duration
+	now    func() time.Time
+	mu     sync.Mutex
+	down   bool
+	// cause is the error by which the outage was last seen.
+	cause error
+	// probeAt is the time after which the remote is next tried.
+	probeAt time.Time
+	// reconciling is set whilst the outage is being written back.
+	reconciling bool
+	// dirty and gone are the sessions used and destroyed locally
+	// during the outage.
+	dirty map[uuid.UUID]struct{}
+	gone  map[uuid.UUID]struct{}
+}
+
+// NewFallbackManager returns a FallbackManager that serves sessions
+// from remote, falling back upon local according to policy whilst the
+// remote is down, and trying the remote again every retry.
+func NewFallbackManager(remote Manager, local *ram.Store, policy DegradePolicy, retry time.Duration) *FallbackManager {
+	return &FallbackManager{
+		Manager: remote,
+		local:   local,
+		policy:  policy,
+		retry:   retry,
+		now:     time.Now,
+		dirty:   make(map[uuid.UUID]struct{}),
+		gone:    make(map[uuid.UUID]struct{}),
+	}
+}
+
+// Degraded reports whether the manager is serving from its local store.
+func (f *FallbackManager) Degraded() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.down
+}
+
+// Create makes the session in the remote manager, or during an outage
+// in the local store.
+func (f *FallbackManager) Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error) {
+	if f.useRemote() {
+		se, err := f.Manager.Create(sid, maxage, opts...)
+		if !outage(err) {
+			if err == nil {
+				f.mirror(se)
+			}
+			return se, err
+		}
+		f.fail(err)
+	}
+	if f.policy == FailClosed {
+		return ram.Session{}, f.unavailable()
+	}
+	se, err := f.local.Create(sid, maxage, opts...)
+	if err != nil {
+		return se, err
+	}
+	f.used(sid)
+	return se, nil
+}
+
+// Restore restores the session from the remote manager, or during an
+// outage from the local store.
+func (f *FallbackManager) Restore(sid uuid.UUID) (ram.Session, error) {
+	if f.useRemote() {
+		se, err := f.Manager.Restore(sid)
+		if !outage(err) {
+			if err == nil {
+				f.mirror(se)
+			}
+			return se, err
+		}
+		f.fail(err)
+	}
+	if f.policy == FailClosed {
+		return ram.Session{}, f.unavailable()
+	}
+	se, err := f.local.Restore(sid)
+	if err != nil {
+		return se, err
+	}
+	f.used(sid)
+	return se, nil
+}
+
+// Destroy destroys the session in the remote manager and the local
+// store, or during an outage in the local store alone, to be destroyed
+// in the remote once it recovers.
+func (f *FallbackManager) Destroy(sid uuid.UUID) error {
+	if f.useRemote() {
+		err := f.Manager.Destroy(sid)
+		if !outage(err) {
+			f.local.Destroy(sid)
+			return err
+		}
+		f.fail(err)
+	}
+	if f.policy == FailClosed {
+		return f.unavailable()
+	}
+	err := f.local.Destroy(sid)
+	f.mu.Lock()
+	delete(f.dirty, sid)
+	f.gone[sid] = struct{}{}
+	f.mu.Unlock()
+	return err
+}
+
+// useRemote reports whether the remote manager is to be used, it being
+// up or due to be tried again, in which case the outage is first
+// written back to it.
+func (f *FallbackManager) useRemote() bool {
+	f.mu.Lock()
+	if !f.down {
+		f.mu.Unlock()
+		return true
+	}
+	if f.reconciling || f.now().Before(f.probeAt) {
+		f.mu.Unlock()
+		return false
+	}
+	f.reconciling = true
+	f.mu.Unlock()
+
+	err := f.reconcile()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reconciling = false
+	if err != nil {
+		f.cause = err
+		f.probeAt = f.now().Add(f.retry)
+		return false
+	}
+	f.down = false
+	return true
+}
+
+// fail records that the remote manager cannot be reached for err.
+func (f *FallbackManager) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = true
+	f.cause = err
+	f.probeAt = f.now().Add(f.retry)
+}
+
+// unavailable returns the error of a call refused during an outage.
+func (f *FallbackManager) unavailable() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return unavailable{f.cause}
+}
+
+// used records that the session was created or restored locally during
+// the outage.
+func (f *FallbackManager) used(sid uuid.UUID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.gone, sid)
+	f.dirty[sid] = struct{}{}
+}
+
+// mirror copies a session of the remote manager to the local store, in
+// place of any local copy, which is removed should the session not be
+// copied such that a stale copy is not served during an outage.
+func (f *FallbackManager) mirror(se ram.Session) {
+	if f.policy == FailClosed {
+		return
+	}
+	snap, err := se.Snapshot()
+	if err == nil {
+		_, err = f.local.ImportReplace(snap)
+	}
+	if err != nil {
+		f.local.Destroy(se.ID())
+	}
+}
+
+// reconcile writes the sessions used and destroyed during the outage to
+// the remote manager, stopping at the first failure, such that what
+// remains is written on the next attempt.
+func (f *FallbackManager) reconcile() error {
+	f.mu.Lock()
+	gone := make([]uuid.UUID, 0, len(f.gone))
+	for sid := range f.gone {
+		gone = append(gone, sid)
+	}
+	dirty := make([]uuid.UUID, 0, len(f.dirty))
+	for sid := range f.dirty {
+		dirty = append(dirty, sid)
+	}
+	f.mu.Unlock()
+
+	if len(gone)+len(dirty) == 0 {
+		return nil
+	}
+	im, ok := provider(f.Manager).(Replacer)
+	if !ok {
+		return ErrNoMigrate
+	}
+	for _, sid := range gone {
+		err := f.Manager.Destroy(sid)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		f.mu.Lock()
+		delete(f.gone, sid)
+		f.mu.Unlock()
+	}
+	for _, sid := range dirty {
+		// The session may have expired locally since, in which case
+		// it is destroyed in the remote.
+		se, err := f.local.Restore(sid)
+		if err == nil {
+			var snap ram.Snapshot
+			snap, err = se.Snapshot()
+			if err == nil {
+				_, err = im.ImportReplace(snap)
+			}
+		} else {
+			err = f.Manager.Destroy(sid)
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		f.mu.Lock()
+		delete(f.dirty, sid)
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+// outage reports whether err is the failure to reach a backend rather
+// than an error of the session.
+func outage(err error) bool {
+	var e *Error
+	return err != nil && !errors.As(err, &e)
+}
+
+// unavailable wraps the error of a backend that cannot be reached.
+type unavailable struct {
+	err error
+}
+
+func (u unavailable) Error() string {
+	return ErrUnavailable.Error() + ": " + u.err.Error()
+}
+
+func (u unavailable) Unwrap() error {
+	return u.err
+}
+
+// Is reports whether target matches ErrUnavailable.
+func (u unavailable) Is(target error) bool {
+	return errors.Is(ErrUnavailable, target)
+}
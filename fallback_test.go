@@ -0,0 +1,254 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// errRefused stands for the failure to reach a remote backend.
+var errRefused = errors.New("connection refused")
+
+// flakyManager is a remote manager that may be taken down.
+type flakyManager struct {
+	*ram.Store
+	mu   sync.Mutex
+	down bool
+	// refuse fails imports alone.
+	refuse bool
+}
+
+func (m *flakyManager) setDown(down bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.down = down
+}
+
+func (m *flakyManager) isDown() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.down
+}
+
+func (m *flakyManager) Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error) {
+	if m.isDown() {
+		return ram.Session{}, errRefused
+	}
+	return m.Store.Create(sid, maxage, opts...)
+}
+
+func (m *flakyManager) Restore(sid uuid.UUID) (ram.Session, error) {
+	if m.isDown() {
+		return ram.Session{}, errRefused
+	}
+	return m.Store.Restore(sid)
+}
+
+func (m *flakyManager) Destroy(sid uuid.UUID) error {
+	if m.isDown() {
+		return errRefused
+	}
+	return m.Store.Destroy(sid)
+}
+
+func (m *flakyManager) Import(snap ram.Snapshot) (ram.Session, error) {
+	if m.isDown() {
+		return ram.Session{}, errRefused
+	}
+	return m.Store.Import(snap)
+}
+
+func (m *flakyManager) ImportReplace(snap ram.Snapshot) (ram.Session, error) {
+	m.mu.Lock()
+	refuse := m.down || m.refuse
+	m.mu.Unlock()
+	if refuse {
+		return ram.Session{}, errRefused
+	}
+	return m.Store.ImportReplace(snap)
+}
+
+func TestFallbackManager(t *testing.T) {
+	const fname = "TestFallbackManager"
+	remote := &flakyManager{Store: ram.Init()}
+	f := NewFallbackManager(remote, ram.Init(), FailOpen, time.Minute)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	a, err := f.Create(uuid.New(), 3600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := a.Set("user", "bob"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	c, err := f.Create(uuid.New(), 3600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := f.Restore(a.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// During the outage the sessions are served from RAM.
+	remote.setDown(true)
+	se, err := f.Restore(a.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !f.Degraded() {
+		t.Errorf("%s: want the manager degraded", fname)
+	}
+	if v, err := se.Get("user"); err != nil || v != "bob" {
+		t.Errorf("%s: want (bob, <nil>) got (%v, %v)", fname, v, err)
+	}
+	if err := se.Set("cart", 1); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	b, err := f.Create(uuid.New(), 3600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := b.Set("user", "ann"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := f.Destroy(c.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// Recovered, the remote is not tried before the retry interval.
+	remote.setDown(false)
+	if _, err := f.Restore(b.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := remote.Store.Restore(b.ID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+
+	// Then the outage is written back.
+	now = now.Add(time.Minute)
+	se, err = f.Restore(a.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if f.Degraded() {
+		t.Errorf("%s: want the manager recovered", fname)
+	}
+	if v, err := se.Get("cart"); err != nil || v != 1 {
+		t.Errorf("%s: want (1, <nil>) got (%v, %v)", fname, v, err)
+	}
+	se, err = remote.Store.Restore(b.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("user"); err != nil || v != "ann" {
+		t.Errorf("%s: want (ann, <nil>) got (%v, %v)", fname, v, err)
+	}
+	if _, err := remote.Store.Restore(c.ID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestFallbackManagerReconcile(t *testing.T) {
+	const fname = "TestFallbackManagerReconcile"
+	remote := &flakyManager{Store: ram.Init()}
+	f := NewFallbackManager(remote, ram.Init(), FailOpen, time.Minute)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	a, err := f.Create(uuid.New(), 3600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// A change made in the remote replaces the local copy on restore.
+	se, err := remote.Store.Restore(a.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("user", "bob"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := f.Restore(a.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	remote.setDown(true)
+	se, err = f.Restore(a.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("user"); err != nil || v != "bob" {
+		t.Errorf("%s: want (bob, <nil>) got (%v, %v)", fname, v, err)
+	}
+	if err := se.Set("user", "ann"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// A failed write back leaves the remote copy in place.
+	remote.setDown(false)
+	remote.mu.Lock()
+	remote.refuse = true
+	remote.mu.Unlock()
+	now = now.Add(time.Minute)
+	if _, err := f.Restore(a.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !f.Degraded() {
+		t.Errorf("%s: want the manager degraded", fname)
+	}
+	se, err = remote.Store.Restore(a.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("user"); err != nil || v != "bob" {
+		t.Errorf("%s: want (bob, <nil>) got (%v, %v)", fname, v, err)
+	}
+
+	remote.mu.Lock()
+	remote.refuse = false
+	remote.mu.Unlock()
+	now = now.Add(time.Minute)
+	se, err = f.Restore(a.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if f.Degraded() {
+		t.Errorf("%s: want the manager recovered", fname)
+	}
+	if v, err := se.Get("user"); err != nil || v != "ann" {
+		t.Errorf("%s: want (ann, <nil>) got (%v, %v)", fname, v, err)
+	}
+}
+
+func TestFallbackManagerFailClosed(t *testing.T) {
+	const fname = "TestFallbackManagerFailClosed"
+	remote := &flakyManager{Store: ram.Init()}
+	f := NewFallbackManager(remote, ram.Init(), FailClosed, time.Minute)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	a, err := f.Create(uuid.New(), 3600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	remote.setDown(true)
+	_, err = f.Restore(a.ID())
+	if !errors.Is(err, ErrUnavailable) || !errors.Is(err, errRefused) {
+		t.Errorf("%s: want ErrUnavailable got (%T, %+v)", fname, err, err)
+	}
+	if _, err := f.Create(uuid.New(), 3600); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("%s: want ErrUnavailable got (%T, %+v)", fname, err, err)
+	}
+	if err := f.Destroy(a.ID()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("%s: want ErrUnavailable got (%T, %+v)", fname, err, err)
+	}
+
+	remote.setDown(false)
+	now = now.Add(time.Minute)
+	if _, err := f.Restore(a.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
@@ -0,0 +1,377 @@
+// Package file persists session records to disk using envelope
+// encryption. Every value in a record is sealed with a data key unique
+// to that record and the data key is in turn sealed, or wrapped, by a
+// master key. The master key may be rotated by rewrapping the data keys
+// alone, leaving the sealed values untouched.
+package file
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/8i8/session/errs"
+	"github.com/8i8/session/stamp"
+	"github.com/google/uuid"
+)
+
+var ErrNoRecord = errs.New(errs.NotFound, "record does not exist")
+var ErrKeySize = errors.New("master key must be 32 bytes")
+var ErrUnknownKey = errors.New("record sealed with an unknown master key")
+
+// ext is the file extension used for records.
+const ext = ".sess"
+
+// Record is the persisted form of a session.
+type Record struct {
+	ID       uuid.UUID
+	Created  time.Time
+	Modified time.Time
+	MaxAge   time.Duration
+	Data     map[string]interface{}
+}
+
+// envelope is the on disk form of a record, the data key is wrapped by
+// the master key whose version is recorded alongside it. Timestamps are
+// stored as stamps, Created and Modified are only read, from records
+// written before that was so.
+type envelope struct {
+	ID         uuid.UUID
+	Created    time.Time
+	Modified   time.Time
+	CreatedAt  stamp.Stamp
+	ModifiedAt stamp.Stamp
+	MaxAge     time.Duration
+	Version    uint64
+	DataKey    []byte
+	Values     map[string][]byte
+}
+
+// Store reads and writes encrypted session records in a directory. It
+// is safe for concurrent use, a key rotation waits upon the saves and
+// loads in progress and holds up those that follow until it is done.
+type Store struct {
+	dir     string
+	mu      sync.RWMutex
+	current uint64
+	masters map[uint64]cipher.AEAD
+}
+
+// Open returns a store that keeps its records in dir, sealing new data
+// keys with master. Any previous master keys that records may still be
+// sealed with can be supplied in old.
+func Open(dir string, master []byte, old ...[]byte) (*Store, error) {
+	const fname = "Open"
+	fail := func(err error) (*Store, error) {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fail(err)
+	}
+	s := &Store{dir: dir, masters: make(map[uint64]cipher.AEAD)}
+	for _, k := range old {
+		if _, err := s.addMaster(k); err != nil {
+			return fail(err)
+		}
+	}
+	v, err := s.addMaster(master)
+	if err != nil {
+		return fail(err)
+	}
+	s.current = v
+	return s, nil
+}
+
+// version returns the version by which a master key is identified, the
+// leading bytes of its hash, so that the key itself need never be
+// stored.
+func version(key []byte) uint64 {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// addMaster adds the key to the stores key ring returning its version.
+// The caller is to hold the write lock, unless the store is not yet in
+// use.
+func (s *Store) addMaster(key []byte) (uint64, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return 0, err
+	}
+	v := version(key)
+	s.masters[v] = aead
+	return v, nil
+}
+
+// newAEAD returns an AES-256-GCM cipher for the given key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain, prefixing the result with its nonce.
+func seal(aead cipher.AEAD, plain, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, ad), nil
+}
+
+// open decrypts data that was encrypted by seal.
+func open(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	n := aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, data[:n], data[n:], ad)
+}
+
+// path returns the file name of the record for id.
+func (s *Store) path(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String()+ext)
+}
+
+// Save writes the record to disk, replacing any previous record of the
+// same id. Values are encoded with encoding/gob, as such the concrete
+// types of any interface values must be registered with gob.
+func (s *Store) Save(r Record) error {
+	const fname = "Store.Save"
+	fail := func(err error) error {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dk := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dk); err != nil {
+		return fail(err)
+	}
+	aead, err := newAEAD(dk)
+	if err != nil {
+		return fail(err)
+	}
+	wrapped, err := seal(s.masters[s.current], dk, r.ID[:])
+	if err != nil {
+		return fail(err)
+	}
+	e := envelope{
+		ID:         r.ID,
+		CreatedAt:  stamp.Of(r.Created),
+		ModifiedAt: stamp.Of(r.Modified),
+		MaxAge:     r.MaxAge,
+		Version:    s.current,
+		DataKey:    wrapped,
+		Values:     make(map[string][]byte, len(r.Data)),
+	}
+	for k, v := range r.Data {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+			return fail(fmt.Errorf("key %q: %w", k, err))
+		}
+		// The key is bound to its value so that sealed values may
+		// not be swapped between keys.
+		e.Values[k], err = seal(aead, buf.Bytes(), []byte(k))
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err := s.write(e); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// Load reads the record for id from disk.
+func (s *Store) Load(id uuid.UUID) (r Record, err error) {
+	const fname = "Store.Load"
+	fail := func(err error) (Record, error) {
+		return Record{}, fmt.Errorf("%s: %w", fname, err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.read(id)
+	if err != nil {
+		return fail(err)
+	}
+	aead, err := s.dataKey(e)
+	if err != nil {
+		return fail(err)
+	}
+	r = Record{
+		ID:       e.ID,
+		Created:  e.Created,
+		Modified: e.Modified,
+		MaxAge:   e.MaxAge,
+		Data:     make(map[string]interface{}, len(e.Values)),
+	}
+	if e.CreatedAt != 0 || e.ModifiedAt != 0 {
+		r.Created, r.Modified = e.CreatedAt.Time(), e.ModifiedAt.Time()
+	}
+	for k, sealed := range e.Values {
+		plain, err := open(aead, sealed, []byte(k))
+		if err != nil {
+			return fail(fmt.Errorf("key %q: %w", k, err))
+		}
+		var v interface{}
+		err = gob.NewDecoder(bytes.NewReader(plain)).Decode(&v)
+		if err != nil {
+			return fail(fmt.Errorf("key %q: %w", k, err))
+		}
+		r.Data[k] = v
+	}
+	return r, nil
+}
+
+// Delete removes the record for id from disk.
+func (s *Store) Delete(id uuid.UUID) error {
+	const fname = "Store.Delete"
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		err = ErrNoRecord
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return nil
+}
+
+// IDs returns the ids of all of the records on disk.
+func (s *Store) IDs() (ids []uuid.UUID, err error) {
+	const fname = "Store.IDs"
+	names, err := filepath.Glob(filepath.Join(s.dir, "*"+ext))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	for _, name := range names {
+		base := filepath.Base(name)
+		id, err := uuid.Parse(base[:len(base)-len(ext)])
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+// RotateKey makes master the current master key, rewrapping the data
+// key of every record on disk with it. The values themselves are not
+// decrypted. Records remain readable throughout as the version of the
+// wrapping key is stored with each, once all are rewrapped the previous
+// master keys are forgotten. A record deleted during the rotation, as
+// by another process, is passed over.
+func (s *Store) RotateKey(master []byte) error {
+	const fname = "Store.RotateKey"
+	fail := func(err error) error {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.addMaster(master)
+	if err != nil {
+		return fail(err)
+	}
+	ids, err := s.IDs()
+	if err != nil {
+		return fail(err)
+	}
+	for _, id := range ids {
+		e, err := s.read(id)
+		if errors.Is(err, ErrNoRecord) {
+			continue
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if e.Version == v {
+			continue
+		}
+		old, ok := s.masters[e.Version]
+		if !ok {
+			return fail(fmt.Errorf("%s: %w", id, ErrUnknownKey))
+		}
+		dk, err := open(old, e.DataKey, e.ID[:])
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", id, err))
+		}
+		e.DataKey, err = seal(s.masters[v], dk, e.ID[:])
+		if err != nil {
+			return fail(err)
+		}
+		e.Version = v
+		if err := s.write(e); err != nil {
+			return fail(err)
+		}
+	}
+	s.current = v
+	for k := range s.masters {
+		if k != v {
+			delete(s.masters, k)
+		}
+	}
+	return nil
+}
+
+// dataKey unwraps the data key of the envelope.
+func (s *Store) dataKey(e envelope) (cipher.AEAD, error) {
+	master, ok := s.masters[e.Version]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	dk, err := open(master, e.DataKey, e.ID[:])
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(dk)
+}
+
+// read decodes the envelope for id from disk.
+func (s *Store) read(id uuid.UUID) (e envelope, err error) {
+	f, err := os.Open(s.path(id))
+	if os.IsNotExist(err) {
+		return e, ErrNoRecord
+	}
+	if err != nil {
+		return e, err
+	}
+	defer f.Close()
+	err = gob.NewDecoder(f).Decode(&e)
+	return
+}
+
+// write encodes the envelope to disk, replacing the previous file
+// atomically so that a failed write never leaves a partial record.
+func (s *Store) write(e envelope) error {
+	tmp, err := ioutil.TempFile(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(e); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(e.ID))
+}
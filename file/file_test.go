@@ -0,0 +1,209 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// key returns a 32 byte master key filled with b.
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newRecord(n int) Record {
+	now := time.Now()
+	return Record{
+		ID:       uuid.New(),
+		Created:  now,
+		Modified: now,
+		MaxAge:   time.Minute,
+		Data:     map[string]interface{}{"n": n, "name": "user"},
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	const fname = "TestSaveLoad"
+	s, err := Open(t.TempDir(), key(1))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	r := newRecord(7)
+	if err := s.Save(r); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	got, err := s.Load(r.ID)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if got.Data["n"] != 7 || got.Data["name"] != "user" {
+		t.Errorf("%s: want %v got %v", fname, r.Data, got.Data)
+	}
+	if !got.Created.Equal(r.Created) || got.MaxAge != r.MaxAge {
+		t.Errorf("%s: want %+v got %+v", fname, r, got)
+	}
+	if err := s.Delete(r.ID); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err = s.Load(r.ID)
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("%s: want ErrNoRecord got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	const fname = "TestRotateKey"
+	dir := t.TempDir()
+	s, err := Open(dir, key(1))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	var recs []Record
+	for i := 0; i < 5; i++ {
+		r := newRecord(i)
+		if err := s.Save(r); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		recs = append(recs, r)
+	}
+	// The sealed values must not be rewritten by a rotation.
+	before, err := s.read(recs[0].ID)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	if err := s.RotateKey(key(2)); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for i, r := range recs {
+		got, err := s.Load(r.ID)
+		if err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+			continue
+		}
+		if got.Data["n"] != i {
+			t.Errorf("%s: want %d got %v", fname, i, got.Data["n"])
+		}
+	}
+	after, err := s.read(recs[0].ID)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !bytes.Equal(before.Values["n"], after.Values["n"]) {
+		t.Errorf("%s: want sealed value unchanged", fname)
+	}
+	if before.Version == after.Version {
+		t.Errorf("%s: want key version changed", fname)
+	}
+
+	// The old master can no longer read anything written since.
+	r := newRecord(9)
+	if err := s.Save(r); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	old, err := Open(dir, key(1))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err = old.Load(r.ID)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("%s: want ErrUnknownKey got (%T, %+v)", fname, err, err)
+	}
+	_, err = old.Load(recs[0].ID)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("%s: want ErrUnknownKey got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestRotateKeyRace(t *testing.T) {
+	const fname = "TestRotateKeyRace"
+	dir := t.TempDir()
+	s, err := Open(dir, key(1))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// Another process removes records from the same directory.
+	other, err := Open(dir, key(1))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				r := newRecord(i)
+				if err := s.Save(r); err != nil {
+					t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+					return
+				}
+				if _, err := s.Load(r.ID); err != nil {
+					t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+					return
+				}
+				del := s.Delete
+				if j%2 == 0 {
+					del = other.Delete
+				}
+				if err := del(r.ID); err != nil {
+					t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+					return
+				}
+			}
+		}(i)
+	}
+	for b := byte(2); b < 5; b++ {
+		if err := s.RotateKey(key(b)); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestTimestamps(t *testing.T) {
+	const fname = "TestTimestamps"
+	s, err := Open(t.TempDir(), key(1))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// A monotonic reading in a location other than UTC.
+	loc := time.FixedZone("UTC-7", -7*3600)
+	r := newRecord(1)
+	r.Created = time.Now().In(loc)
+	r.Modified = r.Created.Add(time.Second)
+	if err := s.Save(r); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	got, err := s.Load(r.ID)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !got.Created.Equal(r.Created) || !got.Modified.Equal(r.Modified) {
+		t.Errorf("%s: want %v %v got %v %v", fname, r.Created,
+			r.Modified, got.Created, got.Modified)
+	}
+
+	// Records written before timestamps were stamped still load.
+	e, err := s.read(r.ID)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	e.Created, e.Modified = r.Created, r.Modified
+	e.CreatedAt, e.ModifiedAt = 0, 0
+	if err := s.write(e); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	got, err = s.Load(r.ID)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !got.Created.Equal(r.Created) || !got.Modified.Equal(r.Modified) {
+		t.Errorf("%s: want %v %v got %v %v", fname, r.Created,
+			r.Modified, got.Created, got.Modified)
+	}
+}
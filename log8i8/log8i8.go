@@ -0,0 +1,38 @@
+// Package log8i8 adapts github.com/8i8/log for use by the session
+// stores, which otherwise log nothing.
+//
+//	s := ram.InitWith(ram.Config{Diagnostics: log8i8.Logger{}})
+package log8i8
+
+import (
+	"github.com/8i8/log"
+)
+
+// Logger writes the events of a store to github.com/8i8/log, subject to
+// its level. It implements both ram.Diagnostics and ram.Logger, the
+// latter at the debug level.
+type Logger struct{}
+
+// Debug writes a debug event.
+func (Logger) Debug(err error, pkg, fname, event string, args ...interface{}) {
+	if log.Is(log.DEBUG) {
+		log.Debug(err, pkg, fname, event, args...)
+	}
+}
+
+// Err writes an error event.
+func (Logger) Err(err error, pkg, fname, event string, args ...interface{}) {
+	if log.Is(log.ERROR) {
+		log.Err(err, pkg, fname, event, args...)
+	}
+}
+
+// Fatal writes a fatal event, ending the program.
+func (Logger) Fatal(pkg, fname, event string, args ...interface{}) {
+	log.Fatal(pkg, fname, event, args...)
+}
+
+// Info writes an informational event at the debug level.
+func (l Logger) Info(pkg, fname, event string, args ...interface{}) {
+	l.Debug(nil, pkg, fname, event, args...)
+}
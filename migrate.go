@@ -0,0 +1,78 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/8i8/session/ram"
+)
+
+// Exporter is implemented by providers whose sessions can be listed.
+type Exporter interface {
+	Export() []ram.Snapshot
+}
+
+// Importer is implemented by providers that can recreate a session from
+// a snapshot.
+type Importer interface {
+	Import(snap ram.Snapshot) (ram.Session, error)
+}
+
+// Replacer is implemented by providers that can recreate a session from
+// a snapshot in place of any of the same SID.
+type Replacer interface {
+	ImportReplace(snap ram.Snapshot) (ram.Session, error)
+}
+
+// ErrNoMigrate is returned when a provider does not support migration.
+var ErrNoMigrate = errors.New("provider does not support migration")
+
+// provider returns the provider underlying a manager.
+func provider(m Manager) Manager {
+	if mgr, ok := m.(*manager); ok {
+		return provider(mgr.current())
+	}
+	return m
+}
+
+// Migrate recreates every session in src within dst, preserving their
+// data, timestamps and so their remaining lifetime. If destroy is set
+// each session that is migrated is removed from src. Sessions whose
+// SID is already in use in dst are left in place and counted, if there
+// are any an error wrapping ErrExists is returned once all of the other
+// sessions have been migrated.
+func Migrate(src, dst Manager, destroy bool) (migrated int, err error) {
+	const fname = "Migrate"
+	ex, ok := provider(src).(Exporter)
+	if !ok {
+		return 0, fmt.Errorf("%s: source: %w", fname, ErrNoMigrate)
+	}
+	im, ok := provider(dst).(Importer)
+	if !ok {
+		return 0, fmt.Errorf("%s: destination: %w", fname, ErrNoMigrate)
+	}
+	var collisions int
+	for _, snap := range ex.Export() {
+		_, err := im.Import(snap)
+		if errors.Is(err, ErrExists) {
+			collisions++
+			continue
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("%s: %w", fname, err)
+		}
+		migrated++
+		if destroy {
+			// The session may have been removed by now.
+			err = src.Destroy(snap.ID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return migrated, fmt.Errorf("%s: %w", fname, err)
+			}
+		}
+	}
+	if collisions > 0 {
+		return migrated, fmt.Errorf("%s: %d sessions: %w", fname,
+			collisions, ErrExists)
+	}
+	return
+}
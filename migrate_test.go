@@ -0,0 +1,69 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+func TestMigrate(t *testing.T) {
+	const fname = "TestMigrate"
+	src, dst := NewManager(RAM), NewManager(RAM)
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		id := uuid.New()
+		sess, err := src.Create(id, 60*(i+1))
+		if err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if err = sess.Set("n", i); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		ids = append(ids, id)
+	}
+	// A collision in the destination is left in place.
+	if _, err := dst.Create(ids[4], 0); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	before := provider(src).(Exporter).Export()
+
+	n, err := Migrate(src, dst, true)
+	if !errors.Is(err, ErrExists) {
+		t.Errorf("%s: want ErrExists got (%T, %+v)", fname, err, err)
+	}
+	if n != 4 {
+		t.Errorf("%s: want 4 migrated got %d", fname, n)
+	}
+
+	after := make(map[uuid.UUID]ram.Snapshot)
+	for _, snap := range provider(dst).(Exporter).Export() {
+		after[snap.ID] = snap
+	}
+	for i, b := range before[:4] {
+		a, ok := after[b.ID]
+		if !ok {
+			t.Errorf("%s: want %s migrated", fname, b.ID)
+			continue
+		}
+		if a.Data["n"] != i {
+			t.Errorf("%s: want %d got %v", fname, i, a.Data["n"])
+		}
+		if !a.Created.Equal(b.Created) ||
+			!a.Modified.Equal(b.Modified) || a.MaxAge != b.MaxAge {
+			t.Errorf("%s: want %+v got %+v", fname, b, a)
+		}
+		_, err := src.Restore(b.ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: want ErrNotFound got (%T, %+v)",
+				fname, err, err)
+		}
+	}
+	if _, err := src.Restore(ids[4]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, ok := after[ids[4]].Data["n"]; ok {
+		t.Errorf("%s: want colliding session unchanged", fname)
+	}
+}
@@ -0,0 +1,72 @@
+package ram
+
+import (
+	"fmt"
+	"time"
+)
+
+// Age returns the time since the session was created, by the clock of
+// the store. Reading it does not touch the session.
+func (s Session) Age() (age time.Duration, err error) {
+	const fname = "Session.Age"
+	err = s.peek(func(se Session, now time.Time) {
+		age = now.Sub(se.created)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// IdleTime returns the time since the session was last used, by the
+// clock of the store. Reading it does not touch the session, as such it
+// is not itself a use.
+func (s Session) IdleTime() (idle time.Duration, err error) {
+	const fname = "Session.IdleTime"
+	err = s.peek(func(se Session, now time.Time) {
+		idle = now.Sub(se.modified)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// peek runs fn upon the live state of the session from within the
+// session server, without touching it, along with the present time. The
+// session is expired first should it have timed out.
+func (s Session) peek(fn func(se Session, now time.Time)) (err error) {
+	const fname = "Session.peek"
+	if s.zero() {
+		return ErrInvalidSession
+	}
+	sto := s.sto
+	sto.exec(func() {
+		se, live := sto.sessions[s.id]
+		if live && sto.expired(se) {
+			sto.expire(s.id, fname)
+			live = false
+		}
+		if !live {
+			err = sto.goneErr(s.id)
+			return
+		}
+		fn(se, sto.clock.Now())
+	})
+	return
+}
+
+// Refresh returns the session as it stands in the store, its maxage and
+// times of creation and last use, such that a session held for some time
+// may be brought up to date. Refreshing does not touch the session. An
+// error matching ErrNoSession is returned if the session is gone.
+func (s Session) Refresh() (fresh Session, err error) {
+	const fname = "Session.Refresh"
+	err = s.peek(func(se Session, now time.Time) {
+		fresh = se
+	})
+	if err != nil {
+		return Session{}, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
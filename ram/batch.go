@@ -0,0 +1,255 @@
+package ram
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+)
+
+// RestoreMany restores every session of the given SIDs that exists in
+// one operation of the session server, each as by Restore. Missing
+// sessions, and poorly formed SIDs, are omitted from the result; any
+// other error ends the restoration and is returned with the sessions
+// restored until then.
+func (s *Store) RestoreMany(sids []uuid.UUID) (sessions map[uuid.UUID]Session, err error) {
+	const fname = "Store.RestoreMany"
+	sessions = make(map[uuid.UUID]Session, len(sids))
+	s.exec(func() {
+		for _, sid := range sids {
+			if invalid(sid) {
+				continue
+			}
+			r := command{cmd: touch, key: sid, seStore: s}.run()
+			if errors.Is(r.err, ErrNoSession) {
+				continue
+			}
+			if r.err != nil {
+				err = r.err
+				return
+			}
+			sessions[sid] = r.se
+		}
+	})
+	if err != nil {
+		return sessions, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// DestroyMany destroys every session of the given SIDs that exists in
+// one operation of the session server, returning the number destroyed
+// along with the error for each SID that was not, ErrPoorForm if it is
+// poorly formed, one matching ErrNoSession if there is no such session
+// or that of the write-ahead log should its destruction fail to be
+// logged.
+func (s *Store) DestroyMany(sids []uuid.UUID) (removed int, errs map[uuid.UUID]error) {
+	const fname = "Store.DestroyMany"
+	errs = make(map[uuid.UUID]error)
+	s.exec(func() {
+		for _, sid := range sids {
+			if invalid(sid) {
+				errs[sid] = ErrPoorForm
+				continue
+			}
+			se, ok := s.sessions[sid]
+			if !ok {
+				errs[sid] = s.goneErr(sid)
+				continue
+			}
+			if err := s.logDestroy(sid); err != nil {
+				errs[sid] = err
+				continue
+			}
+			s.record(OpDestroy, sid, "")
+			s.drop(se, fname)
+			removed++
+		}
+		if removed > 0 {
+			s.compact()
+		}
+	})
+	return
+}
+
+// compact removes the SIDs of destroyed sessions, and the holes left by
+// them, from the array in one pass, correcting the index of those that
+// remain. This function is to be run only by the sessionServer function.
+func (s *Store) compact() {
+	kept := s.array[:0]
+	for _, id := range s.array {
+		se, ok := s.sessions[id]
+		if !ok {
+			continue
+		}
+		se.index = len(kept)
+		s.sessions[id] = se
+		kept = append(kept, id)
+	}
+	s.array = kept
+	s.index = len(kept)
+	s.holes = 0
+}
+
+// each calls fn for every session in the array, in order of creation,
+// passing over any holes. This function is to be run only by the
+// sessionServer function.
+func (s *Store) each(fn func(se Session)) {
+	for _, id := range s.array {
+		if id == uuid.Nil {
+			continue
+		}
+		fn(s.sessions[id])
+	}
+}
+
+// SetMany stores every key value pair in one operation of the session
+// server. Either all of the pairs are stored or, if they will not fit in
+// the store, none are and ErrCapacity is returned.
+func (s Session) SetMany(pairs map[string]interface{}) error {
+	const fname = "Session.SetMany"
+	if s.zero() {
+		return fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	var err error
+	pairs = s.sto.normalizeKeys(pairs)
+	gone := s.sto.update(s.id, func(se Session) {
+		err = s.sto.putMany(se, pairs)
+	})
+	if gone != nil {
+		err = gone
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return nil
+}
+
+// SetAll overlays data onto the session in one operation of the session
+// server, as SetMany, returning the number of keys written, those of
+// data that normalize to the same key being counted once. Keys of the
+// session that are not in data are left as they are.
+func (s Session) SetAll(data map[string]interface{}) (n int, err error) {
+	const fname = "Session.SetAll"
+	if s.zero() {
+		return 0, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	pairs := s.sto.normalizeKeys(data)
+	gone := s.sto.update(s.id, func(se Session) {
+		err = s.sto.putMany(se, pairs)
+	})
+	if gone != nil {
+		err = gone
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fname, err)
+	}
+	return len(pairs), nil
+}
+
+// SetStatus is the effect that storing a pair had upon a key.
+type SetStatus int
+
+const (
+	// KeyCreated the key was not in the session.
+	KeyCreated SetStatus = iota
+	// KeyOverwritten the key held a different value.
+	KeyOverwritten
+	// KeyUnchanged the key held an equal value.
+	KeyUnchanged
+)
+
+// String returns the name of the status.
+func (st SetStatus) String() string {
+	switch st {
+	case KeyCreated:
+		return "KeyCreated"
+	case KeyOverwritten:
+		return "KeyOverwritten"
+	case KeyUnchanged:
+		return "KeyUnchanged"
+	}
+	return "unknown"
+}
+
+// SetManyResult holds the status of each key stored by SetManyR.
+type SetManyResult map[string]SetStatus
+
+// SetManyR is SetMany, reporting for each key whether it was created,
+// overwritten or left unchanged, values being compared with
+// reflect.DeepEqual, such that callers may skip work when nothing has
+// changed.
+func (s Session) SetManyR(pairs map[string]interface{}) (res SetManyResult, err error) {
+	const fname = "Session.SetManyR"
+	if s.zero() {
+		return nil, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	pairs = s.sto.normalizeKeys(pairs)
+	gone := s.sto.update(s.id, func(se Session) {
+		res = make(SetManyResult, len(pairs))
+		for k, v := range pairs {
+			old, ok := se.data[k]
+			switch {
+			case !ok:
+				res[k] = KeyCreated
+			case reflect.DeepEqual(old, v):
+				res[k] = KeyUnchanged
+			default:
+				res[k] = KeyOverwritten
+			}
+		}
+		err = s.sto.putMany(se, pairs)
+	})
+	if gone != nil {
+		err = gone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// putMany stores every key value pair in the session, or none of them
+// returning ErrCapacity if they will not fit in the store or
+// ErrValueTooLarge if a value exceeds the maximum value size,
+// ErrNotSerializable if a value is refused by the Serializable check of
+// the store or ErrReservedKey if a key is reserved. This function is to
+// be run only by the sessionServer function.
+func (s *Store) putMany(se Session, pairs map[string]interface{}) error {
+	var delta int
+	for k, v := range pairs {
+		if reserved(k) {
+			return ErrReservedKey
+		}
+		if s.tooLarge(v) {
+			return ErrValueTooLarge
+		}
+		if err := s.serializable(k, v); err != nil {
+			return err
+		}
+		delta += sizeOf(k, v)
+		if old, ok := se.data[k]; ok {
+			delta -= sizeOf(k, old)
+		}
+	}
+	if !s.fit(delta, se.id) {
+		return ErrCapacity
+	}
+	err := s.logPut(se, func(data map[string]interface{}) {
+		for k, v := range pairs {
+			data[k] = v
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for k, v := range pairs {
+		se.data[k] = v
+	}
+	s.resize(se.id, delta)
+	for k, v := range pairs {
+		s.publish(se.id, k, v)
+	}
+	return nil
+}
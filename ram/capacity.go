@@ -0,0 +1,168 @@
+package ram
+
+import (
+	"github.com/google/uuid"
+)
+
+// CapacityPolicy defines the behaviour of the store when a session is
+// created whilst the store holds its maximum number of sessions.
+type CapacityPolicy int
+
+const (
+	// RejectNew refuses the new session, Create returns ErrCapacity.
+	RejectNew CapacityPolicy = iota
+	// EvictLRU destroys the least recently used session to make room
+	// for the new one.
+	EvictLRU
+	// EvictOldestCreated destroys the session that was created first
+	// to make room for the new one.
+	EvictOldestCreated
+)
+
+// String returns the name of the policy.
+func (p CapacityPolicy) String() string {
+	switch p {
+	case RejectNew:
+		return "RejectNew"
+	case EvictLRU:
+		return "EvictLRU"
+	case EvictOldestCreated:
+		return "EvictOldestCreated"
+	}
+	return "unknown"
+}
+
+// MaxSessions sets the maximum number of sessions that the store may
+// hold, a value of zero or less removes the limit, which is the
+// default. The previous value is returned.
+func (s *Store) MaxSessions(n int) (previous int) {
+	s.exec(func() {
+		previous = s.maxSessions
+		s.maxSessions = n
+	})
+	return
+}
+
+// Policy sets the action that the store takes when a session is
+// created whilst the store is at capacity, the default is RejectNew.
+// The previous policy is returned.
+func (s *Store) Policy(p CapacityPolicy) (previous CapacityPolicy) {
+	s.exec(func() {
+		previous = s.policy
+		s.policy = p
+	})
+	return
+}
+
+// Capacity returns the maximum number of sessions that the store may
+// hold and the policy applied when that number is reached.
+func (s *Store) Capacity() (max int, policy CapacityPolicy) {
+	s.exec(func() {
+		max, policy = s.maxSessions, s.policy
+	})
+	return
+}
+
+// makeRoom ensures that there is space for a new session in the store,
+// evicting sessions if the capacity policy permits it, returning false
+// if there is no room to be had. The sessions replaced, if any, are not
+// evicted but counted as gone, see replace. This function is to be run
+// only by the sessionServer function.
+func (s *Store) makeRoom(replaced ...uuid.UUID) bool {
+	const fname = "Store.makeRoom"
+	if s.maxSessions <= 0 {
+		return true
+	}
+	defer s.hold()()
+	// The limit may have been lowered, evict until there is room.
+	for {
+		n := len(s.sessions)
+		for _, id := range replaced {
+			if s.taken(id) {
+				n--
+			}
+		}
+		if n < s.maxSessions {
+			break
+		}
+		if !s.evict(replaced, fname) {
+			return false
+		}
+	}
+	return true
+}
+
+// evict destroys one session, other than those of keep, chosen
+// according to the capacity policy, returning false if the policy is
+// RejectNew or there is no session to evict.
+func (s *Store) evict(keep []uuid.UUID, sender string) bool {
+	var victim uuid.UUID
+	switch s.policy {
+	case EvictLRU:
+		for e := s.lru.Back(); e != nil; e = e.Prev() {
+			if id := e.Value.(uuid.UUID); !kept(id, keep) && !s.sessions[id].pinned {
+				victim = id
+				break
+			}
+		}
+	case EvictOldestCreated:
+		// The array holds the SIDs in order of creation.
+		for _, id := range s.array {
+			if id != uuid.Nil && !kept(id, keep) && !s.sessions[id].pinned {
+				victim = id
+				break
+			}
+		}
+	}
+	if victim == uuid.Nil {
+		return false
+	}
+	if d := s.diag; d != nil {
+		const event = "evicting session"
+		d.Debug(nil, s.label(), sender, event, "SID", victim,
+			"policy", s.policy)
+	}
+	s.record(OpEvict, victim, "")
+	s.destroy(victim, sender)
+	s.bury(victim, causeDestroyed, ReasonEvicted)
+	if s.onEvict != nil {
+		s.onEvict(victim)
+	}
+	if s.onEvictBatch != nil {
+		s.evictedBatch = append(s.evictedBatch, victim)
+		if !s.holding {
+			s.notify()
+		}
+	}
+	return true
+}
+
+// kept reports whether id is one of keep.
+func kept(id uuid.UUID, keep []uuid.UUID) bool {
+	for _, k := range keep {
+		if id == k {
+			return true
+		}
+	}
+	return false
+}
+
+// lruAdd registers a new session as the most recently used.
+func (s *Store) lruAdd(key uuid.UUID) {
+	s.lruElem[key] = s.lru.PushFront(key)
+}
+
+// lruTouch marks the session as the most recently used.
+func (s *Store) lruTouch(key uuid.UUID) {
+	if e, ok := s.lruElem[key]; ok {
+		s.lru.MoveToFront(e)
+	}
+}
+
+// lruRemove removes the session from the usage list.
+func (s *Store) lruRemove(key uuid.UUID) {
+	if e, ok := s.lruElem[key]; ok {
+		s.lru.Remove(e)
+		delete(s.lruElem, key)
+	}
+}
@@ -0,0 +1,82 @@
+package ram
+
+import "time"
+
+// Clock provides the store with the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default clock, it returns the system time.
+type systemClock struct{}
+
+// Now returns the current system time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Clock sets the clock by which the store times its sessions, the
+// default being the system time. The previous clock is returned. The
+// period of the timeout verification is not affected.
+func (s *Store) Clock(c Clock) (previous Clock) {
+	s.exec(func() {
+		previous = s.clock
+		s.clock = c
+	})
+	return
+}
+
+// GracePeriod sets the time that a session is kept beyond its maxage
+// before being destroyed. A session that is used within its grace
+// period is renewed as though it had not expired, tolerating clock skew
+// and slow clients. The default is zero. The previous value is
+// returned.
+func (s *Store) GracePeriod(d time.Duration) (previous time.Duration) {
+	s.exec(func() {
+		previous = s.grace
+		s.grace = d
+	})
+	return
+}
+
+// expired returns true if the session has outlived both its maxage and
+// the stores grace period, unless it is frozen, or has reached its
+// scheduled destruction. A pinned session never expires. A
+// session last used after the present, the clock having been set back,
+// has not expired.
+func (s *Store) expired(se Session) bool {
+	if se.pinned {
+		return false
+	}
+	now := s.clock.Now()
+	if !se.until.IsZero() && !now.Before(se.until) {
+		return true
+	}
+	if se.frozen {
+		return false
+	}
+	return now.Sub(se.modified) > se.maxage+s.grace
+}
+
+// rebase detects the clock having been set back since the previous
+// timeout verification, as happens on correction by NTP or when a
+// virtual machine resumes, and moves the last used time of every session
+// that is then in the future back to the present. Without this such
+// sessions would outlive their maxage by the size of the jump. The times
+// taken from the system clock carry a monotonic reading and so are not
+// affected, those restored from snapshots or given by another Clock
+// are. This function is to be run only by the sessionServer function.
+func (s *Store) rebase() {
+	now := s.clock.Now()
+	last := s.lastSweep
+	s.lastSweep = now
+	if last.IsZero() || !now.Before(last) {
+		return
+	}
+	for key, se := range s.sessions {
+		if se.modified.After(now) {
+			se.modified = now
+			s.sessions[key] = se
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ram
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Clone creates a new session in the store, with a newly minted id and
+// the same maxage, scheduled destruction, freeze, pin, tags and parent,
+// holding a deep copy of the sessions data, such that
+// changes to either session, or to the values within them, do not
+// reach the other. Maps, slices, arrays, pointers and the exported
+// fields of structs are copied, other values are shared. A value that
+// refers to itself is copied as such, its copy referring to the copy.
+// The source session is touched.
+func (s Session) Clone() (clone Session, err error) {
+	const fname = "Session.Clone"
+	fail := func(err error) (Session, error) {
+		return Session{}, fmt.Errorf("%s: %w", fname, err)
+	}
+	if s.zero() {
+		return fail(ErrInvalidSession)
+	}
+	var snap Snapshot
+	gone := s.sto.update(s.id, func(se Session) {
+		snap = se.snapshot()
+		snap.Data = deepCopy(snap.Data).(map[string]interface{})
+	})
+	if gone != nil {
+		return fail(gone)
+	}
+	clone, err = s.sto.New(0, WithData(snap.Data), withMaxAge(snap.MaxAge),
+		withState(snap))
+	if err != nil {
+		return fail(err)
+	}
+	return
+}
+
+// deepCopy returns a copy of v that shares no maps, slices, arrays or
+// pointers with it.
+func deepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	c := copier{seen: make(map[visit]reflect.Value)}
+	return c.value(reflect.ValueOf(v)).Interface()
+}
+
+// visit identifies a map, slice or pointer that has been copied, by
+// its address, type and, for a slice, length.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// copier makes deep copies, copying each map, slice and pointer once
+// such that the cycles of a value are reproduced rather than followed
+// without end.
+type copier struct {
+	seen map[visit]reflect.Value
+}
+
+// value returns a deep copy of v.
+func (c copier) value(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := visit{v.Pointer(), v.Type(), 0}
+		if cp, ok := c.seen[key]; ok {
+			return cp
+		}
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		c.seen[key] = cp
+		iter := v.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), c.elem(iter.Value(), v.Type().Elem()))
+		}
+		return cp
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		key := visit{v.Pointer(), v.Type(), v.Len()}
+		if cp, ok := c.seen[key]; ok {
+			return cp
+		}
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		c.seen[key] = cp
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(c.elem(v.Index(i), v.Type().Elem()))
+		}
+		return cp
+	case reflect.Array:
+		cp := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(c.elem(v.Index(i), v.Type().Elem()))
+		}
+		return cp
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		key := visit{v.Pointer(), v.Type(), 0}
+		if cp, ok := c.seen[key]; ok {
+			return cp
+		}
+		cp := reflect.New(v.Type().Elem())
+		c.seen[key] = cp
+		cp.Elem().Set(c.value(v.Elem()))
+		return cp
+	case reflect.Struct:
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := cp.Field(i); f.CanSet() {
+				f.Set(c.elem(v.Field(i), f.Type()))
+			}
+		}
+		return cp
+	}
+	return v
+}
+
+// elem returns a deep copy of v, an element of type t within a
+// container, which for an interface type is a copy of its dynamic value.
+func (c copier) elem(v reflect.Value, t reflect.Type) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Zero(t)
+		}
+		cp := reflect.New(t).Elem()
+		cp.Set(c.value(v.Elem()))
+		return cp
+	}
+	return c.value(v)
+}
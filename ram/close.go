@@ -0,0 +1,118 @@
+package ram
+
+import (
+	"container/list"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+// ErrClosed is returned when a session is created in a store that has
+// been closed.
+var ErrClosed = errs.New(errs.Closed, "session store closed")
+
+// OnClose sets a function to be called by Close with a snapshot of every
+// live session, in order of creation, such that they may be persisted
+// before the program exits. The function is called from within the
+// session server, as such it must not itself use the store. The
+// previous function is returned.
+func (s *Store) OnClose(fn func(snaps []Snapshot)) (previous func([]Snapshot)) {
+	s.exec(func() {
+		previous = s.onClose
+		s.onClose = fn
+	})
+	return
+}
+
+// Close shuts the store down. The OnClose function, if any, is given a
+// snapshot of every live session, after which the sessions are
+// destroyed and the session server, the timer and the stats summary
+// stop. Any later use of the store is run by the caller. In write-behind mode
+// the sessions changed since the last flush are first written to the
+// Persister, the sessions are not deleted from it, such that a store
+// started with the same Persister resumes them; the error of the write
+// is returned. The write-ahead log, if any, is likewise compacted and
+// closed with the sessions in it. Sessions may no longer be created, doing so returns
+// ErrClosed. Only the first call has any effect. Close implements
+// io.Closer.
+func (s *Store) Close() error {
+	const fname = "Store.Close"
+	// The final flush is written after any flush under way.
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+	var first bool
+	var snaps []Snapshot
+	var deleted []uuid.UUID
+	var walErr error
+	s.exec(func() {
+		if s.closed {
+			return
+		}
+		first = true
+		s.closed = true
+		if s.persister != nil {
+			snaps, deleted = s.collect()
+		}
+		if s.wal != nil {
+			walErr = s.compactWAL()
+			if err := s.wal.f.Close(); walErr == nil {
+				walErr = err
+			}
+			s.wal = nil
+		}
+		if s.onClose != nil {
+			snaps := make([]Snapshot, 0, len(s.array))
+			s.each(func(se Session) {
+				snaps = append(snaps, se.snapshot())
+			})
+			s.onClose(snaps)
+		}
+		s.each(func(se Session) {
+			s.bury(se.id, causeDestroyed, ReasonClosed)
+			s.unsubscribeAll(se.id)
+		})
+		s.stats.Destroyed += uint64(len(s.sessions))
+		s.sessions = make(map[uuid.UUID]Session)
+		s.array = nil
+		s.index = 0
+		s.holes = 0
+		s.bytes = 0
+		s.lru = list.New()
+		s.lruElem = make(map[uuid.UUID]*list.Element)
+		s.parents = make(map[uuid.UUID]uuid.UUID)
+		s.children = make(map[uuid.UUID][]uuid.UUID)
+		s.tagged, s.tagsOf = nil, nil
+		if d := s.diag; d != nil {
+			const event = "store closed"
+			d.Debug(nil, s.label(), fname, event)
+		}
+	})
+	if !first {
+		return nil
+	}
+	s.StatsInterval(0)
+	if s.flushStop != nil {
+		close(s.flushStop)
+	}
+	s.stop()
+	if s.persister != nil {
+		if err := s.write(snaps, deleted); err != nil {
+			return err
+		}
+	}
+	return walErr
+}
+
+// stop has the session server return once it has run the commands ahead
+// of it, waking the timer.
+func (s *Store) stop() {
+	s.send(command{cmd: exit, result: make(chan result, 1), seStore: s})
+}
+
+// isClosed returns true once the store has been closed.
+func (s *Store) isClosed() (closed bool) {
+	s.exec(func() {
+		closed = s.closed
+	})
+	return
+}
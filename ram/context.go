@@ -0,0 +1,37 @@
+package ram
+
+import "fmt"
+
+// SetContextObject attaches v to the session in a slot of its own,
+// apart from its data. The object is not counted in the size of the
+// session and is neither exported nor encoded, it is intended for
+// objects that are not to be serialised, such as a loaded user or
+// their permissions, for the lifetime of a request. Setting nil
+// clears it.
+func (s Session) SetContextObject(v interface{}) error {
+	const fname = "Session.SetContextObject"
+	if s.zero() {
+		return fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		se.ctx = v
+		s.sto.sessions[se.id] = se
+	})
+	if gone != nil {
+		return fmt.Errorf("%s: %w", fname, gone)
+	}
+	return nil
+}
+
+// ContextObject returns the object attached to the session by
+// SetContextObject, or nil if there is none or the session is no longer
+// live.
+func (s Session) ContextObject() (v interface{}) {
+	if s.zero() {
+		return nil
+	}
+	s.sto.update(s.id, func(se Session) {
+		v = se.ctx
+	})
+	return
+}
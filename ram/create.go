@@ -0,0 +1,104 @@
+package ram
+
+import (
+	"errors"
+	"time"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+var ErrRejected = errs.New(errs.Rejected, "session creation rejected")
+
+// createOptions holds the options given to Create.
+type createOptions struct {
+	data   map[string]interface{}
+	until  time.Time
+	maxage time.Duration
+	state  *Snapshot
+}
+
+// CreateOption sets an option on the creation of a session.
+type CreateOption func(*createOptions)
+
+// WithData creates the session holding a copy of data.
+func WithData(data map[string]interface{}) CreateOption {
+	return func(o *createOptions) {
+		o.data = data
+	}
+}
+
+// withMaxAge creates the session with the maxage d, in place of the
+// maxage in seconds given to Create, such that a maxage of less than a
+// second may be carried over.
+func withMaxAge(d time.Duration) CreateOption {
+	return func(o *createOptions) {
+		o.maxage = d
+	}
+}
+
+// withState creates the session with the scheduled destruction, freeze,
+// pin, tags and parent of the snapshot.
+func withState(snap Snapshot) CreateOption {
+	return func(o *createOptions) {
+		o.until = snap.Until
+		o.state = &snap
+	}
+}
+
+// WithDeadline creates the session scheduled for destruction at the
+// given time, as by DestroyAfter. Should the time already have passed
+// the session is not created and ErrTimedOut is returned.
+func WithDeadline(at time.Time) CreateOption {
+	return func(o *createOptions) {
+		o.until = at
+	}
+}
+
+// BeforeCreate sets a function that may veto the creation of a session
+// by returning an error, it is given the SID and a copy of the data of
+// the session to be created. Create then returns an error that matches
+// both ErrRejected and the error returned by fn. The function is called
+// from within the session server, as such it must not itself use the
+// store. The previous function is returned.
+func (s *Store) BeforeCreate(fn func(sid uuid.UUID, data map[string]interface{}) error) (previous func(uuid.UUID, map[string]interface{}) error) {
+	s.exec(func() {
+		previous = s.beforeCreate
+		s.beforeCreate = fn
+	})
+	return
+}
+
+// rejection wraps the error by which a creation was vetoed.
+type rejection struct {
+	err error
+}
+
+func (r rejection) Error() string {
+	return ErrRejected.Error() + ": " + r.err.Error()
+}
+
+func (r rejection) Unwrap() error {
+	return r.err
+}
+
+// Is reports whether target matches ErrRejected.
+func (r rejection) Is(target error) bool {
+	return errors.Is(ErrRejected, target)
+}
+
+// gate runs the BeforeCreate function, if any. This function is to be
+// run only by the sessionServer function.
+func (s *Store) gate(sid uuid.UUID, data map[string]interface{}) error {
+	if s.beforeCreate == nil {
+		return nil
+	}
+	cp := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		cp[k] = v
+	}
+	if err := s.beforeCreate(sid, cp); err != nil {
+		return rejection{err: err}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package ram
+
+// Diagnostics receives the debug and error events of a store, which are
+// given by the package, or the name of the store, the function in which
+// they arise and a description, followed by key value pairs. The store
+// has no Diagnostics unless one is given in its Config, its events are
+// then discarded, such that the package has no logging of its own. The
+// log8i8 package adapts github.com/8i8/log to this interface.
+type Diagnostics interface {
+	// Debug receives events that trace the working of the store.
+	Debug(err error, pkg, fname, event string, args ...interface{})
+	// Err receives events that indicate a fault within the store.
+	Err(err error, pkg, fname, event string, args ...interface{})
+	// Fatal receives an event after which the store can not continue,
+	// the store panics should Fatal return.
+	Fatal(pkg, fname, event string, args ...interface{})
+}
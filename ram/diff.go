@@ -0,0 +1,68 @@
+package ram
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ChangeKind defines the manner in which a key differs between two
+// snapshots.
+type ChangeKind int
+
+const (
+	// Added keys are present only in the later snapshot.
+	Added ChangeKind = iota
+	// Removed keys are present only in the earlier snapshot.
+	Removed
+	// Modified keys are present in both with differing values.
+	Modified
+)
+
+// String returns the name of the kind of change.
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "Added"
+	case Removed:
+		return "Removed"
+	case Modified:
+		return "Modified"
+	}
+	return "unknown"
+}
+
+// Change describes the difference in one key between two snapshots, Old
+// is nil for an added key and New for a removed one.
+type Change struct {
+	Key  string
+	Kind ChangeKind
+	Old  interface{}
+	New  interface{}
+}
+
+// Diff returns the changes to the data of a session between the
+// snapshots before and after, in order of key, such as for an audit of
+// what a request did. Values are compared with reflect.DeepEqual, such
+// that values which are not comparable may be held.
+func Diff(before, after Snapshot) []Change {
+	var changes []Change
+	for k, old := range before.Data {
+		v, ok := after.Data[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Key: k, Kind: Removed, Old: old})
+		case !reflect.DeepEqual(old, v):
+			changes = append(changes, Change{Key: k, Kind: Modified,
+				Old: old, New: v})
+		}
+	}
+	for k, v := range after.Data {
+		if _, ok := before.Data[k]; !ok {
+			changes = append(changes, Change{Key: k, Kind: Added, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
@@ -0,0 +1,47 @@
+package ram
+
+import (
+	"github.com/google/uuid"
+)
+
+// SessionFactory prepares each new session before it is stored, it may
+// add, change or remove values from the data with which the session is
+// to be created, setting default keys or stamping a version.
+type SessionFactory interface {
+	Prepare(sid uuid.UUID, data map[string]interface{})
+}
+
+// SessionFactoryFunc adapts an ordinary function to a SessionFactory.
+type SessionFactoryFunc func(sid uuid.UUID, data map[string]interface{})
+
+// Prepare calls f(sid, data).
+func (f SessionFactoryFunc) Prepare(sid uuid.UUID, data map[string]interface{}) {
+	f(sid, data)
+}
+
+// Factory sets the SessionFactory that prepares every session created
+// by the store, once any BeforeCreate function has permitted it. The
+// factory is called from within the session server, as such it must not
+// itself use the store. The previous factory is returned.
+func (s *Store) Factory(f SessionFactory) (previous SessionFactory) {
+	s.exec(func() {
+		previous = s.factory
+		s.factory = f
+	})
+	return
+}
+
+// prepare returns the data with which the session is to be created, as
+// amended by the factory, if any. This function is to be run only by the
+// sessionServer function.
+func (s *Store) prepare(sid uuid.UUID, data map[string]interface{}) map[string]interface{} {
+	if s.factory == nil {
+		return data
+	}
+	cp := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		cp[k] = v
+	}
+	s.factory.Prepare(sid, cp)
+	return cp
+}
@@ -0,0 +1,74 @@
+package ram
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Fingerprint returns a hash of the sessions data, such that sessions
+// holding the same keys and values have the same fingerprint whatever
+// the order in which they were set. Values are hashed by their JSON
+// encoding, as such a value that can not be encoded as JSON causes an
+// error to be returned. The fingerprint is not a cryptographic hash.
+func (s Session) Fingerprint() (uint64, error) {
+	const fname = "Session.Fingerprint"
+	if s.zero() {
+		return 0, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	var snap Snapshot
+	gone := s.sto.update(s.id, func(se Session) {
+		snap = se.snapshot()
+	})
+	if gone != nil {
+		return 0, fmt.Errorf("%s: %w", fname, gone)
+	}
+	fp, err := fingerprint(snap.Data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fname, err)
+	}
+	return fp, nil
+}
+
+// CompareAndTouch touches the session only if the fingerprint of its
+// data is expected, as returned by an earlier call to Fingerprint,
+// reporting whether it was touched, such that a session is kept alive
+// only if it has not been changed since it was read. The comparison and
+// the touch are made in one operation of the session server.
+func (s Session) CompareAndTouch(expected uint64) (touched bool, err error) {
+	const fname = "Session.CompareAndTouch"
+	var ferr error
+	touched, err = s.RenewIf(func(data map[string]interface{}) bool {
+		var fp uint64
+		fp, ferr = fingerprint(data)
+		return ferr == nil && fp == expected
+	})
+	if err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// fingerprint returns the hash of the data of a session.
+func fingerprint(data map[string]interface{}) (uint64, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	for _, k := range keys {
+		b, err := json.Marshal(data[k])
+		if err != nil {
+			return 0, fmt.Errorf("key %q: %w", k, err)
+		}
+		// Length prefixes keep the boundaries between keys and
+		// values unambiguous.
+		fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(b), b)
+	}
+	return h.Sum64(), nil
+}
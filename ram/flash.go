@@ -0,0 +1,70 @@
+package ram
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/8i8/session/errs"
+)
+
+// flashPrefix is the namespace in which flash messages are kept, apart
+// from the regular keys of a session. Keys within it are reserved, they
+// may not be set other than by Flash, nor are they listed by Keys.
+const flashPrefix = "_flash."
+
+// ErrReservedKey is returned when a key reserved for the use of the
+// store, such as those of flash messages, is set.
+var ErrReservedKey = errs.New(errs.Reserved, "key reserved by the session store")
+
+// reserved reports whether the key is reserved for the use of the store.
+func reserved(key string) bool {
+	return strings.HasPrefix(key, flashPrefix)
+}
+
+// Flash adds a message to those held under key, to be read once only by
+// Flashes.
+func (s Session) Flash(key string, value interface{}) (err error) {
+	const fname = "Session.Flash"
+	fail := func(err error) error {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	if s.zero() {
+		return fail(ErrInvalidSession)
+	}
+	k := flashPrefix + s.sto.normalize(key)
+	gone := s.sto.update(s.id, func(se Session) {
+		prev, _ := se.data[k].([]interface{})
+		flashes := make([]interface{}, len(prev), len(prev)+1)
+		copy(flashes, prev)
+		err = s.sto.putKey(se, k, append(flashes, value))
+	})
+	if gone != nil {
+		return fail(gone)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return
+}
+
+// Flashes returns the messages held under key, in the order in which
+// they were added, and removes them in the same operation. A key with
+// no messages returns an empty slice.
+func (s Session) Flashes(key string) (flashes []interface{}, err error) {
+	const fname = "Session.Flashes"
+	if s.zero() {
+		return nil, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	k := flashPrefix + s.sto.normalize(key)
+	gone := s.sto.update(s.id, func(se Session) {
+		flashes, _ = se.data[k].([]interface{})
+		err = s.sto.remove(se, k)
+	})
+	if gone != nil {
+		return nil, fmt.Errorf("%s: %w", fname, gone)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package ram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/8i8/session/errs"
+)
+
+// ErrUnresponsive is returned by Report when the session server does not
+// answer within the time given.
+var ErrUnresponsive = errs.New(errs.Unresponsive, "session server unresponsive")
+
+// HealthReport describes the state of a store at one moment.
+type HealthReport struct {
+	// Sessions is the number of sessions in the store.
+	Sessions int
+	// QueueDepth is the number of commands waiting upon the server.
+	QueueDepth int
+	// QueueCapacity is the number that may wait before senders block.
+	QueueCapacity int
+	// LastSweep is the time of the last timeout verification, zero if
+	// there has been none.
+	LastSweep time.Time
+	// Latency is the time that the server took to answer.
+	Latency time.Duration
+}
+
+// Report returns the health of the store, such as for a health check
+// endpoint, in one operation of the session server. Should the server
+// not answer within timeout, the report holds only the queue figures,
+// which do not require it, and ErrUnresponsive is returned; the request
+// is then left to be answered, or not, in the background.
+func (s *Store) Report(timeout time.Duration) (r HealthReport, err error) {
+	const fname = "Store.Report"
+	r.QueueDepth = s.QueueDepth()
+	r.QueueCapacity = s.QueueCapacity()
+	start := time.Now()
+	done := make(chan HealthReport, 1)
+	go s.exec(func() {
+		done <- HealthReport{
+			Sessions:  len(s.sessions),
+			LastSweep: s.lastSweep,
+		}
+	})
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case got := <-done:
+		r.Sessions, r.LastSweep = got.Sessions, got.LastSweep
+		r.Latency = time.Since(start)
+		return r, nil
+	case <-t.C:
+		return r, fmt.Errorf("%s: %w", fname, ErrUnresponsive)
+	}
+}
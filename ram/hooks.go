@@ -0,0 +1,168 @@
+package ram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OnExpire sets a function to be called with the SID of every session
+// that is destroyed for having timed out. The function is called from
+// within the session server, as such it must not itself use the store.
+// The previous function is returned.
+func (s *Store) OnExpire(fn func(sid uuid.UUID)) (previous func(uuid.UUID)) {
+	s.exec(func() {
+		previous = s.onExpire
+		s.onExpire = fn
+	})
+	return
+}
+
+// OnExpireBatch sets a function to be called once by each timeout
+// verification with the SIDs of all of the sessions that it found to have
+// timed out, rather than once for each, as for consumers that would be
+// overwhelmed by a large sweep. A session that is found to have expired
+// outside of a sweep is reported alone. It may be set alongside
+// OnExpire. The function is called from within the session server, as
+// such it must not itself use the store. The previous function is
+// returned.
+func (s *Store) OnExpireBatch(fn func(sids []uuid.UUID)) (previous func([]uuid.UUID)) {
+	s.exec(func() {
+		previous = s.onExpireBatch
+		s.onExpireBatch = fn
+	})
+	return
+}
+
+// OnEvict sets a function to be called with the SID of every session
+// that is evicted to make room in the store, see CapacityPolicy. The
+// function is called from within the session server, as such it must
+// not itself use the store. The previous function is returned.
+func (s *Store) OnEvict(fn func(sid uuid.UUID)) (previous func(uuid.UUID)) {
+	s.exec(func() {
+		previous = s.onEvict
+		s.onEvict = fn
+	})
+	return
+}
+
+// OnEvictBatch sets a function to be called once for each creation or
+// update that evicts sessions to make room, with the SIDs of all of the
+// sessions so evicted. It may be set alongside OnEvict. The function is
+// called from within the session server, as such it must not itself use
+// the store. The previous function is returned.
+func (s *Store) OnEvictBatch(fn func(sids []uuid.UUID)) (previous func([]uuid.UUID)) {
+	s.exec(func() {
+		previous = s.onEvictBatch
+		s.onEvictBatch = fn
+	})
+	return
+}
+
+// hold collects the SIDs for the batch functions until the returned
+// function is called, which then calls them. Holds may nest, only the
+// outermost calls the functions. This function is to be run only by the
+// sessionServer function.
+func (s *Store) hold() (release func()) {
+	if s.holding {
+		return func() {}
+	}
+	s.holding = true
+	return func() {
+		s.holding = false
+		s.notify()
+	}
+}
+
+// notify calls the batch functions with any SIDs collected for them.
+// This function is to be run only by the sessionServer function.
+func (s *Store) notify() {
+	expired, evicted := s.expiredBatch, s.evictedBatch
+	s.expiredBatch, s.evictedBatch = nil, nil
+	if len(expired) > 0 && s.onExpireBatch != nil {
+		s.onExpireBatch(expired)
+	}
+	if len(evicted) > 0 && s.onEvictBatch != nil {
+		s.onEvictBatch(evicted)
+	}
+}
+
+// Expire destroys the session as though it had timed out, rather than
+// been destroyed, such that the OnExpire function is called, the
+// session is recorded as having expired and its later use returns an
+// error matching ErrTimedOut. ErrNoSession is returned if there is no
+// such session.
+func (s *Store) Expire(sid uuid.UUID) (err error) {
+	const fname = "Store.Expire"
+	if invalid(sid) {
+		return fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	s.exec(func() {
+		if _, ok := s.sessions[sid]; !ok {
+			err = s.goneErr(sid)
+			return
+		}
+		s.expire(sid, fname)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// expire destroys a session that has timed out. This function is to be
+// run only by the sessionServer function.
+func (s *Store) expire(key uuid.UUID, sender string) {
+	// The session may have gone with its parent.
+	if _, ok := s.sessions[key]; !ok {
+		return
+	}
+	s.record(OpExpire, key, "")
+	s.destroy(key, sender)
+	s.bury(key, causeExpired, ReasonExpired)
+	s.stats.Expired++
+	if s.onExpire != nil {
+		s.onExpire(key)
+	}
+	if s.onExpireBatch != nil {
+		s.expiredBatch = append(s.expiredBatch, key)
+		if !s.holding {
+			s.notify()
+		}
+	}
+}
+
+// OnNearExpiry sets a function to be called by the timeout verification
+// with the SID of every session that will expire within threshold, such
+// that its user may be warned. The function is called once per session
+// until the session is next used. It is called from within the session
+// server, as such it must not itself use the store. The previous
+// function is returned.
+func (s *Store) OnNearExpiry(threshold time.Duration, fn func(sid uuid.UUID)) (previous func(uuid.UUID)) {
+	s.exec(func() {
+		previous = s.onNear
+		s.onNear = fn
+		s.nearWithin = threshold
+	})
+	return
+}
+
+// warn calls the near expiry function for each session of the sweep,
+// see eachSwept, that will expire within the threshold and has yet to
+// be warned. This function is to be run only by the sessionServer
+// function.
+func (s *Store) warn(batch []uuid.UUID) {
+	if s.onNear == nil {
+		return
+	}
+	now := s.clock.Now()
+	s.eachSwept(batch, func(se Session) {
+		if se.warned || se.frozen || se.pinned || deadline(se).Sub(now) >= s.nearWithin {
+			return
+		}
+		se.warned = true
+		s.sessions[se.id] = se
+		s.onNear(se.id)
+	})
+}
@@ -0,0 +1,132 @@
+package ram
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CursorIterate calls fn for every session in the store, in order of
+// creation, until fn returns false. The ids of the sessions are copied
+// once at the start and each session is then looked up in turn, so the
+// session server is not held for the duration of the scan and fn may
+// itself use the store. Sessions destroyed during the iteration are
+// skipped, their number is returned.
+func (s *Store) CursorIterate(fn func(Session) bool) (skipped int) {
+	var ids []uuid.UUID
+	s.exec(func() {
+		ids = make([]uuid.UUID, 0, len(s.array))
+		s.each(func(se Session) {
+			ids = append(ids, se.id)
+		})
+	})
+	for _, id := range ids {
+		var se Session
+		var ok bool
+		s.exec(func() {
+			se, ok = s.sessions[id]
+		})
+		if !ok {
+			skipped++
+			continue
+		}
+		if !fn(se) {
+			break
+		}
+	}
+	return
+}
+
+// Aggregate folds fn over the data of every session in the store, in
+// order of creation, starting from seed and returning the final value
+// of the accumulator, such as to count the sessions that share some
+// value without copying every session out of the store. Each call is
+// given a copy of the sessions data. As fn is run by the session server
+// it must not itself use the store.
+func (s *Store) Aggregate(fn func(acc interface{}, data map[string]interface{}) interface{}, seed interface{}) interface{} {
+	acc := seed
+	s.exec(func() {
+		s.each(func(se Session) {
+			acc = fn(acc, se.snapshot().Data)
+		})
+	})
+	return acc
+}
+
+// ModifiedSince returns the SIDs of the sessions last used at or after
+// t, in order of creation or as given by, such as for periodic exports
+// of the sessions that have changed.
+func (s *Store) ModifiedSince(t time.Time, by ...SortBy) (sids []uuid.UUID) {
+	var ses []Session
+	s.exec(func() {
+		s.each(func(se Session) {
+			if !se.modified.Before(t) {
+				ses = append(ses, se)
+			}
+		})
+	})
+	if len(by) > 0 {
+		sortSessions(ses, by[0])
+	}
+	for _, se := range ses {
+		sids = append(sids, se.id)
+	}
+	return
+}
+
+// SortBy is the order in which sessions are enumerated.
+type SortBy int
+
+const (
+	// Unsorted leaves the sessions in the order in which the store
+	// holds them, it is the fastest.
+	Unsorted SortBy = iota
+	CreatedAsc
+	CreatedDesc
+	ModifiedAsc
+	ModifiedDesc
+)
+
+// IDs returns the SIDs of every session in the store, sorted as given
+// by, if at all.
+func (s *Store) IDs(by ...SortBy) []uuid.UUID {
+	order := Unsorted
+	if len(by) > 0 {
+		order = by[0]
+	}
+	var ses []Session
+	s.exec(func() {
+		ses = make([]Session, 0, len(s.array))
+		s.each(func(se Session) {
+			ses = append(ses, se)
+		})
+	})
+	sortSessions(ses, order)
+	ids := make([]uuid.UUID, len(ses))
+	for i, se := range ses {
+		ids[i] = se.id
+	}
+	return ids
+}
+
+// sortSessions sorts the sessions in the given order, those that are
+// equal keep their relative order.
+func sortSessions(ses []Session, by SortBy) {
+	var less func(a, b Session) bool
+	switch by {
+	case CreatedAsc:
+		less = func(a, b Session) bool { return a.created.Before(b.created) }
+	case CreatedDesc:
+		less = func(a, b Session) bool { return a.created.After(b.created) }
+	case ModifiedAsc:
+		less = func(a, b Session) bool { return a.modified.Before(b.modified) }
+	case ModifiedDesc:
+		less = func(a, b Session) bool { return a.modified.After(b.modified) }
+	default:
+		return
+	}
+	sort.SliceStable(ses, func(i, j int) bool {
+		return less(ses[i], ses[j])
+	})
+}
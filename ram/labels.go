@@ -0,0 +1,140 @@
+package ram
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// defaultLabelValues is the number of values counted for each label
+// key, when Config.MaxLabelValues is not set.
+const defaultLabelValues = 100
+
+// LabelOther is the value under which are counted the values of a label
+// key beyond the first Config.MaxLabelValues.
+const LabelOther = "other"
+
+// Labels partition the counts of the store by request, as by route or
+// user tier, see WithLabels.
+type Labels map[string]string
+
+// String returns the labels as sorted key=value pairs separated by
+// commas, the form in which StatsByLabels keys its counts.
+func (l Labels) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// labelsKey is the context key of the labels of a request.
+type labelsKey struct{}
+
+// WithLabels returns a copy of ctx carrying labels, by which the context
+// aware methods of a store, CreateContext and RestoreContext, count
+// their calls. Only the keys given to the store as Config.LabelKeys are
+// counted.
+func WithLabels(ctx context.Context, labels Labels) context.Context {
+	return context.WithValue(ctx, labelsKey{}, labels)
+}
+
+// LabelsFrom returns the labels carried by ctx, nil if there are none.
+func LabelsFrom(ctx context.Context) Labels {
+	l, _ := ctx.Value(labelsKey{}).(Labels)
+	return l
+}
+
+// LabelStats are the counts of the calls made with a set of labels.
+type LabelStats struct {
+	// Created counts the sessions created.
+	Created uint64
+	// Restored counts the sessions restored.
+	Restored uint64
+	// Missed counts the restores of sessions that were not live.
+	Missed uint64
+}
+
+// StatsByLabels returns the counts of the calls made with labels, keyed
+// by the String of the labels counted.
+func (s *Store) StatsByLabels() (m map[string]LabelStats) {
+	s.exec(func() {
+		m = make(map[string]LabelStats, len(s.labelled))
+		for k, st := range s.labelled {
+			m[k] = st
+		}
+	})
+	return
+}
+
+// labelKeys returns the set of label keys that are counted.
+func labelKeys(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
+
+// countLabels counts the call op, of outcome err, against the labels of
+// ctx. Nothing is done without labels to count.
+func (s *Store) countLabels(ctx context.Context, op string, err error) {
+	if s.labelKeys == nil {
+		return
+	}
+	labels := LabelsFrom(ctx)
+	if len(labels) == 0 {
+		return
+	}
+	s.exec(func() {
+		key := s.bound(labels).String()
+		st := s.labelled[key]
+		switch {
+		case op == "Create" && err == nil:
+			st.Created++
+		case op == "Restore" && err == nil:
+			st.Restored++
+		case op == "Restore":
+			st.Missed++
+		default:
+			return
+		}
+		if s.labelled == nil {
+			s.labelled = make(map[string]LabelStats)
+		}
+		s.labelled[key] = st
+	})
+}
+
+// bound returns the labels of the counted keys, those values of a key
+// beyond its limit replaced by LabelOther. This function is to be run
+// only by the sessionServer function.
+func (s *Store) bound(labels Labels) Labels {
+	if s.labelSeen == nil {
+		s.labelSeen = make(map[string]map[string]bool)
+	}
+	b := make(Labels, len(labels))
+	for k, v := range labels {
+		if !s.labelKeys[k] {
+			continue
+		}
+		seen := s.labelSeen[k]
+		if seen == nil {
+			seen = make(map[string]bool)
+			s.labelSeen[k] = seen
+		}
+		if !seen[v] {
+			if len(seen) >= s.labelValues {
+				v = LabelOther
+			} else {
+				seen[v] = true
+			}
+		}
+		b[k] = v
+	}
+	return b
+}
@@ -0,0 +1,69 @@
+package ram
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+// ErrBusy is returned by Create when the store is at its limit of
+// concurrent creation.
+var ErrBusy = errs.New(errs.Busy, "session store busy")
+
+// slots is a semaphore, the nil value imposes no limit.
+type slots chan struct{}
+
+// try takes a slot if one is free, returning false if not.
+func (sl slots) try() bool {
+	if sl == nil {
+		return true
+	}
+	select {
+	case sl <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire takes a slot, waiting for one to be freed until ctx is done.
+func (sl slots) acquire(ctx context.Context) error {
+	if sl == nil {
+		return nil
+	}
+	select {
+	case sl <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken by try or acquire.
+func (sl slots) release() {
+	if sl != nil {
+		<-sl
+	}
+}
+
+// CreateContext is Create, save that if the store is at its limit of
+// concurrent creation it waits for a call to complete, returning the
+// error of ctx if it is done first. Should ctx be done whilst the server
+// has the request the error of ctx is returned, though the session may
+// yet be created. The call is traced as a child of the
+// span of ctx when the store has a Tracer, and counted by the labels of
+// ctx, see WithLabels.
+func (s *Store) CreateContext(ctx context.Context, sid uuid.UUID, maxage int, opts ...CreateOption) (se Session, err error) {
+	const fname = "Store.CreateContext"
+	if end := s.span(ctx, "Create", sid); end != nil {
+		defer func() { end(err) }()
+	}
+	defer func() { s.countLabels(ctx, "Create", err) }()
+	if err := s.creates.acquire(ctx); err != nil {
+		return se, fmt.Errorf("%s: %w", fname, err)
+	}
+	defer s.creates.release()
+	return s.createSession(ctx, fname, sid, maxage, opts)
+}
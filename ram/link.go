@@ -0,0 +1,98 @@
+package ram
+
+import (
+	"fmt"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+// ErrCycle is returned by Link when the link would make a session its
+// own ancestor.
+var ErrCycle = errs.New(errs.Cycle, "link would form a cycle")
+
+// Link makes child a child of parent, such that when parent is
+// destroyed, for whatever reason, child is destroyed with it, along
+// with any children of its own. Destroying child leaves parent in
+// place. A session has at most one parent, linking it again moves it.
+// ErrNoSession is returned if either session does not exist and
+// ErrCycle if child is parent or one of its ancestors.
+func (s *Store) Link(parent, child uuid.UUID) (err error) {
+	const fname = "Store.Link"
+	if invalid(parent) || invalid(child) {
+		return fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	s.exec(func() {
+		for _, id := range []uuid.UUID{parent, child} {
+			if _, ok := s.sessions[id]; !ok {
+				err = s.goneErr(id)
+				return
+			}
+		}
+		for id, ok := parent, true; ok; id, ok = s.parents[id] {
+			if id == child {
+				err = ErrCycle
+				return
+			}
+		}
+		old, had := s.parents[child]
+		s.detach(child)
+		s.parents[child] = parent
+		s.children[parent] = append(s.children[parent], child)
+		if err = s.logPut(s.sessions[child], nil); err != nil {
+			s.detach(child)
+			if had {
+				s.parents[child] = old
+				s.children[old] = append(s.children[old], child)
+			}
+			return
+		}
+		s.markDirty(child)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// detach removes the link between the session and its parent. This
+// function is to be run only by the sessionServer function.
+func (s *Store) detach(child uuid.UUID) {
+	parent, ok := s.parents[child]
+	if !ok {
+		return
+	}
+	delete(s.parents, child)
+	kids := s.children[parent]
+	for i, id := range kids {
+		if id == child {
+			kids = append(kids[:i], kids[i+1:]...)
+			break
+		}
+	}
+	if len(kids) == 0 {
+		delete(s.children, parent)
+		return
+	}
+	s.children[parent] = kids
+}
+
+// unlink removes the links of a session that has been removed from the
+// map, removing its children in turn, returning their number. This
+// function is to be run only by the sessionServer function.
+func (s *Store) unlink(key uuid.UUID, sender string) (cascaded int) {
+	s.detach(key)
+	kids := s.children[key]
+	delete(s.children, key)
+	for _, id := range kids {
+		delete(s.parents, id)
+		se, ok := s.sessions[id]
+		if !ok {
+			continue
+		}
+		s.record(OpDestroy, id, "")
+		cascaded += 1 + s.drop(se, sender)
+		s.bury(id, causeDestroyed, ReasonCascade)
+	}
+	return
+}
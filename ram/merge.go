@@ -0,0 +1,279 @@
+package ram
+
+import (
+	"fmt"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+var ErrConflict = errs.New(errs.Conflict, "key present in both sessions")
+var ErrOtherStore = errs.New(errs.OtherStore, "sessions belong to different stores")
+
+// MergeStrategy defines how Merge resolves a key that is present in both
+// sessions.
+type MergeStrategy int
+
+const (
+	// PreferSelf keeps the value of the receiving session.
+	PreferSelf MergeStrategy = iota
+	// PreferOther takes the value of the other session.
+	PreferOther
+	// ErrorOnConflict merges nothing and returns ErrConflict.
+	ErrorOnConflict
+)
+
+// Merge copies the data of other into the session, resolving keys that
+// are present in both according to strategy. The merge is made in one
+// operation of the session server, as such both sessions must belong to
+// the same store. Both sessions are touched, and the merged keys are
+// published to their subscribers. The flash messages of other are not
+// merged. A panic within the merge is returned as an error matching
+// ErrInternal.
+func (s Session) Merge(other Session, strategy MergeStrategy) (err error) {
+	const fname = "Session.Merge"
+	fail := func(err error) error {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	if s.zero() || other.zero() {
+		return fail(ErrInvalidSession)
+	}
+	if s.sto != other.sto {
+		return fail(ErrOtherStore)
+	}
+	gone := s.sto.update(s.id, func(self Session) {
+		oth, othGone := s.sto.use(other.id)
+		if othGone != nil {
+			err = othGone
+			return
+		}
+		if strategy == ErrorOnConflict {
+			for k := range oth.data {
+				if key, ok := k.(string); ok && reserved(key) {
+					continue
+				}
+				if _, ok := self.data[k]; ok {
+					err = fmt.Errorf("key %v: %w", k, ErrConflict)
+					return
+				}
+			}
+		}
+		merge := make(map[string]interface{}, len(oth.data))
+		for k, v := range oth.data {
+			key, ok := k.(string)
+			if !ok || reserved(key) {
+				continue
+			}
+			if _, ok := self.data[key]; ok && strategy == PreferSelf {
+				continue
+			}
+			merge[key] = v
+		}
+		err = s.sto.putMany(self, merge)
+	})
+	if gone != nil {
+		err = gone
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return
+}
+
+// MoveKey moves the value paired with key from the session src to the
+// session dst in one operation of the session server, such that it is
+// never held by both nor by neither. ErrNoData is returned if src does
+// not hold the key, in which case dst is unchanged, and an error
+// matching ErrNoSession if either session does not exist. Both sessions
+// are touched.
+func (s *Store) MoveKey(src, dst uuid.UUID, key string) (err error) {
+	const fname = "Store.MoveKey"
+	if invalid(src) || invalid(dst) {
+		return fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	key = s.normalize(key)
+	if reserved(key) {
+		return fmt.Errorf("%s: %w", fname, ErrReservedKey)
+	}
+	s.exec(func() {
+		from, fromGone := s.use(src)
+		to, toGone := s.use(dst)
+		if fromGone != nil {
+			err = fromGone
+			return
+		}
+		if toGone != nil {
+			err = toGone
+			return
+		}
+		v, ok := from.data[key]
+		if !ok {
+			err = ErrNoData
+			return
+		}
+		if src == dst {
+			return
+		}
+		if s.tooLarge(v) {
+			err = ErrValueTooLarge
+			return
+		}
+		delta := sizeOf(key, v)
+		if old, ok := to.data[key]; ok {
+			delta -= sizeOf(key, old)
+		}
+		// The key leaves src, neither session is evicted to make room.
+		if !s.fit(delta-sizeOf(key, v), dst, src) {
+			err = ErrCapacity
+			return
+		}
+		err = s.logPut(to, func(data map[string]interface{}) {
+			data[key] = v
+		})
+		if err != nil {
+			return
+		}
+		err = s.logPut(from, func(data map[string]interface{}) {
+			delete(data, key)
+		})
+		if err != nil {
+			// Put dst back as it was, such that the log holds
+			// neither change.
+			s.logPut(to, nil)
+			return
+		}
+		to.data[key] = v
+		s.resize(dst, delta)
+		s.publish(dst, key, v)
+		s.record(OpSet, dst, key)
+		delete(from.data, key)
+		s.resize(src, -sizeOf(key, v))
+		s.record(OpDel, src, key)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// SwapData exchanges the data of the sessions a and b in one operation
+// of the session server, such that no other operation sees the data of
+// either in both or in neither. Only the data moves, the SIDs,
+// timestamps, owners and all else of the sessions stay with them and
+// neither is touched. An error matching ErrNoSession is returned if
+// either session is not live, in which case neither is changed.
+func (s *Store) SwapData(a, b uuid.UUID) (err error) {
+	const fname = "Store.SwapData"
+	if invalid(a) || invalid(b) {
+		return fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	s.exec(func() {
+		for _, sid := range []uuid.UUID{a, b} {
+			if !s.live(sid) {
+				err = s.goneErr(sid)
+				return
+			}
+		}
+		if a == b {
+			return
+		}
+		sa, sb := s.sessions[a], s.sessions[b]
+		sa.data, sb.data = sb.data, sa.data
+		sa.size, sb.size = sb.size, sa.size
+		if err = s.logPut(sa, nil); err != nil {
+			return
+		}
+		if err = s.logPut(sb, nil); err != nil {
+			// Put a back as it was, such that the log holds
+			// neither change.
+			s.logPut(s.sessions[a], nil)
+			return
+		}
+		s.sessions[a], s.sessions[b] = sa, sb
+		for _, se := range []Session{sa, sb} {
+			s.markDirty(se.id)
+			s.record(OpSet, se.id, "")
+			for k, v := range se.data {
+				if key, ok := k.(string); ok {
+					s.publish(se.id, key, v)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// RenameKey moves the value paired with oldKey to newKey within the
+// session in one operation of the session server, such that no other
+// operation sees it under both or neither. ErrNoData is returned if
+// oldKey is absent and ErrConflict if newKey is already present, see
+// ReplaceKey to overwrite it. The session is touched.
+func (s Session) RenameKey(oldKey, newKey string) error {
+	return s.rename("Session.RenameKey", oldKey, newKey, false)
+}
+
+// ReplaceKey is RenameKey, overwriting any value paired with newKey.
+func (s Session) ReplaceKey(oldKey, newKey string) error {
+	return s.rename("Session.ReplaceKey", oldKey, newKey, true)
+}
+
+// rename moves the value of oldKey to newKey, overwriting any value of
+// newKey only if overwrite is set.
+func (s Session) rename(fname, oldKey, newKey string, overwrite bool) (err error) {
+	fail := func(err error) error {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	if s.zero() {
+		return fail(ErrInvalidSession)
+	}
+	oldKey, newKey = s.sto.normalize(oldKey), s.sto.normalize(newKey)
+	if reserved(oldKey) || reserved(newKey) {
+		return fail(ErrReservedKey)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		v, ok := se.data[oldKey]
+		if !ok {
+			err = ErrNoData
+			return
+		}
+		if oldKey == newKey {
+			return
+		}
+		prev, exists := se.data[newKey]
+		if exists && !overwrite {
+			err = fmt.Errorf("key %v: %w", newKey, ErrConflict)
+			return
+		}
+		delta := sizeOf(newKey, v) - sizeOf(oldKey, v)
+		if exists {
+			delta -= sizeOf(newKey, prev)
+		}
+		if !s.sto.fit(delta, s.id) {
+			err = ErrCapacity
+			return
+		}
+		err = s.sto.logPut(se, func(data map[string]interface{}) {
+			delete(data, oldKey)
+			data[newKey] = v
+		})
+		if err != nil {
+			return
+		}
+		delete(se.data, oldKey)
+		se.data[newKey] = v
+		s.sto.resize(s.id, delta)
+		s.sto.publish(s.id, newKey, v)
+		s.sto.record(OpSet, s.id, newKey)
+		s.sto.record(OpDel, s.id, oldKey)
+	})
+	if gone != nil {
+		return fail(gone)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return
+}
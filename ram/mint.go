@@ -0,0 +1,115 @@
+package ram
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// mintAttempts is the number of ids that New tries before giving up.
+const mintAttempts = 3
+
+// IDSource provides the store with new session ids.
+type IDSource interface {
+	New() uuid.UUID
+}
+
+// randomSource is the default id source, it returns random uuids.
+type randomSource struct{}
+
+// New returns a new random uuid.
+func (randomSource) New() uuid.UUID {
+	return uuid.New()
+}
+
+// V7Source is an id source that returns time ordered version 7 uuids,
+// which sort by their time of creation, such that a backend that keys
+// its sessions in a tree writes them close to one another rather than
+// across the whole of its key space. Those minted within the same
+// millisecond, or should the clock step back, are kept in order by
+// counting on from the last. The zero value is ready for use, it is
+// safe for concurrent use.
+type V7Source struct {
+	// Clock gives the time of the ids, by default the system time.
+	Clock Clock
+
+	mu   sync.Mutex
+	last uuid.UUID
+}
+
+// New returns a new version 7 uuid, greater in byte order than the last.
+func (v *V7Source) New() uuid.UUID {
+	var id uuid.UUID
+	if _, err := io.ReadFull(rand.Reader, id[6:]); err != nil {
+		panic(err)
+	}
+	var now time.Time
+	if v.Clock != nil {
+		now = v.Clock.Now()
+	} else {
+		now = time.Now()
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixNano()/int64(time.Millisecond)))
+	copy(id[:6], ms[2:])
+	id[6] = id[6]&0x0f | 0x70 // version 7
+	id[8] = id[8]&0x3f | 0x80 // variant RFC 4122
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if bytes.Compare(id[:], v.last[:]) <= 0 {
+		id = v.last
+		next(&id)
+	}
+	v.last = id
+	return id
+}
+
+// next increments the random bits that follow the variant of the
+// version 7 uuid, as a counter.
+func next(id *uuid.UUID) {
+	for i := 15; i > 8; i-- {
+		id[i]++
+		if id[i] != 0 {
+			return
+		}
+	}
+	id[8] = id[8]&0xc0 | (id[8]+1)&0x3f
+}
+
+// IDSource sets the source of the ids minted by New, the default being
+// random version 4 uuids, see V7Source for time ordered ids. The previous source is returned.
+func (s *Store) IDSource(src IDSource) (previous IDSource) {
+	s.exec(func() {
+		previous = s.ids
+		s.ids = src
+	})
+	return
+}
+
+// New creates a session with a newly minted id, should the id already
+// be in use another is tried.
+func (s *Store) New(maxage int, opts ...CreateOption) (se Session, err error) {
+	const fname = "Store.New"
+	var ids IDSource
+	s.exec(func() {
+		ids = s.ids
+	})
+	for i := 0; i < mintAttempts; i++ {
+		se, err = s.Create(ids.New(), maxage, opts...)
+		if !errors.Is(err, ErrExists) {
+			break
+		}
+	}
+	if err != nil {
+		return se, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
@@ -0,0 +1,24 @@
+package ram
+
+// normalize returns the canonical form of key, as given by the
+// NormalizeKey function of the store, or key itself if it has none.
+func (s *Store) normalize(key string) string {
+	if s.normKey == nil {
+		return key
+	}
+	return s.normKey(key)
+}
+
+// normalizeKeys returns pairs with every key normalised, pairs itself
+// if the store has no NormalizeKey function. Should two keys normalise
+// to the same key either value may be kept.
+func (s *Store) normalizeKeys(pairs map[string]interface{}) map[string]interface{} {
+	if s.normKey == nil {
+		return pairs
+	}
+	norm := make(map[string]interface{}, len(pairs))
+	for k, v := range pairs {
+		norm[s.normKey(k)] = v
+	}
+	return norm
+}
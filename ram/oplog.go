@@ -0,0 +1,110 @@
+package ram
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OpKind identifies an operation upon a session.
+type OpKind int
+
+const (
+	OpCreate OpKind = iota
+	OpRestore
+	OpDestroy
+	OpExpire
+	OpEvict
+	OpSet
+	OpGet
+	OpDel
+	OpPop
+)
+
+// String returns the name of the operation.
+func (k OpKind) String() string {
+	switch k {
+	case OpCreate:
+		return "Create"
+	case OpRestore:
+		return "Restore"
+	case OpDestroy:
+		return "Destroy"
+	case OpExpire:
+		return "Expire"
+	case OpEvict:
+		return "Evict"
+	case OpSet:
+		return "Set"
+	case OpGet:
+		return "Get"
+	case OpDel:
+		return "Del"
+	case OpPop:
+		return "Pop"
+	}
+	return "unknown"
+}
+
+// Op is a record of an operation made upon a session. Values are never
+// recorded, only the key concerned if any.
+type Op struct {
+	Kind OpKind
+	SID  uuid.UUID
+	Key  string
+	Time time.Time
+}
+
+// opLog is a ring of the most recent operations, n of which are held.
+type opLog struct {
+	ops  []Op
+	next int
+	n    int
+	keep time.Duration
+}
+
+// record adds an operation to the stores operation log, if it keeps
+// one. This function is to be run only by the sessionServer function.
+func (s *Store) record(kind OpKind, sid uuid.UUID, key string) {
+	l := &s.opLog
+	if len(l.ops) == 0 {
+		return
+	}
+	l.ops[l.next] = Op{Kind: kind, SID: sid, Key: key,
+		Time: s.clock.Now()}
+	l.next = (l.next + 1) % len(l.ops)
+	if l.n < len(l.ops) {
+		l.n++
+	}
+}
+
+// oldest returns the position of the oldest operation held.
+func (l *opLog) oldest() int {
+	return (l.next - l.n + len(l.ops)) % len(l.ops)
+}
+
+// reap forgets the operations made before cutoff.
+func (l *opLog) reap(cutoff time.Time) {
+	for l.n > 0 && l.ops[l.oldest()].Time.Before(cutoff) {
+		l.n--
+	}
+}
+
+// RecentOps returns the most recent operations made upon the stores
+// sessions, oldest first, up to the number set by Config.RecordOps and
+// no older than Config.OpRetention, if it is set. Creation,
+// restoration, destruction for any reason, and the Set, Get, Del and Pop
+// of single keys are recorded.
+func (s *Store) RecentOps() (ops []Op) {
+	s.exec(func() {
+		l := &s.opLog
+		if l.n == 0 {
+			return
+		}
+		for i, j := 0, l.oldest(); i < l.n; i++ {
+			ops = append(ops, l.ops[j])
+			j = (j + 1) % len(l.ops)
+		}
+	})
+	return
+}
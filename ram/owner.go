@@ -0,0 +1,152 @@
+package ram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+// ErrNotOwner is returned when a session belongs to a user other than
+// the one given.
+var ErrNotOwner = errs.New(errs.NotOwner, "session not owned by user")
+
+// SetOwner tags the session as belonging to the user userID, an empty
+// id removes the tag.
+func (s Session) SetOwner(userID string) (err error) {
+	const fname = "Session.SetOwner"
+	if s.zero() {
+		return fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		se.owner = userID
+		if err = s.sto.logPut(se, nil); err != nil {
+			return
+		}
+		s.sto.sessions[se.id] = se
+		s.sto.markDirty(se.id)
+	})
+	if gone != nil {
+		return fmt.Errorf("%s: %w", fname, gone)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// Owner returns the id of the user to whom the session belongs, empty
+// if it has not been tagged.
+func (s Session) Owner() (userID string, err error) {
+	const fname = "Session.Owner"
+	if s.zero() {
+		return "", fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		userID = se.owner
+	})
+	if gone != nil {
+		return "", fmt.Errorf("%s: %w", fname, gone)
+	}
+	return
+}
+
+// VerifyOwner reports whether the session sid exists and belongs to the
+// user userID, in one operation of the session server. Unlike Restore
+// it does not touch the session, such that authorisation checks do not
+// extend its life. ErrNoSession is returned if the session does not
+// exist and ErrNotOwner if it belongs to another user.
+func (s *Store) VerifyOwner(sid uuid.UUID, userID string) (ok bool, err error) {
+	const fname = "Store.VerifyOwner"
+	if invalid(sid) {
+		return false, fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	s.exec(func() {
+		se, live := s.sessions[sid]
+		if live && s.expired(se) {
+			s.expire(sid, fname)
+			live = false
+		}
+		switch {
+		case !live:
+			err = s.goneErr(sid)
+		case se.owner != userID:
+			err = ErrNotOwner
+		default:
+			ok = true
+		}
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// CreateExclusive creates a session for the given SID tagged as
+// belonging to the user userID and destroys every other session that
+// belongs to that user, returning their SIDs so that the user may be
+// told of it. The creation and the destruction happen in one operation
+// of the session server; should the creation fail no session is
+// destroyed. The sessions of the user are counted as gone when making
+// room for the new one, such that a store at capacity does not refuse
+// it. Should the destruction of a prior session fail to be logged, the
+// new session is returned with the SIDs destroyed until then and the
+// error.
+func (s *Store) CreateExclusive(sid uuid.UUID, maxage int, userID string, opts ...CreateOption) (se Session, prior []uuid.UUID, err error) {
+	const fname = "Store.CreateExclusive"
+	fail := func(err error) (Session, []uuid.UUID, error) {
+		return Session{}, nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if invalid(sid) {
+		return fail(ErrPoorForm)
+	}
+	var o createOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var destroyed []uuid.UUID
+	s.exec(func() {
+		var owned []uuid.UUID
+		for _, id := range s.array {
+			if id == uuid.Nil || id == sid {
+				continue
+			}
+			if old, ok := s.sessions[id]; ok && old.owner == userID {
+				owned = append(owned, id)
+			}
+		}
+		c := command{
+			cmd:      create,
+			key:      sid,
+			maxage:   time.Duration(maxage) * time.Second,
+			seStore:  s,
+			data:     o.data,
+			until:    o.until,
+			owner:    userID,
+			replaced: owned,
+		}
+		se, err = c.create()
+		if err != nil {
+			return
+		}
+		for _, id := range owned {
+			// A session may have gone with a linked parent.
+			if _, ok := s.sessions[id]; ok {
+				if err = s.logDestroy(id); err != nil {
+					return
+				}
+				s.record(OpDestroy, id, "")
+				s.destroy(id, fname)
+			}
+			destroyed = append(destroyed, id)
+		}
+	})
+	if err != nil {
+		if se.zero() {
+			return fail(err)
+		}
+		return se, destroyed, fmt.Errorf("%s: %w", fname, err)
+	}
+	return se, destroyed, nil
+}
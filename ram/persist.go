@@ -0,0 +1,157 @@
+package ram
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Persister is the backing store of the write-behind mode of a store,
+// it may write the snapshots it is given with any encoding to any
+// medium.
+type Persister interface {
+	// Save writes the snapshots, replacing any earlier snapshot of the
+	// same SID.
+	Save(snaps []Snapshot) error
+	// Delete removes the snapshots of the SIDs, those that it does not
+	// hold are ignored.
+	Delete(sids []uuid.UUID) error
+	// Load returns every snapshot held.
+	Load() ([]Snapshot, error)
+}
+
+// markDirty records that the session has changed since it was last
+// flushed. This function is to be run only by the sessionServer
+// function.
+func (s *Store) markDirty(sid uuid.UUID) {
+	if s.persister == nil {
+		return
+	}
+	s.dirty[sid] = struct{}{}
+	delete(s.deleted, sid)
+}
+
+// markDeleted records that the session has been removed since the last
+// flush. This function is to be run only by the sessionServer function.
+func (s *Store) markDeleted(sid uuid.UUID) {
+	if s.persister == nil {
+		return
+	}
+	delete(s.dirty, sid)
+	s.deleted[sid] = struct{}{}
+}
+
+// collect returns a snapshot of every session changed, and the SID of
+// every session removed, since the last flush, clearing the records of
+// both. This function is to be run only by the sessionServer function.
+func (s *Store) collect() (snaps []Snapshot, deleted []uuid.UUID) {
+	for id := range s.dirty {
+		if se, ok := s.sessions[id]; ok {
+			snaps = append(snaps, se.snapshot())
+		}
+	}
+	for id := range s.deleted {
+		deleted = append(deleted, id)
+	}
+	s.dirty = make(map[uuid.UUID]struct{})
+	s.deleted = make(map[uuid.UUID]struct{})
+	return
+}
+
+// Flush writes every session changed since the last flush to the
+// Persister of the store and deletes those removed, it is otherwise
+// done every FlushInterval and on Close. The snapshots are taken in one
+// operation of the session server and written outside of it, one flush
+// at a time. Should the write fail the sessions are flushed again next
+// time.
+func (s *Store) Flush() error {
+	if s.persister == nil {
+		return nil
+	}
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+	var snaps []Snapshot
+	var deleted []uuid.UUID
+	s.exec(func() {
+		snaps, deleted = s.collect()
+	})
+	return s.write(snaps, deleted)
+}
+
+// write passes the snapshots and deletions to the Persister, marking
+// them once more should it fail.
+func (s *Store) write(snaps []Snapshot, deleted []uuid.UUID) (err error) {
+	const fname = "Store.write"
+	if len(snaps) > 0 {
+		err = s.persister.Save(snaps)
+	}
+	if err == nil && len(deleted) > 0 {
+		err = s.persister.Delete(deleted)
+	}
+	if err == nil {
+		return nil
+	}
+	if d := s.diag; d != nil {
+		const event = "flush failed"
+		d.Err(err, s.label(), fname, event, "saved", len(snaps),
+			"deleted", len(deleted))
+	}
+	s.exec(func() {
+		if s.closed {
+			return
+		}
+		for _, snap := range snaps {
+			if _, ok := s.sessions[snap.ID]; ok {
+				if _, gone := s.deleted[snap.ID]; !gone {
+					s.dirty[snap.ID] = struct{}{}
+				}
+			}
+		}
+		for _, id := range deleted {
+			if _, ok := s.sessions[id]; !ok {
+				s.deleted[id] = struct{}{}
+			}
+		}
+	})
+	return err
+}
+
+// hydrate imports every session held by the Persister, those that have
+// since expired are left to the timeout verification, or passed over if
+// the store is to RejectExpired, as are those already recovered from the
+// write-ahead log. The error of the load or of an import is returned.
+// This function is to be called only by OpenWith.
+func (s *Store) hydrate() (err error) {
+	snaps, err := s.persister.Load()
+	if err == nil {
+		for _, snap := range lineage(snaps) {
+			_, err = s.Import(snap)
+			if errors.Is(err, ErrTimedOut) || errors.Is(err, ErrExists) {
+				err = nil
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	// What was loaded is already persisted.
+	s.exec(func() {
+		s.collect()
+	})
+	return err
+}
+
+// flushEvery flushes the store every d until stop is closed.
+func (s *Store) flushEvery(d time.Duration, stop chan struct{}) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			s.Flush()
+		}
+	}
+}
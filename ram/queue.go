@@ -0,0 +1,18 @@
+package ram
+
+// defaultQueueSize is the default number of commands that may wait
+// upon the session server before senders block.
+const defaultQueueSize = 64
+
+// QueueDepth returns the number of commands sent to the session server
+// that it has yet to receive. A depth that remains at the capacity of
+// the queue indicates that the server is saturated or wedged.
+func (s *Store) QueueDepth() int {
+	return len(s.commands)
+}
+
+// QueueCapacity returns the number of commands that may wait upon the
+// session server before senders block.
+func (s *Store) QueueCapacity() int {
+	return cap(s.commands)
+}
@@ -1,19 +1,33 @@
 package ram
 
 import (
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/8i8/log"
+	"github.com/8i8/session/errs"
 	"github.com/google/uuid"
 )
 
 const pkg = "session"
-var ErrNoSession = errors.New("session does not exist")
-var ErrPoorForm = errors.New("poorly formed uuid")
-var ErrTimedOut = errors.New("session timed out")
-var ErrNoData = errors.New("data not found in session")
+
+var ErrNoSession = errs.New(errs.NotFound, "session does not exist")
+var ErrExists = errs.New(errs.Exists, "session already exists")
+var ErrPoorForm = errs.New(errs.InvalidID, "poorly formed uuid")
+var ErrTimedOut = errs.New(errs.TimedOut, "session timed out")
+var ErrNoData = errs.New(errs.NoData, "data not found in session")
+var ErrCapacity = errs.New(errs.Capacity, "session store at capacity")
+
+// ErrInvalidSession is returned by the methods of a Session that was
+// never valid, such as the zero value returned along with an error,
+// as opposed to one that has since expired or been destroyed.
+var ErrInvalidSession = errs.New(errs.InvalidSession, "invalid session")
 
 // valueStore is the providrs data storage.
 type valueStore map[interface{}]interface{}
@@ -36,6 +50,7 @@ const (
 	deactivate
 	touch
 	timecheck
+	call
 	exit
 )
 
@@ -44,152 +59,318 @@ type command struct {
 	cmd
 	key     uuid.UUID
 	maxage  time.Duration
-	result  chan Session
+	result  chan result
 	seStore *Store
+	// fn is run by the server on receipt of a call command.
+	fn func()
+	// data is the initial data of a session to be created.
+	data map[string]interface{}
+	// until is the scheduled destruction of a session to be created.
+	until time.Time
+	// owner is the user to whom a session to be created belongs, and
+	// replaced the sessions that it is to take the place of, see
+	// CreateExclusive.
+	owner    string
+	replaced []uuid.UUID
+	// state, if set, holds the freeze, pin, tags and parent to be given
+	// to a session to be created, see Clone.
+	state *Snapshot
+}
+
+// result is the reply of the session server to a command, the session
+// concerned, if any, or the reason that the command failed.
+type result struct {
+	se  Session
+	err error
+	// reaped are the SIDs removed by a timeout verification, for the
+	// SweepSink of the store.
+	reaped []uuid.UUID
 }
 
 // sessionServer responds to requests for sessions either serving or
 // removing them, sessions may be removed either by request or when they
-// timeout through lack of activity.
-func sessionServer(commands chan command) {
+// timeout through lack of activity. It returns on receipt of an exit
+// command, closing done, see Store.send.
+func sessionServer(commands chan command, done chan struct{}) {
+	defer close(done)
 	for c := range commands {
-		switch c.cmd {
-		case create:
-			c.result <- c.create()
-		case activate:
-			c.result <- c.retrieve()
-		case deactivate:
-			c.destroy()
-			c.result <- Session{}
-		case touch:
-			c.result <- c.touch()
-		case timecheck:
-			c.timeout()
-			c.result <- Session{}
-		default:
-			c.def()
-			c.result <- Session{}
+		c.result <- c.run()
+		if c.cmd == exit {
+			return
 		}
 	}
 }
 
-// create makes a session for the given sid, returning and error if the
-// session already exists, returning and empty session struct if it does
-// not.
-func (c command) create() (s Session) {
+// run carries out the command, returning its result.
+func (c command) run() result {
+	switch c.cmd {
+	case create:
+		se, err := c.create()
+		return result{se: se, err: err}
+	case activate:
+		se, err := c.retrieve()
+		return result{se: se, err: err}
+	case deactivate:
+		return result{err: c.destroy()}
+	case touch:
+		c.seStore.record(OpRestore, c.key, "")
+		se, err := c.touch()
+		return result{se: se, err: err}
+	case timecheck:
+		return result{reaped: c.timeout()}
+	case call:
+		c.fn()
+		return result{}
+	case exit:
+		return result{}
+	default:
+		c.def()
+		return result{}
+	}
+}
+
+// create makes a session for the given sid, returning an error if the
+// session already exists or may not be stored. A panic from within a
+// BeforeCreate function or SessionFactory is returned as an error
+// matching ErrInternal.
+func (c command) create() (s Session, err error) {
 	const fname = "create"
-	_, exists := c.seStore.sessions[c.key]
-	if exists {
-		if log.Is(log.DEBUG) {
-			const event = "Session already in use"
-			log.Debug(nil, pkg, fname, event,
-				"SID", c.key)
-		}
-		return Session{}
+	if !c.seStore.noRecover {
+		defer c.seStore.recoverInto(&err, c.key)
 	}
+	now := c.seStore.clock.Now()
 	s = Session{
 		id:       c.key,
-		data:     make(valueStore),
-		created:  time.Now(),
-		modified: time.Now(),
-		index:    c.seStore.index,
+		data:     make(valueStore, len(c.data)),
+		created:  now,
+		modified: now,
 		sto:      c.seStore,
 		maxage:   c.maxage,
+		until:    c.until,
+		owner:    c.owner,
 		active:   true,
 	}
-	// If the maxage is not sane, set to half the stores timeout
-	// period.
+	if c.state != nil {
+		s.frozen, s.pinned = c.state.Frozen, c.state.Pinned
+	}
+	// If the maxage is not sane, use the stores default maxage or,
+	// if that is not set, half the stores timeout period.
 	if c.maxage <= 0 {
+		s.maxage = c.seStore.defaultMaxAge
+	}
+	if s.maxage <= 0 {
 		s.maxage = c.seStore.period / divisor
 	}
-	c.seStore.sessions[c.key] = s
-	// Add SID to array and augment index tally.
-	c.seStore.array = append(c.seStore.array, c.key)
-	c.seStore.index++
-	if log.Is(log.DEBUG) {
+	// A session that would be born expired is refused.
+	if c.seStore.expired(s) {
+		err = ErrTimedOut
+	}
+	if err == nil {
+		err = c.seStore.gate(c.key, c.data)
+	}
+	if err == nil && c.state != nil && c.seStore.taken(c.key) {
+		err = ErrExists
+	}
+	if err == nil {
+		for k, v := range c.seStore.prepare(c.key, c.data) {
+			k = c.seStore.normalize(k)
+			s.data[k] = v
+			s.size += sizeOf(k, v)
+		}
+		if c.state != nil {
+			c.seStore.adopt(c.key, c.state.Tags, c.state.Parent)
+		}
+		s, err = c.seStore.replace(s, c.replaced...)
+		if err != nil && c.state != nil {
+			c.seStore.disown(c.key)
+		}
+	}
+	if err != nil {
+		if d := c.seStore.diag; d != nil {
+			const event = "Session not created"
+			d.Debug(nil, c.seStore.label(), fname, event, "SID", c.key,
+				"err", err)
+		}
+		return Session{}, err
+	}
+	c.seStore.record(OpCreate, c.key, "")
+	if d := c.seStore.diag; d != nil {
 		const event = "Session created"
-		log.Debug(nil, pkg, fname, event, "SID", c.key)
+		d.Debug(nil, c.seStore.label(), fname, event, "SID", c.key)
 	}
-	return s
+	return s, nil
 }
 
 // retrieve returns an active session if one exists for the given sid,
-// returning and empty session struct if it does not.
-func (c command) retrieve() (s Session) {
+// returning the reason if it does not.
+func (c command) retrieve() (s Session, err error) {
 	const fname = "activate"
 	s, ok := c.seStore.sessions[c.key]
 	if ok {
-		if log.Is(log.DEBUG) {
+		if d := c.seStore.diag; d != nil {
 			const event = "Session restored"
-			log.Debug(nil, pkg, fname, event,
+			d.Debug(nil, c.seStore.label(), fname, event,
 				"SID", c.key)
 		}
 		// Reset maxage, it may have changed.
 		s.maxage = c.maxage
-		return s
+		return s, nil
 	}
-	if log.Is(log.DEBUG) {
+	if d := c.seStore.diag; d != nil {
 		const event = "Session not found"
-		log.Debug(nil, pkg, fname, event, "SID", c.key)
+		d.Debug(nil, c.seStore.label(), fname, event, "SID", c.key)
 	}
-	return Session{}
+	return Session{}, c.seStore.goneErr(c.key)
 }
 
 // destroy destroys the session corresponding to the given sid,
-// logging an error if there is no session to match the key.
-func (c command) destroy() {
+// returning the reason if there is no session to match the key.
+func (c command) destroy() error {
 	const fname = "cmd.destroy"
 	// If the session uuid is valid destroy the session.
 	if _, ok := c.seStore.sessions[c.key]; ok {
+		if err := c.seStore.logDestroy(c.key); err != nil {
+			return err
+		}
+		c.seStore.record(OpDestroy, c.key, "")
 		c.seStore.destroy(c.key, fname)
-		return
+		return nil
 	}
-	if log.Is(log.DEBUG) {
+	if d := c.seStore.diag; d != nil {
 		const event = "no session to destroy"
-		log.Debug(nil, pkg, fname, event, "SID", c.key)
+		d.Debug(nil, c.seStore.label(), fname, event, "SID", c.key)
 	}
+	return c.seStore.goneErr(c.key)
 }
 
 // touch updates the modified time of a session, required as sessions
-// are being passed by value, not by reference.
-func (c command) touch() (s Session) {
+// are being passed by value, not by reference. The reason is returned
+// if the session is not live.
+func (c command) touch() (s Session, err error) {
 	const fname = "cmd.touch"
-	// If there is a session update its time.
+	// If there is a session update its time, unless it has expired
+	// in which case it is destroyed.
 	s, ok := c.seStore.sessions[c.key]
+	if ok && c.seStore.expired(s) {
+		c.seStore.expire(c.key, fname)
+		return Session{}, c.seStore.goneErr(c.key)
+	}
 	if ok {
-		s.modified = time.Now()
+		s.modified = c.seStore.clock.Now()
+		s.warned = false
 		c.seStore.sessions[c.key] = s
-		return s
+		c.seStore.lruTouch(c.key)
+		return s, nil
 	}
-	if log.Is(log.DEBUG) {
+	if d := c.seStore.diag; d != nil {
 		const event = "no session for this key"
-		log.Debug(nil, pkg, fname, event, "SID", c.key)
+		d.Debug(nil, c.seStore.label(), fname, event, "SID", c.key)
 	}
-	return Session{}
+	return Session{}, c.seStore.goneErr(c.key)
 }
 
 // timeout iterates over all of the sessions in the index array,
 // destroying any that have a timeout setting that is less than the
-// difference between now and the last modified time.
-func (c command) timeout() {
+// difference between now and the last modified time. When the store
+// has a SweepSink the SIDs of all of the sessions removed are returned.
+func (c command) timeout() (reaped []uuid.UUID) {
 	const fname = "cmd.timeout"
-	if log.Is(log.DEBUG) {
+	if c.seStore.sink != nil {
+		c.seStore.reaping = true
+		defer func() {
+			reaped = c.seStore.reaped
+			c.seStore.reaping, c.seStore.reaped = false, nil
+		}()
+	}
+	if d := c.seStore.diag; d != nil {
 		const event = "clearing session store"
-		log.Debug(nil, pkg, fname, event)
+		d.Debug(nil, c.seStore.label(), fname, event)
+	}
+	c.seStore.rebase()
+	defer c.seStore.hold()()
+	c.seStore.trim()
+	// The sessions of an incremental sweep, nil for a full sweep.
+	var batch []uuid.UUID
+	defer func() {
+		c.seStore.warn(batch)
+		c.seStore.adapt(batch)
+	}()
+	if c.seStore.sweepBatch > 0 {
+		batch = c.seStore.sweepIncremental(fname)
+		return
+	}
+	if c.seStore.sweepOrder == DeadlineOrder {
+		c.seStore.sweepOrdered(fname)
+		return
 	}
 	for key := range c.seStore.sessions {
 		s := c.seStore.sessions[key]
-		if time.Since(s.modified) > s.maxage {
-			c.seStore.destroy(key, fname)
+		if c.seStore.expired(s) {
+			c.seStore.expire(key, fname)
 		}
 	}
+	return
 }
 
 // def is the default action when the given command is not recognised.
 func (c command) def() {
 	const fname = "cmd.def"
 	const event = "default fall through"
-	log.Fatal(pkg, fname, event, "cmd", c.cmd)
+	if d := c.seStore.diag; d != nil {
+		d.Fatal(c.seStore.label(), fname, event, "cmd", c.cmd)
+	}
+	panic(fmt.Sprintf("%s: %s: %v", fname, event, c.cmd))
+}
+
+// insert adds the session to the store, returning it with its index
+// set, or an error if its SID is already in use or there is no room for
+// it. This function is to be run only by the sessionServer function.
+func (s *Store) insert(se Session) (Session, error) {
+	return s.replace(se)
+}
+
+// replace is insert, for a session that is to take the place of the
+// sessions replaced, which the caller removes once it is in. The room of
+// the sessions replaced is counted as that of the new one, such that
+// none are evicted for the other. This function is to be run only by
+// the sessionServer function.
+func (s *Store) replace(se Session, replaced ...uuid.UUID) (Session, error) {
+	if s.closed {
+		return Session{}, ErrClosed
+	}
+	if _, exists := s.sessions[se.id]; exists {
+		return Session{}, ErrExists
+	}
+	delta := se.size
+	for _, id := range replaced {
+		delta -= s.sessions[id].size
+	}
+	if !s.makeRoom(replaced...) || !s.fit(delta, replaced...) {
+		return Session{}, ErrCapacity
+	}
+	if err := s.logPut(se, nil); err != nil {
+		return Session{}, err
+	}
+	se.index = s.index
+	s.sessions[se.id] = se
+	s.tombs.remove(se.id)
+	s.bytes += se.size
+	s.stats.Created++
+	n := len(s.sessions)
+	for _, id := range replaced {
+		if s.taken(id) {
+			n--
+		}
+	}
+	if n > s.stats.Peak {
+		s.stats.Peak = n
+	}
+	// Add SID to array and augment index tally.
+	s.array = append(s.array, se.id)
+	s.index++
+	s.lruAdd(se.id)
+	s.markDirty(se.id)
+	return se, nil
 }
 
 // destroy removes the session corresponding to the given SID from the
@@ -201,32 +382,89 @@ func (s *Store) destroy(key uuid.UUID, sender string) {
 	// Retrieve the session.
 	se, ok := s.sessions[key]
 	if !ok {
-		if log.Is(log.ERROR) {
+		if d := s.diag; d != nil {
 			const event = "no session found"
-			log.Err(nil, pkg, fname, event, "SID", key,
+			d.Err(nil, s.label(), fname, event, "SID", key,
 				"caller", sender)
 		}
 		return
 	}
 
-	// Remove the SID from the array and diminish the index.
-	s.array = append(s.array[:se.index], s.array[se.index+1:]...)
-	s.index--
+	// Should the index of the session have drifted from its position
+	// in the array, search for the SID rather than removing the wrong
+	// one.
+	i := se.index
+	if i < 0 || i >= len(s.array) || s.array[i] != key {
+		if d := s.diag; d != nil {
+			const event = "inconsistent index"
+			d.Err(nil, s.label(), fname, event, "SID", key,
+				"index", se.index, "caller", sender)
+		}
+		i = -1
+		for j, id := range s.array {
+			if id == key {
+				i = j
+				break
+			}
+		}
+	}
+
+	// Leave a hole in the array, to be closed by a later compaction, or
+	// remove the SID from the array and diminish the index.
+	if i >= 0 && s.compactAt > 0 {
+		s.array[i] = uuid.Nil
+		s.holes++
+	} else if i >= 0 {
+		s.array = append(s.array[:i], s.array[i+1:]...)
+		s.index = len(s.array)
+
+		// Correct the index of all moved sid's.
+		for j := i; j < len(s.array); j++ {
+			moved := s.sessions[s.array[j]]
+			moved.index = j
+			s.sessions[s.array[j]] = moved
+		}
+	}
+	if s.drop(se, sender) > 0 || s.compactAt > 0 && s.holes >= s.compactAt {
+		s.compact()
+	}
+}
+
+// drop removes the session from the map and from the accounting of the
+// store, along with any sessions linked to it as children, returning the
+// number of children removed. The array is left to the caller. This
+// function is to be run only by the sessionServer function.
+func (s *Store) drop(se Session, sender string) (cascaded int) {
+	const fname = "cmd.destroy"
+	key := se.id
 
-	// Correct the index of all moved sid's.
-	for _, uuid := range s.array[se.index:] {
-		se := s.sessions[uuid]
-		se.index--
-		s.sessions[uuid] = se
+	// A removal by the store itself is made whether or not it may be
+	// logged.
+	if err := s.logDel(key); err != nil {
+		if d := s.diag; d != nil {
+			const event = "write-ahead log failed"
+			d.Err(err, s.label(), fname, event, "SID", key)
+		}
 	}
 
 	// Remove the session from the map.
+	s.bytes -= se.size
+	s.stats.Destroyed++
 	delete(s.sessions, key)
-	if log.Is(log.DEBUG) {
+	s.markDeleted(key)
+	s.bury(key, causeDestroyed, ReasonDestroyed)
+	s.lruRemove(key)
+	s.unsubscribeAll(key)
+	s.untagAll(key)
+	if s.reaping {
+		s.reaped = append(s.reaped, key)
+	}
+	if d := s.diag; d != nil {
 		const event = "session destroyed"
-		log.Debug(nil, pkg, fname, event, "SID", key,
+		d.Debug(nil, s.label(), fname, event, "SID", key,
 			"caller", sender)
 	}
+	return s.unlink(key, sender)
 }
 
 // Store contains the session map and array of indices used to track
@@ -237,133 +475,739 @@ type Store struct {
 	index    int
 	period   time.Duration
 	commands chan command
+
+	// The maxage of sessions created without one.
+	defaultMaxAge time.Duration
+
+	// Concurrent creation limit, see limit.go.
+	creates slots
+
+	// The name of the store, see stats.go.
+	name string
+
+	// Recent operations, see oplog.go.
+	opLog opLog
+
+	// Session groups, see link.go.
+	parents  map[uuid.UUID]uuid.UUID
+	children map[uuid.UUID][]uuid.UUID
+
+	// Import of expired snapshots, see snapshot.go.
+	rejectExpired bool
+
+	// Data operations leave the session untouched, see touch.go.
+	noImplicitTouch bool
+
+	// Value check and encoding, see serialize.go.
+	checkValue     func(interface{}) error
+	unserializable UnserializablePolicy
+
+	// Panic recovery, see recover.go.
+	noRecover bool
+	testHook  func()
+
+	// Capacity, see capacity.go.
+	maxSessions int
+	policy      CapacityPolicy
+	lru         *list.List
+	lruElem     map[uuid.UUID]*list.Element
+
+	// Incremental and ordered sweeps, see sweep.go.
+	sweepBatch int
+	sweepPos   int
+	sweepOrder SweepOrder
+
+	// Removals of the sweep, see sweep.go.
+	sink    SweepSink
+	reaping bool
+	reaped  []uuid.UUID
+
+	// Adaptive sweep period, see sweep.go.
+	sweepMin      time.Duration
+	sweepMax      time.Duration
+	sweepPressure int
+	sweepEvery    time.Duration
+
+	// The pending sweep, see sweep.go.
+	sweepFrom  time.Time
+	sweepAt    time.Time
+	sweepMoved bool
+	wake       chan struct{}
+
+	// Expiry, see clock.go.
+	clock     Clock
+	grace     time.Duration
+	lastSweep time.Time
+
+	// Memory accounting, see size.go.
+	bytes    int
+	maxBytes int
+	maxValue int
+
+	// Recently removed sessions, see tombstone.go.
+	tombs     *tombstones
+	tombsKeep time.Duration
+
+	// Id minting, see mint.go.
+	ids IDSource
+
+	// Statistics, see stats.go.
+	stats      Stats
+	statsLast  Stats
+	statsEvery time.Duration
+	statsStop  chan struct{}
+	logger     Logger
+
+	// Counts by request labels, see labels.go.
+	labelKeys   map[string]bool
+	labelValues int
+	labelSeen   map[string]map[string]bool
+	labelled    map[string]LabelStats
+
+	// Diagnostic events, see diag.go.
+	diag Diagnostics
+
+	// Event hooks, see hooks.go.
+	onExpire     func(uuid.UUID)
+	beforeCreate func(uuid.UUID, map[string]interface{}) error
+	factory      SessionFactory
+	onNear       func(uuid.UUID)
+	nearWithin   time.Duration
+
+	// Batched notification, see hooks.go.
+	onExpireBatch func([]uuid.UUID)
+	onEvict       func(uuid.UUID)
+	onEvictBatch  func([]uuid.UUID)
+	holding       bool
+	expiredBatch  []uuid.UUID
+	evictedBatch  []uuid.UUID
+
+	// Write behind, see persist.go.
+	persister Persister
+	dirty     map[uuid.UUID]struct{}
+	deleted   map[uuid.UUID]struct{}
+	flushStop chan struct{}
+	// flushing is held from the collection of a flush until its write,
+	// such that an earlier snapshot is never written over a later one.
+	flushing sync.Mutex
+
+	// Write-ahead log, see wal.go. walDel is a removal already
+	// logged by logDestroy.
+	wal    *wal
+	walDel uuid.UUID
+
+	// Subscriptions to keys of sessions, see subscribe.go.
+	subs map[uuid.UUID]map[string][]*subscription
+
+	// Tags and the sessions that carry them, see tag.go.
+	tagged map[string]map[uuid.UUID]struct{}
+	tagsOf map[uuid.UUID]map[string]struct{}
+
+	// Tracing, see trace.go.
+	tracer Tracer
+
+	// Key normalisation, see normalize.go.
+	normKey func(string) string
+
+	// Lazy removal from the array, see batch.go.
+	compactAt int
+	holes     int
+
+	// Shutdown, see close.go. done is closed once the session server
+	// has stopped, after which stopped serialises the commands that
+	// are run by their callers in its stead.
+	onClose func([]Snapshot)
+	closed  bool
+	done    chan struct{}
+	stopped sync.Mutex
 }
 
 // Init initialises a new ram store.
 func Init() *Store {
-	var cmds = make(chan command)
-	go sessionServer(cmds)
+	return InitWith(Config{})
+}
+
+// Config holds the settings of a store that are to be applied before it
+// is started, the zero value of each field leaves the default in place.
+type Config struct {
+	Period        time.Duration
+	MaxSessions   int
+	Policy        CapacityPolicy
+	MaxBytes      int
+	GracePeriod   time.Duration
+	SweepBatch    int
+	Order         SweepOrder
+	StatsInterval time.Duration
+	Clock         Clock
+	IDSource      IDSource
+	Logger        Logger
+	QueueSize     int
+	DefaultMaxAge time.Duration
+	// Diagnostics receives the debug and error events of the store,
+	// by default there is none and they are discarded.
+	Diagnostics Diagnostics
+	// NoRecover lets a panic within the store crash the program, as
+	// when debugging, rather than being returned as ErrInternal.
+	NoRecover bool
+	// Persister, when set, puts the store in write-behind mode: it is
+	// loaded with the sessions held by the Persister as it starts and
+	// the sessions that have since changed are written back every
+	// FlushInterval, if it is greater than zero, and on Close.
+	Persister     Persister
+	FlushInterval time.Duration
+	// WAL, when set, is the path of a write-ahead log to which every
+	// change to a session is written before it is made, and from
+	// which the sessions are recovered as the store starts, see
+	// wal.go. A change that can not be written is not made, its error
+	// is returned; a removal made by the store itself, on expiry or
+	// eviction, is made regardless. The log is compacted every
+	// WALCompact entries, by default 1000. WALSync has each entry
+	// synced to disk.
+	WAL        string
+	WALCompact int
+	WALSync    bool
+	// TombstoneRetention is the time for which the tombstones of
+	// removed sessions are kept, zero or less keeps them until they
+	// are overwritten by later removals. MaxTombstones is the number
+	// kept, by default 1024.
+	TombstoneRetention time.Duration
+	MaxTombstones      int
+	// Tracer, when set, traces the context aware methods of the store.
+	Tracer Tracer
+	// LabelKeys are the keys of the labels, attached to a context by
+	// WithLabels, by which the context aware methods of the store
+	// count their calls, see StatsByLabels. Labels of other keys are
+	// ignored, such that a key of many values, such as a user id, may
+	// not multiply the counts without bound. MaxLabelValues limits the
+	// number of values counted for each key, by default 100, beyond
+	// which values are counted as LabelOther.
+	LabelKeys      []string
+	MaxLabelValues int
+	// NormalizeKey, when set, is applied to every key given to the
+	// sessions of the store, such that keys differing only in a way
+	// that it removes, as in case, are one and the same.
+	NormalizeKey func(key string) string
+	// CompactAt has destroyed sessions leave a hole in the array of
+	// sessions rather than the array being closed over each at once,
+	// once there are this many holes they are all closed in one pass.
+	// Zero or less closes the array over each session as it goes.
+	CompactAt int
+	// Serializable, when set, is applied to every value as it is
+	// stored by Set or its like, a value for which it returns an error
+	// is refused with an error matching ErrNotSerializable that names
+	// its key. It lets a store that persists its sessions, as by a
+	// Persister or write-ahead log, refuse a value that it could not
+	// write at the call that gave it rather than when writing. See
+	// JSONSerializable and GobSerializable.
+	Serializable func(value interface{}) error
+	// Unserializable is what MarshalJSON does with a value that may
+	// not be encoded as JSON, by default FailFast.
+	Unserializable UnserializablePolicy
+	// RejectExpired has Import refuse, with ErrTimedOut, a snapshot
+	// of a session that has already expired, rather than import it to
+	// be removed by the next timeout verification.
+	RejectExpired bool
+	// NoImplicitTouch has the operations upon the data of a session,
+	// Get, Set, Del and their like, leave it untouched, such that it
+	// expires unless kept alive by Touch, Restore or RenewIf.
+	NoImplicitTouch bool
+	// MaxValueBytes limits the length of strings and byte slices
+	// stored under a single key, zero or less leaves it unlimited.
+	MaxValueBytes int
+	// RecordOps is the number of recent operations kept for
+	// RecentOps, zero or less keeps none. OpRetention is the time for
+	// which they are kept, zero or less keeps them until they are
+	// overwritten by later operations.
+	RecordOps   int
+	OpRetention time.Duration
+	// Name labels the log events of the store in place of the package
+	// name, such that several stores may be told apart.
+	Name string
+	// MaxCreates limits the number of concurrent calls to Create, zero
+	// or less leaves it unlimited.
+	MaxCreates int
+	// Rand decides the time of the first sweep, by default it is
+	// seeded with the time at which the store is started.
+	Rand *rand.Rand
+
+	// SweepMin and SweepMax, when both are set, make the sweep period
+	// adapt to the store, starting from Period: each timeout
+	// verification counts the sessions that will expire before the
+	// next and, should there be SweepPressure or more, by default one,
+	// halves the period down to SweepMin, should there be none,
+	// doubles it up to SweepMax.
+	SweepMin      time.Duration
+	SweepMax      time.Duration
+	SweepPressure int
+	// SweepSink receives the SIDs removed by each timeout
+	// verification, for the cleanup of an external mirror.
+	SweepSink SweepSink
+
+	// sleep replaces time.Sleep in the timer, for testing.
+	sleep func(time.Duration)
+}
+
+// InitWith initialises a new ram store with the given settings, all of
+// which are in effect before the session server and its timer start.
+// InitWith panics should the write-ahead log of cfg not be recovered,
+// OpenWith returns the error.
+func InitWith(cfg Config) *Store {
+	s, err := OpenWith(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// OpenWith is InitWith, returning an error rather than a store should
+// the write-ahead log of cfg not be recovered, such that the store does
+// not run without it.
+func OpenWith(cfg Config) (*Store, error) {
+	const fname = "OpenWith"
 	s := Store{
-		sessions: make(map[uuid.UUID]Session),
-		period:   time.Minute * time.Duration(defaultPeriod),
-		commands: cmds,
+		sessions:    make(map[uuid.UUID]Session),
+		period:      time.Minute * time.Duration(defaultPeriod),
+		lru:         list.New(),
+		lruElem:     make(map[uuid.UUID]*list.Element),
+		clock:       systemClock{},
+		ids:         randomSource{},
+		tombs:       newTombstones(defaultTombstones),
+		parents:     make(map[uuid.UUID]uuid.UUID),
+		children:    make(map[uuid.UUID][]uuid.UUID),
+		maxSessions: cfg.MaxSessions,
+		policy:      cfg.Policy,
+		maxBytes:    cfg.MaxBytes,
+		grace:       cfg.GracePeriod,
+		sweepBatch:  cfg.SweepBatch,
+		sweepOrder:  cfg.Order,
+	}
+	s.defaultMaxAge = cfg.DefaultMaxAge
+	s.name = cfg.Name
+	s.maxValue = cfg.MaxValueBytes
+	s.noRecover = cfg.NoRecover
+	s.rejectExpired = cfg.RejectExpired
+	s.noImplicitTouch = cfg.NoImplicitTouch
+	s.checkValue = cfg.Serializable
+	s.unserializable = cfg.Unserializable
+	s.sweepMin, s.sweepMax = cfg.SweepMin, cfg.SweepMax
+	s.sink = cfg.SweepSink
+	s.sweepPressure = defaultSweepPressure
+	if cfg.SweepPressure > 0 {
+		s.sweepPressure = cfg.SweepPressure
+	}
+	s.compactAt = cfg.CompactAt
+	s.normKey = cfg.NormalizeKey
+	s.tracer = cfg.Tracer
+	s.labelKeys = labelKeys(cfg.LabelKeys)
+	s.labelValues = defaultLabelValues
+	if cfg.MaxLabelValues > 0 {
+		s.labelValues = cfg.MaxLabelValues
 	}
-	s.startTimer()
-	return &s
+	s.tombsKeep = cfg.TombstoneRetention
+	if cfg.Persister != nil {
+		s.persister = cfg.Persister
+		s.dirty = make(map[uuid.UUID]struct{})
+		s.deleted = make(map[uuid.UUID]struct{})
+	}
+	s.diag = cfg.Diagnostics
+	s.logger = diagLogger{s.diag}
+	if cfg.RecordOps > 0 {
+		s.opLog.ops = make([]Op, cfg.RecordOps)
+		s.opLog.keep = cfg.OpRetention
+	}
+	if cfg.MaxTombstones > 0 {
+		s.tombs = newTombstones(cfg.MaxTombstones)
+	}
+	if cfg.MaxCreates > 0 {
+		s.creates = make(slots, cfg.MaxCreates)
+	}
+	if cfg.Period > 0 {
+		s.period = cfg.Period
+	}
+	if cfg.Clock != nil {
+		s.clock = cfg.Clock
+	}
+	if cfg.IDSource != nil {
+		s.ids = cfg.IDSource
+	}
+	if cfg.Logger != nil {
+		s.logger = cfg.Logger
+	}
+	queue := defaultQueueSize
+	if cfg.QueueSize > 0 {
+		queue = cfg.QueueSize
+	}
+	s.commands = make(chan command, queue)
+	s.done = make(chan struct{})
+	go sessionServer(s.commands, s.done)
+	rnd := cfg.Rand
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	s.wake = make(chan struct{}, 1)
+	sleep := cfg.sleep
+	if sleep == nil {
+		sleep = s.sleep
+	}
+	if cfg.WAL != "" {
+		if err := s.recoverWAL(cfg.WAL, cfg.WALCompact, cfg.WALSync); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("%s: %w", fname, err)
+		}
+	}
+	if s.persister != nil {
+		if err := s.hydrate(); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("%s: %w", fname, err)
+		}
+		if cfg.FlushInterval > 0 {
+			s.flushStop = make(chan struct{})
+			go s.flushEvery(cfg.FlushInterval, s.flushStop)
+		}
+	}
+	s.startTimer(rnd, sleep)
+	if cfg.StatsInterval > 0 {
+		s.StatsInterval(cfg.StatsInterval)
+	}
+	return &s, nil
+}
+
+// invalid returns true if the SID is not a well formed RFC 4122 uuid.
+func invalid(sid uuid.UUID) bool {
+	return sid == uuid.Nil || sid.Variant() != uuid.RFC4122
 }
 
 // Create makes a session for which the given SID is the key, returning
-// and error if the SID is already in use.
-func (s *Store) Create(sid uuid.UUID, maxage int) (se Session, err error) {
+// ErrExists if the SID is already in use, or ErrCapacity if the store
+// is full and its capacity policy is RejectNew. The maxage is given in
+// seconds, when it is zero or less the stores DefaultMaxAge is used and
+// failing that half of its period. If the store limits concurrent
+// creation and is at its limit ErrBusy is returned, see CreateContext
+// to wait instead.
+func (s *Store) Create(sid uuid.UUID, maxage int, opts ...CreateOption) (se Session, err error) {
 	const fname = "Store.Create"
+	if !s.creates.try() {
+		return se, fmt.Errorf("%s: %w", fname, ErrBusy)
+	}
+	defer s.creates.release()
+	return s.createSession(context.Background(), fname, sid, maxage, opts)
+}
+
+// createSession has the session server create the session, returning
+// the error of ctx should it be done before the server answers. The
+// result channel is buffered, such that the server does not wait upon
+// a caller that has given up.
+func (s *Store) createSession(ctx context.Context, fname string, sid uuid.UUID, maxage int, opts []CreateOption) (se Session, err error) {
 	fail := func(err error) (Session, error) {
 		return se, fmt.Errorf("%s: %w", fname, err)
 	}
-	if sid.Variant() == uuid.Invalid {
+	if invalid(sid) {
 		return fail(ErrPoorForm)
 	}
-	res := make(chan Session)
+	var o createOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	res := make(chan result, 1)
 	c := command{
 		cmd:     create,
 		key:     sid,
 		maxage:  time.Duration(maxage) * time.Second,
 		result:  res,
 		seStore: s,
+		data:    o.data,
+		until:   o.until,
+		state:   o.state,
+	}
+	if o.maxage > 0 {
+		c.maxage = o.maxage
 	}
-	s.commands <- c
-	sess := <-res
-	if !sess.active {
-		return fail(ErrNoSession)
+	r, err := s.sendContext(ctx, c)
+	if err != nil {
+		return fail(err)
 	}
-	se = sess
+	if r.err != nil {
+		return fail(r.err)
+	}
+	se = r.se
 	return
 }
 
 // Restore returns a session for which the given SID is the key if it
-// exists, returning an error if it does not.
+// exists, returning an error if it does not. The session is looked up
+// and touched in one operation of the session server, as such a Restore
+// that races a Destroy of the same SID is ordered wholly before or
+// after it: either the session is returned live, or the zero Session is
+// returned along with an error matching ErrNoSession, never a session
+// that had already been destroyed. A session destroyed after it was
+// restored returns errors matching ErrNoSession from its methods.
 func (s *Store) Restore(sid uuid.UUID) (se Session, err error) {
-	const fname = "Store.Restore"
+	return s.restoreSession(context.Background(), "Store.Restore", sid)
+}
+
+// restoreSession has the session server restore the session, returning
+// the error of ctx should it be done before the server answers.
+func (s *Store) restoreSession(ctx context.Context, fname string, sid uuid.UUID) (se Session, err error) {
 	fail := func(err error) (Session, error) {
 		return se, fmt.Errorf("%s: %w", fname, err)
 	}
 
-	if sid.Variant() == uuid.Invalid {
+	if invalid(sid) {
 		return fail(ErrPoorForm)
 	}
-	res := make(chan Session)
+	res := make(chan result, 1)
 	c := command{
 		cmd:     touch,
 		key:     sid,
 		result:  res,
 		seStore: s,
 	}
-	s.commands <- c
-	sess := <-res
-	if !sess.active {
-		return fail(ErrNoSession)
+	r, err := s.sendContext(ctx, c)
+	if err != nil {
+		return fail(err)
+	}
+	if r.err != nil {
+		return fail(r.err)
 	}
-	se = sess
+	se = r.se
 	return
 }
 
-// Destroy removes a session from the store.
+// Destroy removes a session from the store, returning ErrNoSession if
+// there is no session to remove.
 func (s *Store) Destroy(sid uuid.UUID) (err error) {
 	const fname = "Store.Destroy"
-	if sid.Variant() == uuid.Invalid {
-		return fmt.Errorf("%s: %w", fname, ErrNoSession)
+	if invalid(sid) {
+		return fmt.Errorf("%s: %w", fname, ErrPoorForm)
 	}
-	res := make(chan Session)
+	res := make(chan result, 1)
 	c := command{
 		cmd:     deactivate,
 		key:     sid,
 		result:  res,
 		seStore: s,
 	}
-	s.commands <- c
-	<-res
+	if r := s.send(c); r.err != nil {
+		return fmt.Errorf("%s: %w", fname, r.err)
+	}
+	return
+}
+
+// DefaultMaxAge sets the maxage of sessions created with a maxage of
+// zero or less. When it is itself zero or less, the default, such
+// sessions are given half of the stores period instead. The previous
+// value is returned.
+func (s *Store) DefaultMaxAge(d time.Duration) (previous time.Duration) {
+	s.exec(func() {
+		previous = s.defaultMaxAge
+		s.defaultMaxAge = d
+	})
 	return
 }
 
 // Period sets the periodicity for the stores timeout function timer.
+// The pending sweep is moved to one new period after the last, or after
+// the start of the store, sweeping at once should that time have
+// passed. An adaptive period starts again from t.
 func (s *Store) Period(t time.Duration) (previous time.Duration) {
-	previous = s.period
-	s.period = t
+	s.exec(func() {
+		previous = s.period
+		s.period = t
+		s.sweepEvery = 0
+		if !s.sweepFrom.IsZero() {
+			s.sweepAt = s.sweepFrom.Add(t)
+			s.sweepMoved = true
+			select {
+			case s.wake <- struct{}{}:
+			default:
+			}
+		}
+	})
 	return
 }
 
 // startTimer starts a go routine that periodically clears unused
 // sessions from the session store.
-func (s *Store) startTimer() {
-	res := make(chan Session)
+func (s *Store) startTimer(rnd *rand.Rand, sleep func(time.Duration)) {
+	// The first sweep is made at a random point within the first
+	// period, such that stores started together do not all sweep
+	// together thereafter. It is planned before the store is returned
+	// that NextSweep is set from the start.
+	var phase time.Duration
+	var first bool
+	s.exec(func() {
+		if first = s.period > 0; first {
+			phase = time.Duration(rnd.Int63n(int64(s.period)))
+			s.plan(phase)
+		}
+	})
+	go func() {
+		if first {
+			s.wait(phase, sleep)
+			s.sweep()
+		}
+		for !s.isClosed() {
+			var period time.Duration
+			s.exec(func() {
+				period = s.nextSweep()
+				s.plan(period)
+			})
+			s.wait(period, sleep)
+			s.sweep()
+		}
+	}()
+}
+
+// sweep has the session server run the timeout verification.
+func (s *Store) sweep() {
+	res := make(chan result, 1)
 	c := command{
 		cmd:     timecheck,
 		result:  res,
 		seStore: s,
 	}
-	go func() {
-		for {
-			time.Sleep(s.period)
-			s.commands <- c
-			<-res
-		}
-	}()
+	if r := s.send(c); len(r.reaped) > 0 {
+		s.sink.Reaped(r.reaped)
+	}
 }
 
-// touch updates the sessions lastUsed time to now.
-func (s *Store) touch(sid uuid.UUID) (se Session) {
-	res := make(chan Session)
+// exec runs fn within the session server, giving it sole access to the
+// stores internal state for the duration of the call.
+func (s *Store) exec(fn func()) {
+	res := make(chan result, 1)
 	c := command{
-		cmd:     touch,
-		key:     sid,
+		cmd:     call,
 		result:  res,
 		seStore: s,
+		fn:      fn,
+	}
+	s.send(c)
+}
+
+// execContext is exec, giving up should ctx be done before fn has run,
+// in which case ctx.Err() is returned and fn may yet be run later.
+func (s *Store) execContext(ctx context.Context, fn func()) error {
+	res := make(chan result, 1)
+	c := command{
+		cmd:     call,
+		result:  res,
+		seStore: s,
+		fn:      fn,
+	}
+	select {
+	case s.commands <- c:
+	case <-s.done:
+		s.runStopped(c)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-res:
+	case <-s.done:
+		s.await(res)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// send passes the command to the session server and returns its result.
+// Once the server has stopped, the store having been closed, the command
+// is run by the caller in its stead.
+func (s *Store) send(c command) result {
+	select {
+	case <-s.done:
+		return s.runStopped(c)
+	default:
+	}
+	select {
+	case s.commands <- c:
+	case <-s.done:
+		return s.runStopped(c)
 	}
-	s.commands <- c
-	se = <-res
+	return s.await(c.result)
+}
+
+// sendContext is send, returning the error of ctx should it be done
+// before the session server answers. The result channel of the command
+// is to be buffered, such that the server does not wait upon a caller
+// that has given up.
+func (s *Store) sendContext(ctx context.Context, c command) (result, error) {
+	select {
+	case s.commands <- c:
+	case <-s.done:
+		return s.runStopped(c), nil
+	case <-ctx.Done():
+		return result{}, ctx.Err()
+	}
+	select {
+	case r := <-c.result:
+		return r, nil
+	case <-s.done:
+		return s.await(c.result), nil
+	case <-ctx.Done():
+		return result{}, ctx.Err()
+	}
+}
+
+// await returns the result of a command passed to the session server.
+// Should the server stop before reaching it, the commands that remain in
+// the queue are run by those that wait upon them, each taking the next
+// in turn until its own result is in.
+func (s *Store) await(res chan result) result {
+	select {
+	case r := <-res:
+		return r
+	case <-s.done:
+	}
+	for {
+		s.stopped.Lock()
+		select {
+		case r := <-res:
+			s.stopped.Unlock()
+			return r
+		case c := <-s.commands:
+			c.result <- c.run()
+		}
+		s.stopped.Unlock()
+	}
+}
+
+// runStopped runs the command in the place of the session server, which
+// has stopped.
+func (s *Store) runStopped(c command) result {
+	s.stopped.Lock()
+	defer s.stopped.Unlock()
+	return c.run()
+}
+
+// update touches the session, unless the store is of NoImplicitTouch,
+// and, if it is live, runs fn upon it from
+// within the session server, such that fn has sole access to the
+// sessions data for its duration. If the session is not live the
+// reason is returned. A panic within the update is returned as an error
+// matching ErrInternal, see recover.go.
+func (s *Store) update(sid uuid.UUID, fn func(se Session)) (gone error) {
+	s.exec(func() {
+		if !s.noRecover {
+			defer s.recoverInto(&gone, sid)
+		}
+		se, err := s.use(sid)
+		if err != nil {
+			gone = err
+			return
+		}
+		if s.testHook != nil {
+			s.testHook()
+		}
+		fn(se)
+	})
 	return
 }
 
-// Session is a key value pair data store.
+// Session is a key value pair data store. The zero value is not a valid
+// session, its methods return ErrInvalidSession.
 type Session struct {
 	// Contains non exported fields.
 	id       uuid.UUID
@@ -374,32 +1218,71 @@ type Session struct {
 	sto      *Store
 	maxage   time.Duration
 	active   bool
+	size     int
+	// until is the time of the sessions scheduled destruction, see
+	// schedule.go.
+	until time.Time
+	// ctx is the sessions context object, see context.go.
+	ctx interface{}
+	// owner is the id of the user to whom the session belongs, see
+	// owner.go.
+	owner string
+	// frozen pauses the expiry of the session, see schedule.go.
+	frozen bool
+	// pinned exempts the session from expiry and eviction, see
+	// schedule.go.
+	pinned bool
+	// warned is set once the session has been reported as near to
+	// expiry, until it is next touched, see hooks.go.
+	warned bool
+}
+
+// zero returns true if the session was never valid.
+func (s Session) zero() bool {
+	return s.sto == nil || !s.active
+}
+
+// ID returns the sessions id.
+func (s Session) ID() uuid.UUID {
+	return s.id
+}
+
+// MaxAge returns the time for which the session lives without use.
+func (s Session) MaxAge() time.Duration {
+	return s.maxage
 }
 
-// Set stores the given key pair value.
+// Set stores the given key pair value, returning ErrReservedKey if the
+// key is reserved by the store, see Flash.
 func (s Session) Set(key string, value interface{}) (err error) {
 	const fname = "Session.Set"
 	fail := func(err error) error {
 		return fmt.Errorf("%s: %w", fname, err)
 	}
-	if s.sto == nil || !s.active {
-		return fail(ErrTimedOut)
+	if s.zero() {
+		return fail(ErrInvalidSession)
 	}
-	s = s.sto.touch(s.id)
-	if !s.active {
-		if log.Is(log.DEBUG) {
+	key = s.sto.normalize(key)
+	gone := s.sto.update(s.id, func(se Session) {
+		s.sto.record(OpSet, s.id, key)
+		err = s.sto.put(se, key, value)
+	})
+	if gone != nil {
+		if d := s.sto.diag; d != nil {
 			const event = "failed"
-			log.Debug(nil, pkg, fname, event,
+			d.Debug(nil, s.sto.label(), fname, event,
 				"SID", s.id)
 		}
-		return fail(ErrTimedOut)
+		return fail(gone)
 	}
-	if log.Is(log.DEBUG) {
+	if err != nil {
+		return fail(err)
+	}
+	if d := s.sto.diag; d != nil {
 		const event = "success"
-		log.Debug(nil, pkg, fname, event,
+		d.Debug(nil, s.sto.label(), fname, event,
 			"SID", s.id)
 	}
-	s.data[key] = value
 	return
 }
 
@@ -409,58 +1292,219 @@ func (s Session) Get(key string) (value interface{}, err error) {
 	fail := func(err error) (interface{}, error) {
 		return nil, fmt.Errorf("%s: %w", fname, err)
 	}
-	if s.sto == nil || !s.active {
-		return fail(ErrPoorForm)
+	if s.zero() {
+		return fail(ErrInvalidSession)
 	}
-	s = s.sto.touch(s.id)
-	if !s.active {
-		return fail(ErrNoSession)
+	key = s.sto.normalize(key)
+	var ok bool
+	gone := s.sto.update(s.id, func(se Session) {
+		s.sto.record(OpGet, s.id, key)
+		value, ok = se.data[key]
+	})
+	if gone != nil {
+		return fail(gone)
 	}
-	value, ok := s.data[key]
 	if !ok {
-		if log.Is(log.DEBUG) {
+		if d := s.sto.diag; d != nil {
 			const event = "failed"
-			log.Debug(nil, pkg, fname, event,
+			d.Debug(nil, s.sto.label(), fname, event,
 				"SID", s.id)
 		}
 		return fail(ErrNoData)
 	}
-	if log.Is(log.DEBUG) {
+	if d := s.sto.diag; d != nil {
 		const event = "success"
-		log.Debug(nil, pkg, fname, event,
+		d.Debug(nil, s.sto.label(), fname, event,
 			"SID", s.id)
 	}
 	return
 }
 
+// GetDefault retrieves the value paired with key, returning def if the
+// session holds no such key. Errors concerning the session itself are
+// still returned.
+func (s Session) GetDefault(key string, def interface{}) (value interface{}, err error) {
+	const fname = "Session.GetDefault"
+	value, err = s.Get(key)
+	if errors.Is(err, ErrNoData) {
+		return def, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// Has reports whether a value is paired with key, without returning the
+// value itself. The session is touched as it is by Get.
+func (s Session) Has(key string) (ok bool, err error) {
+	const fname = "Session.Has"
+	if s.zero() {
+		return false, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	key = s.sto.normalize(key)
+	gone := s.sto.update(s.id, func(se Session) {
+		_, ok = se.data[key]
+	})
+	if gone != nil {
+		return false, fmt.Errorf("%s: %w", fname, gone)
+	}
+	return
+}
+
 // Del deletes the value paired with key.
 func (s Session) Del(key string) (err error) {
 	const fname = "Session.Del"
 	fail := func(err error) error {
 		return fmt.Errorf("%s: %w", fname, err)
 	}
-	if s.sto == nil || !s.active {
-		return fail(ErrPoorForm)
+	if s.zero() {
+		return fail(ErrInvalidSession)
 	}
-	s = s.sto.touch(s.id)
-	if !s.active {
-		if log.Is(log.DEBUG) {
+	key = s.sto.normalize(key)
+	gone := s.sto.update(s.id, func(se Session) {
+		s.sto.record(OpDel, s.id, key)
+		err = s.sto.remove(se, key)
+	})
+	if gone != nil {
+		if d := s.sto.diag; d != nil {
 			const event = "failed"
-			log.Debug(nil, pkg, fname, event,
+			d.Debug(nil, s.sto.label(), fname, event,
 				"SID", s.id)
 		}
-		return fail(ErrNoSession)
+		return fail(gone)
 	}
-	delete(s.data, key)
-	if log.Is(log.DEBUG) {
+	if err != nil {
+		return fail(err)
+	}
+	if d := s.sto.diag; d != nil {
 		const event = "success"
-		log.Debug(nil, pkg, fname, event,
+		d.Debug(nil, s.sto.label(), fname, event,
 			"SID", s.id)
 	}
 	return
 }
 
-// Valid returns the session active state.
+// Pop retrieves the value paired with key and deletes it in one
+// operation, such that only one caller may ever receive it.
+func (s Session) Pop(key string) (value interface{}, err error) {
+	const fname = "Session.Pop"
+	fail := func(err error) (interface{}, error) {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if s.zero() {
+		return fail(ErrInvalidSession)
+	}
+	key = s.sto.normalize(key)
+	var ok bool
+	gone := s.sto.update(s.id, func(se Session) {
+		s.sto.record(OpPop, s.id, key)
+		value, ok = se.data[key]
+		err = s.sto.remove(se, key)
+	})
+	if gone != nil {
+		return fail(gone)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if !ok {
+		return fail(ErrNoData)
+	}
+	return
+}
+
+// DelPrefix deletes every key that begins with prefix in one operation,
+// returning the number of keys deleted. Keys reserved by the store are
+// kept.
+func (s Session) DelPrefix(prefix string) (n int, err error) {
+	const fname = "Session.DelPrefix"
+	if s.zero() {
+		return 0, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	prefix = s.sto.normalize(prefix)
+	gone := s.sto.update(s.id, func(se Session) {
+		for k := range se.data {
+			key, ok := k.(string)
+			if ok && strings.HasPrefix(key, prefix) && !reserved(key) {
+				if err = s.sto.remove(se, key); err != nil {
+					return
+				}
+				n++
+			}
+		}
+	})
+	if gone != nil {
+		return 0, fmt.Errorf("%s: %w", fname, gone)
+	}
+	if err != nil {
+		return n, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// Keys returns the keys held by the session in sorted order, save those
+// reserved by the store.
+func (s Session) Keys() (keys []string, err error) {
+	const fname = "Session.Keys"
+	if s.zero() {
+		return nil, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		keys = make([]string, 0, len(se.data))
+		for k := range se.data {
+			if key, ok := k.(string); ok && !reserved(key) {
+				keys = append(keys, key)
+			}
+		}
+	})
+	if gone != nil {
+		return nil, fmt.Errorf("%s: %w", fname, gone)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// Valid reports whether the session may still be used, as decided by
+// the store rather than by the copy at hand: false if it has since been
+// removed, or if it has expired, in which case it is removed at once
+// rather than at the next timeout verification. The session is not
+// touched.
 func (s Session) Valid() (ok bool) {
-	return s.active
+	if s.zero() {
+		return false
+	}
+	s.sto.exec(func() {
+		ok = s.sto.live(s.id)
+	})
+	return
+}
+
+// ValidContext is Valid, returning false should ctx be done before the
+// store answers.
+func (s Session) ValidContext(ctx context.Context) (ok bool) {
+	if s.zero() {
+		return false
+	}
+	var live bool
+	err := s.sto.execContext(ctx, func() {
+		live = s.sto.live(s.id)
+	})
+	if err != nil {
+		return false
+	}
+	return live
+}
+
+// live reports whether the session sid is in the store and has not
+// expired, removing it if it has. This function is to be run only by the
+// sessionServer function.
+func (s *Store) live(sid uuid.UUID) bool {
+	const fname = "Store.live"
+	se, ok := s.sessions[sid]
+	if ok && s.expired(se) {
+		s.expire(sid, fname)
+		return false
+	}
+	return ok
 }
@@ -0,0 +1,4657 @@
+package ram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newIDs returns n new session ids.
+func newIDs(n int) []uuid.UUID {
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	return ids
+}
+
+func TestCapacityRejectNew(t *testing.T) {
+	const fname = "TestCapacityRejectNew"
+	s := Init()
+	s.MaxSessions(2)
+	ids := newIDs(3)
+	for _, id := range ids[:2] {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	_, err := s.Create(ids[2], 0)
+	if !errors.Is(err, ErrCapacity) {
+		t.Errorf("%s: want ErrCapacity got (%T, %+v)", fname, err, err)
+	}
+	for _, id := range ids[:2] {
+		if _, err := s.Restore(id); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if max, p := s.Capacity(); max != 2 || p != RejectNew {
+		t.Errorf("%s: want (2, RejectNew) got (%d, %s)", fname, max, p)
+	}
+}
+
+func TestCapacityEvictLRU(t *testing.T) {
+	const fname = "TestCapacityEvictLRU"
+	s := Init()
+	s.MaxSessions(3)
+	s.Policy(EvictLRU)
+	ids := newIDs(4)
+	for _, id := range ids[:3] {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	// Use the first session, leaving the second as the least recently
+	// used.
+	if _, err := s.Restore(ids[0]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Create(ids[3], 0); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err := s.Restore(ids[1])
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	for _, id := range []uuid.UUID{ids[0], ids[2], ids[3]} {
+		if _, err := s.Restore(id); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+}
+
+func TestCapacityEvictOldestCreated(t *testing.T) {
+	const fname = "TestCapacityEvictOldestCreated"
+	s := Init()
+	s.MaxSessions(3)
+	s.Policy(EvictOldestCreated)
+	ids := newIDs(4)
+	for _, id := range ids[:3] {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	// Using the oldest session must not save it.
+	if _, err := s.Restore(ids[0]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Create(ids[3], 0); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err := s.Restore(ids[0])
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	for _, id := range ids[1:] {
+		if _, err := s.Restore(id); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+}
+
+func TestGetDefault(t *testing.T) {
+	const fname = "TestGetDefault"
+	s := Init()
+	id := uuid.New()
+	sess, err := s.Create(id, 0)
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err = sess.Set("flag", true); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// Present key returns the stored value.
+	v, err := sess.GetDefault("flag", false)
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v != true {
+		t.Errorf("%s: want true got (%T, %+v)", fname, v, v)
+	}
+
+	// Absent key returns the default.
+	v, err = sess.GetDefault("missing", "def")
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v != "def" {
+		t.Errorf("%s: want \"def\" got (%T, %+v)", fname, v, v)
+	}
+
+	// A dead session returns its error.
+	s.Destroy(id)
+	v, err = sess.GetDefault("flag", false)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if v != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, v, v)
+	}
+}
+
+func TestHas(t *testing.T) {
+	const fname = "TestHas"
+	s := Init()
+	id := uuid.New()
+	sess, err := s.Create(id, 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err = sess.Set("onboarding", 2); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if ok, err := sess.Has("onboarding"); err != nil || !ok {
+		t.Errorf("%s: want (true, <nil>) got (%v, %v)", fname, ok, err)
+	}
+	if err = sess.Del("onboarding"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if ok, err := sess.Has("onboarding"); err != nil || ok {
+		t.Errorf("%s: want (false, <nil>) got (%v, %v)", fname, ok, err)
+	}
+
+	// A dead session returns its error.
+	s.Destroy(id)
+	_, err = sess.Has("onboarding")
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	const fname = "TestSubscribe"
+	s := Init()
+	id := uuid.New()
+	sess, err := s.Create(id, 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	values, cancel, err := sess.Subscribe("step")
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err = sess.Set("step", i); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if err = sess.Set("other", i); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		if v := <-values; v != i {
+			t.Errorf("%s: want %d got %v", fname, i, v)
+		}
+	}
+	cancel()
+	cancel()
+	if _, ok := <-values; ok {
+		t.Errorf("%s: want channel closed", fname)
+	}
+
+	// A slow subscriber loses the oldest values.
+	values, _, err = sess.Subscribe("step")
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for i := 0; i < subscriptionBuffer+5; i++ {
+		if err = sess.Set("step", i); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if v := <-values; v != 5 {
+		t.Errorf("%s: want 5 got %v", fname, v)
+	}
+
+	// Destroying the session closes its subscriptions.
+	s.Destroy(id)
+	n := 1
+	for range values {
+		n++
+	}
+	if n != subscriptionBuffer {
+		t.Errorf("%s: want %d values got %d", fname, subscriptionBuffer, n)
+	}
+	s.exec(func() {
+		if len(s.subs) != 0 {
+			t.Errorf("%s: want no subscriptions got %v", fname, s.subs)
+		}
+	})
+	_, _, err = sess.Subscribe("step")
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestCursorIterate(t *testing.T) {
+	const fname = "TestCursorIterate"
+	s := Init()
+	ids := newIDs(10)
+	for _, id := range ids {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	var seen []uuid.UUID
+	skipped := s.CursorIterate(func(se Session) bool {
+		if len(seen) == 0 {
+			// Destroy three sessions that are yet to be visited
+			// and create one that is not in the snapshot.
+			for _, id := range ids[7:] {
+				if err := s.Destroy(id); err != nil {
+					t.Errorf("%s: want <nil> got (%T, %+v)",
+						fname, err, err)
+				}
+			}
+			if _, err := s.Create(uuid.New(), 0); err != nil {
+				t.Errorf("%s: want <nil> got (%T, %+v)",
+					fname, err, err)
+			}
+		}
+		seen = append(seen, se.ID())
+		return true
+	})
+	if skipped != 3 {
+		t.Errorf("%s: want 3 skipped got %d", fname, skipped)
+	}
+	if len(seen) != 7 {
+		t.Fatalf("%s: want 7 sessions got %d", fname, len(seen))
+	}
+	for i, id := range seen {
+		if id != ids[i] {
+			t.Errorf("%s: want %s got %s", fname, ids[i], id)
+		}
+	}
+}
+
+// count returns the number of sessions in the store.
+func (s *Store) count() (n int) {
+	s.exec(func() {
+		n = len(s.sessions)
+	})
+	return
+}
+
+// backdate sets the last modified time of the session, as seen by the
+// store, to d in the past.
+func (s *Store) backdate(id uuid.UUID, d time.Duration) {
+	s.exec(func() {
+		se := s.sessions[id]
+		se.modified = s.clock.Now().Add(-d)
+		s.sessions[id] = se
+	})
+}
+
+func TestSweepBatch(t *testing.T) {
+	const fname = "TestSweepBatch"
+	const total, batch = 100, 10
+	s := Init()
+	s.SweepBatch(batch)
+	ids := newIDs(total)
+	for i, id := range ids {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if i%2 == 0 {
+			s.backdate(id, time.Hour)
+		}
+	}
+	s.sweep()
+	if n := s.count(); n < total-batch {
+		t.Errorf("%s: want at most %d reaped got %d",
+			fname, batch, total-n)
+	}
+	// Every session is examined within total/batch sweeps.
+	for i := 1; i < total/batch; i++ {
+		s.sweep()
+	}
+	if n := s.count(); n != total/2 {
+		t.Errorf("%s: want %d sessions got %d", fname, total/2, n)
+	}
+	for i, id := range ids {
+		_, err := s.Restore(id)
+		if i%2 == 0 && !errors.Is(err, ErrNoSession) {
+			t.Errorf("%s: want ErrNoSession got (%T, %+v)",
+				fname, err, err)
+		}
+		if i%2 == 1 && err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+}
+
+func TestSweepBatchWarn(t *testing.T) {
+	const fname = "TestSweepBatchWarn"
+	const total, batch = 10, 3
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	s.SweepBatch(batch)
+	warned := make(map[uuid.UUID]int)
+	s.OnNearExpiry(20*time.Second, func(sid uuid.UUID) {
+		warned[sid]++
+	})
+	for _, id := range newIDs(total) {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	clock.Advance(50 * time.Second)
+	s.sweep()
+	if len(warned) != batch {
+		t.Errorf("%s: want %d warned got %d", fname, batch, len(warned))
+	}
+	// Every session is warned, once, within total/batch sweeps.
+	for i := 0; i < total/batch; i++ {
+		s.sweep()
+	}
+	if len(warned) != total {
+		t.Errorf("%s: want %d warned got %d", fname, total, len(warned))
+	}
+	for sid, n := range warned {
+		if n != 1 {
+			t.Errorf("%s: want 1 warning got %d for %s", fname, n, sid)
+		}
+	}
+}
+
+func TestAdaptiveSweep(t *testing.T) {
+	const fname = "TestAdaptiveSweep"
+	sleeps, wake := make(chan time.Duration), make(chan struct{})
+	s := InitWith(Config{
+		Clock:    newFakeClock(),
+		Period:   time.Minute,
+		SweepMin: 10 * time.Second,
+		SweepMax: 4 * time.Minute,
+		sleep: func(d time.Duration) {
+			sleeps <- d
+			<-wake
+		},
+	})
+	// The first sweep is at a random point of the first period.
+	<-sleeps
+	ids := newIDs(10)
+	for _, id := range ids {
+		if _, err := s.Create(id, 30); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	wake <- struct{}{}
+	if d := <-sleeps; d >= time.Minute {
+		t.Errorf("%s: want a period under %v got %v", fname, time.Minute, d)
+	}
+	for _, id := range ids {
+		if err := s.Destroy(id); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	// A quiet store lengthens the period, up to the maximum.
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute,
+		4 * time.Minute, 4 * time.Minute} {
+		wake <- struct{}{}
+		if d := <-sleeps; d != want {
+			t.Errorf("%s: want a period of %v got %v", fname, want, d)
+		}
+	}
+}
+
+func TestNextSweep(t *testing.T) {
+	const fname = "TestNextSweep"
+	clock := newFakeClock()
+	start := clock.Now()
+	s := InitWith(Config{Clock: clock, Period: time.Hour})
+	at := s.NextSweep()
+	if at.Before(start) || !at.Before(start.Add(time.Hour)) {
+		t.Errorf("%s: want within the first period got %v", fname, at.Sub(start))
+	}
+	s.Period(10 * time.Hour)
+	if got := s.NextSweep(); !got.Equal(start.Add(10 * time.Hour)) {
+		t.Errorf("%s: want %v got %v", fname, 10*time.Hour, got.Sub(start))
+	}
+
+	// A shorter period that has already passed sweeps at once.
+	if _, err := s.Create(uuid.New(), 60); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(2 * time.Minute)
+	s.Period(time.Minute)
+	for i := 0; s.count() > 0; i++ {
+		if i == 100 {
+			t.Fatalf("%s: want the session swept", fname)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	now := clock.Now()
+	for i := 0; !s.NextSweep().Equal(now.Add(time.Minute)); i++ {
+		if i == 100 {
+			t.Fatalf("%s: want %v got %v", fname, now.Add(time.Minute),
+				s.NextSweep())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Close()
+	if got := s.NextSweep(); !got.IsZero() {
+		t.Errorf("%s: want the zero time got %v", fname, got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	const fname = "TestMerge"
+	tests := []struct {
+		strategy MergeStrategy
+		want     map[string]interface{}
+		err      error
+	}{
+		{PreferSelf, map[string]interface{}{
+			"a": "self", "b": "self", "c": "other"}, nil},
+		{PreferOther, map[string]interface{}{
+			"a": "self", "b": "other", "c": "other"}, nil},
+		{ErrorOnConflict, map[string]interface{}{
+			"a": "self", "b": "self"}, ErrConflict},
+	}
+	s := Init()
+	for _, test := range tests {
+		self, err := s.Create(uuid.New(), 0)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		other, err := s.Create(uuid.New(), 0)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		self.Set("a", "self")
+		self.Set("b", "self")
+		other.Set("b", "other")
+		other.Set("c", "other")
+
+		err = self.Merge(other, test.strategy)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: %d: want %v got (%T, %+v)", fname,
+				test.strategy, test.err, err, err)
+		}
+		got := self.snapshot().Data
+		if len(got) != len(test.want) {
+			t.Errorf("%s: %d: want %v got %v", fname,
+				test.strategy, test.want, got)
+		}
+		for k, v := range test.want {
+			if got[k] != v {
+				t.Errorf("%s: %d: want %s=%v got %v", fname,
+					test.strategy, k, v, got[k])
+			}
+		}
+	}
+
+	// A dead session returns its error.
+	self, _ := s.Create(uuid.New(), 0)
+	other, _ := s.Create(uuid.New(), 0)
+	s.Destroy(other.ID())
+	err := self.Merge(other, PreferSelf)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestMergePublish(t *testing.T) {
+	const fname = "TestMergePublish"
+	s := Init()
+	a, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	b, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	values, cancel, err := a.Subscribe("k")
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	defer cancel()
+	b.Set("k", "v")
+	if err := a.Merge(b, PreferOther); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	select {
+	case v := <-values:
+		if v != "v" {
+			t.Errorf("%s: want v got %v", fname, v)
+		}
+	default:
+		t.Errorf("%s: want the merged key published", fname)
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	const fname = "TestGracePeriod"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	s.GracePeriod(30 * time.Second)
+	id := uuid.New()
+	if _, err := s.Create(id, 60); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// Expired but within the grace period, the sweep leaves it and it
+	// may be restored, which renews it.
+	clock.Advance(80 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	if _, err := s.Restore(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(80 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+
+	// Beyond the grace period it is gone.
+	clock.Advance(11 * time.Second)
+	_, err := s.Restore(id)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+
+	// Likewise when the sweep gets there first.
+	id = uuid.New()
+	if _, err := s.Create(id, 60); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(91 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	_, err = s.Restore(id)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	const fname = "TestMaxBytes"
+	value := func(n int) string {
+		return string(make([]byte, n))
+	}
+	for _, policy := range []CapacityPolicy{EvictLRU, RejectNew} {
+		s := Init()
+		s.MaxBytes(100)
+		s.Policy(policy)
+		var sess []Session
+		for i := 0; i < 3; i++ {
+			se, err := s.Create(uuid.New(), 0)
+			if err != nil {
+				t.Fatalf("%s: want <nil> got (%T, %+v)",
+					fname, err, err)
+			}
+			if err = se.Set("k", value(29)); err != nil {
+				t.Errorf("%s: want <nil> got (%T, %+v)",
+					fname, err, err)
+			}
+			sess = append(sess, se)
+		}
+		if n := s.Bytes(); n != 90 {
+			t.Errorf("%s: want 90 bytes got %d", fname, n)
+		}
+
+		err := sess[2].Set("x", value(19))
+		switch policy {
+		case EvictLRU:
+			if err != nil {
+				t.Errorf("%s: want <nil> got (%T, %+v)",
+					fname, err, err)
+			}
+			_, err = s.Restore(sess[0].ID())
+			if !errors.Is(err, ErrNoSession) {
+				t.Errorf("%s: want ErrNoSession got (%T, %+v)",
+					fname, err, err)
+			}
+			if n := s.Bytes(); n != 80 {
+				t.Errorf("%s: want 80 bytes got %d", fname, n)
+			}
+		case RejectNew:
+			if !errors.Is(err, ErrCapacity) {
+				t.Errorf("%s: want ErrCapacity got (%T, %+v)",
+					fname, err, err)
+			}
+			if n := s.Bytes(); n != 90 {
+				t.Errorf("%s: want 90 bytes got %d", fname, n)
+			}
+		}
+
+		// Del and Destroy release their bytes.
+		sess[1].Del("k")
+		if n, _ := sess[1].Size(); n != 0 {
+			t.Errorf("%s: want 0 bytes got %d", fname, n)
+		}
+		for _, se := range sess {
+			s.Destroy(se.ID())
+		}
+		if n := s.Bytes(); n != 0 {
+			t.Errorf("%s: want 0 bytes got %d", fname, n)
+		}
+	}
+}
+
+func TestSweepOrder(t *testing.T) {
+	const fname = "TestSweepOrder"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	s.Order(DeadlineOrder)
+	var got []uuid.UUID
+	s.OnExpire(func(sid uuid.UUID) {
+		got = append(got, sid)
+	})
+	// Created at the same time, 2 and 3 expire together, 2 having been
+	// created first.
+	ids := newIDs(4)
+	for i, maxage := range []int{40, 10, 20, 20} {
+		if _, err := s.Create(ids[i], maxage); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	clock.Advance(time.Minute)
+	s.sweep()
+	want := []uuid.UUID{ids[1], ids[2], ids[3], ids[0]}
+	if len(got) != len(want) {
+		t.Fatalf("%s: want %d expired got %d", fname, len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: %d: want %s got %s", fname, i, want[i], got[i])
+		}
+	}
+}
+
+func TestRenewIf(t *testing.T) {
+	const fname = "TestRenewIf"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("plan", "paid")
+	paid := func(data map[string]interface{}) bool {
+		return data["plan"] == "paid"
+	}
+
+	// Renewed, the session outlives its original window.
+	clock.Advance(50 * time.Second)
+	ok, err := se.RenewIf(paid)
+	if err != nil || !ok {
+		t.Errorf("%s: want (true, <nil>) got (%t, %v)", fname, ok, err)
+	}
+	clock.Advance(50 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+
+	// Not renewed, the session expires.
+	se.Set("plan", "free")
+	clock.Advance(50 * time.Second)
+	ok, err = se.RenewIf(paid)
+	if err != nil || ok {
+		t.Errorf("%s: want (false, <nil>) got (%t, %v)", fname, ok, err)
+	}
+	clock.Advance(11 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	_, err = se.RenewIf(paid)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestBeforeCreate(t *testing.T) {
+	const fname = "TestBeforeCreate"
+	s := Init()
+	errBlocked := errors.New("blocked address")
+	s.BeforeCreate(func(sid uuid.UUID, data map[string]interface{}) error {
+		if data["ip"] == "10.0.0.1" {
+			return errBlocked
+		}
+		return nil
+	})
+	id := uuid.New()
+	_, err := s.Create(id, 0, WithData(map[string]interface{}{
+		"ip": "10.0.0.1",
+	}))
+	if !errors.Is(err, errBlocked) || !errors.Is(err, ErrRejected) {
+		t.Errorf("%s: want errBlocked and ErrRejected got (%T, %+v)",
+			fname, err, err)
+	}
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+
+	se, err := s.Create(id, 0, WithData(map[string]interface{}{
+		"ip": "10.0.0.2",
+	}))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	ip, err := se.Get("ip")
+	if err != nil || ip != "10.0.0.2" {
+		t.Errorf("%s: want (10.0.0.2, <nil>) got (%v, %v)", fname, ip, err)
+	}
+}
+
+func TestSessionFactory(t *testing.T) {
+	const fname = "TestSessionFactory"
+	s := Init()
+	s.Factory(SessionFactoryFunc(func(sid uuid.UUID, data map[string]interface{}) {
+		data["_v"] = 2
+	}))
+	data := map[string]interface{}{"user": "bob"}
+	for i := 0; i < 3; i++ {
+		se, err := s.Create(uuid.New(), 0, WithData(data))
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		v, err := se.Get("_v")
+		if err != nil || v != 2 {
+			t.Errorf("%s: want (2, <nil>) got (%v, %v)", fname, v, err)
+		}
+		u, err := se.Get("user")
+		if err != nil || u != "bob" {
+			t.Errorf("%s: want (bob, <nil>) got (%v, %v)", fname, u, err)
+		}
+	}
+	// The data given to Create is left as it was.
+	if _, ok := data["_v"]; ok {
+		t.Errorf("%s: want caller data unchanged got %v", fname, data)
+	}
+}
+
+func TestResultErrors(t *testing.T) {
+	const fname = "TestResultErrors"
+	s := Init()
+	id := uuid.New()
+	if _, err := s.Create(id, 0); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	all := []error{ErrExists, ErrNoSession, ErrInternal}
+	// only reports whether err matches want and none other of all.
+	only := func(err, want error) bool {
+		for _, e := range all {
+			if errors.Is(err, e) != (e == want) {
+				return false
+			}
+		}
+		return true
+	}
+	_, err := s.Create(id, 0)
+	if !only(err, ErrExists) {
+		t.Errorf("%s: want ErrExists got (%T, %+v)", fname, err, err)
+	}
+	_, err = s.Restore(uuid.New())
+	if !only(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	s.Factory(SessionFactoryFunc(func(uuid.UUID, map[string]interface{}) {
+		panic("factory failed")
+	}))
+	_, err = s.Create(uuid.New(), 0)
+	if !only(err, ErrInternal) {
+		t.Errorf("%s: want ErrInternal got (%T, %+v)", fname, err, err)
+	}
+	// The server survives the failure.
+	s.Factory(nil)
+	if _, err := s.Restore(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+// listSource returns its ids in turn.
+type listSource struct {
+	ids []uuid.UUID
+}
+
+func (l *listSource) New() (id uuid.UUID) {
+	id, l.ids = l.ids[0], l.ids[1:]
+	return
+}
+
+func TestNewRetries(t *testing.T) {
+	const fname = "TestNewRetries"
+	s := Init()
+	used, fresh := uuid.New(), uuid.New()
+	if _, err := s.Create(used, 0); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	s.IDSource(&listSource{ids: []uuid.UUID{used, fresh}})
+	se, err := s.New(0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if se.ID() != fresh {
+		t.Errorf("%s: want %s got %s", fname, fresh, se.ID())
+	}
+
+	// Giving up after too many collisions.
+	s.IDSource(&listSource{ids: []uuid.UUID{used, fresh, used}})
+	_, err = s.New(0)
+	if !errors.Is(err, ErrExists) {
+		t.Errorf("%s: want ErrExists got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestV7Source(t *testing.T) {
+	const fname = "TestV7Source"
+	s := InitWith(Config{IDSource: &V7Source{}})
+	var last uuid.UUID
+	for i := 0; i < 1000; i++ {
+		se, err := s.New(60)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		id := se.ID()
+		if v := id[6] >> 4; v != 7 {
+			t.Fatalf("%s: want version 7 got %d", fname, v)
+		}
+		if bytes.Compare(id[:], last[:]) <= 0 {
+			t.Fatalf("%s: want %v after %v", fname, id, last)
+		}
+		last = id
+	}
+
+	// A stopped clock still yields increasing ids.
+	v := &V7Source{Clock: newFakeClock()}
+	a, b := v.New(), v.New()
+	if bytes.Compare(b[:], a[:]) <= 0 {
+		t.Errorf("%s: want %v after %v", fname, b, a)
+	}
+
+	// Both versions are accepted.
+	for _, sid := range []uuid.UUID{uuid.New(), v.New()} {
+		if _, err := s.Create(sid, 60); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if _, err := s.Restore(sid); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+}
+
+// recordLogger keeps the events logged to it.
+type recordLogger struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (r *recordLogger) Info(pkg, fname, event string, args ...interface{}) {
+	e := map[string]interface{}{"pkg": pkg, "event": event}
+	for i := 0; i+1 < len(args); i += 2 {
+		e[args[i].(string)] = args[i+1]
+	}
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+func (r *recordLogger) Debug(err error, pkg, fname, event string, args ...interface{}) {
+	r.Info(pkg, fname, event, args...)
+}
+
+func (r *recordLogger) Err(err error, pkg, fname, event string, args ...interface{}) {
+	r.Info(pkg, fname, event, args...)
+}
+
+func (r *recordLogger) Fatal(pkg, fname, event string, args ...interface{}) {
+	r.Info(pkg, fname, event, args...)
+}
+
+func (r *recordLogger) find(event string) (map[string]interface{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.events {
+		if e["event"] == event {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func TestStatsInterval(t *testing.T) {
+	const fname = "TestStatsInterval"
+	s := Init()
+	rec := &recordLogger{}
+	s.Logger(rec)
+	ids := newIDs(3)
+	for _, id := range ids {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	s.Destroy(ids[0])
+	s.StatsInterval(10 * time.Millisecond)
+	defer s.StatsInterval(0)
+
+	deadline := time.Now().Add(time.Second)
+	var e map[string]interface{}
+	var ok bool
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		e, ok = rec.find("session stats")
+	}
+	if !ok {
+		t.Fatalf("%s: want a stats event got none", fname)
+	}
+	want := map[string]interface{}{
+		"live": 2, "peak": 3, "created": uint64(3),
+		"destroyed": uint64(1), "expired": uint64(0),
+	}
+	for k, v := range want {
+		if e[k] != v {
+			t.Errorf("%s: want %s=%v got %v", fname, k, v, e[k])
+		}
+	}
+
+	// The interval may be changed from many goroutines, without a
+	// Logger.
+	s.Logger(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.StatsInterval(time.Duration(i+1) * time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+	time.Sleep(20 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if d := s.StatsInterval(time.Millisecond); d != 0 {
+		t.Errorf("%s: want 0 got %v", fname, d)
+	}
+}
+
+func TestPop(t *testing.T) {
+	const fname = "TestPop"
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("token", "once")
+
+	const n = 50
+	var wg sync.WaitGroup
+	got := make(chan interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := se.Pop("token")
+			if err == nil {
+				got <- v
+				return
+			}
+			if !errors.Is(err, ErrNoData) {
+				t.Errorf("%s: want ErrNoData got (%T, %+v)",
+					fname, err, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(got)
+	var count int
+	for v := range got {
+		count++
+		if v != "once" {
+			t.Errorf("%s: want \"once\" got %v", fname, v)
+		}
+	}
+	if count != 1 {
+		t.Errorf("%s: want 1 value got %d", fname, count)
+	}
+}
+
+func TestDelPrefix(t *testing.T) {
+	const fname = "TestDelPrefix"
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	keys := []string{"cart.items", "cart.total", "cart.", "user.name",
+		"user.cart"}
+	for _, k := range keys {
+		se.Set(k, k)
+	}
+	n, err := se.DelPrefix("cart.")
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if n != 3 {
+		t.Errorf("%s: want 3 deleted got %d", fname, n)
+	}
+	for _, k := range keys {
+		_, err := se.Get(k)
+		gone := errors.Is(err, ErrNoData)
+		if want := strings.HasPrefix(k, "cart."); gone != want {
+			t.Errorf("%s: %s: want deleted %t got %t", fname, k,
+				want, gone)
+		}
+	}
+}
+
+func TestRestoreMany(t *testing.T) {
+	const fname = "TestRestoreMany"
+	s := InitWith(Config{RecordOps: 16})
+	ids := newIDs(6)
+	for _, id := range ids[:4] {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	got, err := s.RestoreMany(append(ids, uuid.Nil))
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(got) != 4 {
+		t.Errorf("%s: want 4 sessions got %d", fname, len(got))
+	}
+	for _, id := range ids[:4] {
+		if se, ok := got[id]; !ok || !se.Valid() {
+			t.Errorf("%s: want %s restored", fname, id)
+		}
+	}
+	// Each is recorded as by Restore.
+	var restored int
+	for _, op := range s.RecentOps() {
+		if op.Kind == OpRestore {
+			restored++
+		}
+	}
+	if restored != len(ids) {
+		t.Errorf("%s: want %d restores recorded got %d", fname, len(ids),
+			restored)
+	}
+}
+
+// benchIDs returns a store holding n sessions and their ids.
+func benchIDs(b *testing.B, n int) (*Store, []uuid.UUID) {
+	s := Init()
+	ids := newIDs(n)
+	for _, id := range ids {
+		if _, err := s.Create(id, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s, ids
+}
+
+func BenchmarkRestoreMany(b *testing.B) {
+	s, ids := benchIDs(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.RestoreMany(ids)
+	}
+}
+
+func BenchmarkRestoreLoop(b *testing.B) {
+	s, ids := benchIDs(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, id := range ids {
+			s.Restore(id)
+		}
+	}
+}
+
+func BenchmarkDestroyMany(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s, ids := benchIDs(b, 100)
+		b.StartTimer()
+		s.DestroyMany(ids)
+	}
+}
+
+func BenchmarkDestroyLoop(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s, ids := benchIDs(b, 100)
+		b.StartTimer()
+		for _, id := range ids {
+			s.Destroy(id)
+		}
+	}
+}
+
+// benchChurn destroys the oldest of 10000 sessions and creates another
+// in its place, b.N times.
+func benchChurn(b *testing.B, compactAt int) {
+	s := InitWith(Config{CompactAt: compactAt})
+	ids := newIDs(10000)
+	for _, id := range ids {
+		if _, err := s.Create(id, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Destroy(ids[i%len(ids)])
+		ids[i%len(ids)] = uuid.New()
+		s.Create(ids[i%len(ids)], 0)
+	}
+}
+
+func BenchmarkChurn(b *testing.B) {
+	benchChurn(b, 0)
+}
+
+func BenchmarkChurnCompactAt(b *testing.B) {
+	benchChurn(b, 1000)
+}
+
+func TestFlash(t *testing.T) {
+	const fname = "TestFlash"
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("notice", "regular")
+	for _, msg := range []string{"saved", "mailed"} {
+		if err := se.Flash("notice", msg); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	got, err := se.Flashes("notice")
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(got) != 2 || got[0] != "saved" || got[1] != "mailed" {
+		t.Errorf("%s: want [saved mailed] got %v", fname, got)
+	}
+	got, err = se.Flashes("notice")
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(got) != 0 {
+		t.Errorf("%s: want [] got %v", fname, got)
+	}
+	// The regular key is untouched.
+	if v, _ := se.Get("notice"); v != "regular" {
+		t.Errorf("%s: want \"regular\" got %v", fname, v)
+	}
+
+	// The flash keys are reserved and hidden.
+	se.Flash("notice", "kept")
+	sets := map[string]func() error{
+		"Set": func() error {
+			return se.Set(flashPrefix+"notice", "forged")
+		},
+		"SetMany": func() error {
+			return se.SetMany(map[string]interface{}{flashPrefix + "x": 1})
+		},
+		"RenameKey": func() error {
+			return se.RenameKey("notice", flashPrefix+"notice")
+		},
+	}
+	for name, set := range sets {
+		if err := set(); !errors.Is(err, ErrReservedKey) {
+			t.Errorf("%s: %s: want ErrReservedKey got (%T, %+v)", fname, name,
+				err, err)
+		}
+	}
+	if keys, _ := se.Keys(); !reflect.DeepEqual(keys, []string{"notice"}) {
+		t.Errorf("%s: want [notice] got %v", fname, keys)
+	}
+	if n, err := se.DelPrefix(""); n != 1 || err != nil {
+		t.Errorf("%s: want (1, <nil>) got (%d, %v)", fname, n, err)
+	}
+	if got, _ := se.Flashes("notice"); len(got) != 1 || got[0] != "kept" {
+		t.Errorf("%s: want [kept] got %v", fname, got)
+	}
+}
+
+func TestZeroSession(t *testing.T) {
+	const fname = "TestZeroSession"
+	var se Session
+	if se.Valid() {
+		t.Errorf("%s: want invalid", fname)
+	}
+	s := Init()
+	live, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	calls := map[string]func() error{
+		"Set": func() error { return se.Set("k", 1) },
+		"Get": func() error { _, err := se.Get("k"); return err },
+		"GetDefault": func() error {
+			_, err := se.GetDefault("k", 1)
+			return err
+		},
+		"Del": func() error { return se.Del("k") },
+		"Pop": func() error { _, err := se.Pop("k"); return err },
+		"DelPrefix": func() error {
+			_, err := se.DelPrefix("k")
+			return err
+		},
+		"Merge":      func() error { return se.Merge(live, PreferSelf) },
+		"MergeOther": func() error { return live.Merge(se, PreferSelf) },
+		"RenewIf": func() error {
+			_, err := se.RenewIf(func(map[string]interface{}) bool {
+				return true
+			})
+			return err
+		},
+		"Size":  func() error { _, err := se.Size(); return err },
+		"Flash": func() error { return se.Flash("k", 1) },
+		"Flashes": func() error {
+			_, err := se.Flashes("k")
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("%s: %s: want ErrInvalidSession got (%T, %+v)",
+				fname, name, err, err)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	const fname = "TestValid"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock})
+	id := uuid.New()
+	se, err := s.Create(id, 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !se.Valid() || !se.ValidContext(context.Background()) {
+		t.Errorf("%s: want a fresh session valid", fname)
+	}
+	other, err := s.Restore(id)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Destroy(other.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if se.Valid() {
+		t.Errorf("%s: want a destroyed session invalid", fname)
+	}
+
+	// Expiry is seen before any sweep.
+	se, err = s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(61 * time.Second)
+	if se.Valid() {
+		t.Errorf("%s: want an expired session invalid", fname)
+	}
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+
+	se, err = s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The store is stalled such that only the context may answer.
+	started, stall := make(chan struct{}), make(chan struct{})
+	go s.exec(func() {
+		close(started)
+		<-stall
+	})
+	<-started
+	if se.ValidContext(ctx) {
+		t.Errorf("%s: want false once the context is done", fname)
+	}
+	close(stall)
+}
+
+func TestGoneCause(t *testing.T) {
+	const fname = "TestGoneCause"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	expired, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	destroyed, err := s.Create(uuid.New(), 600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Destroy(destroyed.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(61 * time.Second)
+
+	_, err = expired.Get("k")
+	if !errors.Is(err, ErrTimedOut) || errors.Is(err, ErrDestroyed) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+	_, err = destroyed.Get("k")
+	if !errors.Is(err, ErrDestroyed) || errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrDestroyed got (%T, %+v)", fname, err, err)
+	}
+	// Both remain not found.
+	for _, se := range []Session{expired, destroyed} {
+		if _, err := s.Restore(se.ID()); !errors.Is(err, ErrNoSession) {
+			t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+		}
+	}
+	// A session never seen has no cause.
+	_, err = s.Restore(uuid.New())
+	if !errors.Is(err, ErrNoSession) || errors.Is(err, ErrTimedOut) ||
+		errors.Is(err, ErrDestroyed) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	const fname = "TestQueueDepth"
+	s := InitWith(Config{QueueSize: 8})
+	if n := s.QueueCapacity(); n != 8 {
+		t.Errorf("%s: want capacity 8 got %d", fname, n)
+	}
+
+	// Stall the server then queue commands behind it.
+	stall := make(chan struct{})
+	stalled := make(chan struct{})
+	go s.exec(func() {
+		close(stalled)
+		<-stall
+	})
+	<-stalled
+	const queued = 5
+	var wg sync.WaitGroup
+	for i := 0; i < queued; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.sweep()
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.QueueDepth() < queued && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// The timer may have queued a command of its own.
+	if n := s.QueueDepth(); n < queued || n > queued+1 {
+		t.Errorf("%s: want depth %d got %d", fname, queued, n)
+	}
+
+	close(stall)
+	wg.Wait()
+	if n := s.QueueDepth(); n != 0 {
+		t.Errorf("%s: want depth 0 got %d", fname, n)
+	}
+}
+
+func TestDestroyAfter(t *testing.T) {
+	const fname = "TestDestroyAfter"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	ids := newIDs(2)
+	for _, id := range ids {
+		if _, err := s.Create(id, 3600); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	at := clock.Now().Add(time.Minute)
+	for _, id := range ids {
+		if err := s.DestroyAfter(id, at); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if err := s.CancelDestroy(ids[1]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// Recent use does not save a session from its schedule.
+	clock.Advance(50 * time.Second)
+	for _, id := range ids {
+		if _, err := s.Restore(id); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	clock.Advance(10 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	_, err := s.Restore(ids[0])
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Restore(ids[1]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	err = s.DestroyAfter(uuid.New(), at)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestRejectExpired(t *testing.T) {
+	const fname = "TestRejectExpired"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock, RejectExpired: true})
+	_, err := s.Create(uuid.New(), 60, WithDeadline(clock.Now().Add(-time.Second)))
+	if !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	id := uuid.New()
+	if _, err := s.Create(id, 60, WithDeadline(clock.Now().Add(time.Second))); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(time.Second)
+	if _, err := s.Restore(id); !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+
+	// A snapshot taken long ago is refused when importing.
+	snap := Snapshot{
+		ID:       uuid.New(),
+		Created:  clock.Now().Add(-time.Hour),
+		Modified: clock.Now().Add(-time.Hour),
+		MaxAge:   time.Minute,
+	}
+	if _, err := s.Import(snap); !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+	// Unless the store is not to reject it.
+	r := InitWith(Config{Clock: clock})
+	if _, err := r.Import(snap); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestContextObject(t *testing.T) {
+	const fname = "TestContextObject"
+	type user struct{ name string }
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("theme", "dark")
+	u := &user{name: "ann"}
+	if err := se.SetContextObject(u); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// The object is shared by every copy of the session.
+	restored, err := s.Restore(se.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if got, _ := restored.ContextObject().(*user); got != u {
+		t.Errorf("%s: want %p got %p", fname, u, got)
+	}
+
+	keys, err := se.Keys()
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(keys) != 1 || keys[0] != "theme" {
+		t.Errorf("%s: want [theme] got %v", fname, keys)
+	}
+	b, err := se.MarshalJSON()
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if string(b) != `{"theme":"dark"}` {
+		t.Errorf("%s: want {\"theme\":\"dark\"} got %s", fname, b)
+	}
+	if n, _ := se.Size(); n != sizeOf("theme", "dark") {
+		t.Errorf("%s: want size %d got %d", fname, sizeOf("theme", "dark"), n)
+	}
+
+	if err := se.SetContextObject(nil); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v := se.ContextObject(); v != nil {
+		t.Errorf("%s: want <nil> got %v", fname, v)
+	}
+}
+
+func TestDefaultMaxAge(t *testing.T) {
+	const fname = "TestDefaultMaxAge"
+	s := InitWith(Config{Period: time.Hour})
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if se.maxage != 30*time.Minute {
+		t.Errorf("%s: want 30m0s got %v", fname, se.maxage)
+	}
+
+	s.DefaultMaxAge(5 * time.Minute)
+	se, err = s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if se.maxage != 5*time.Minute {
+		t.Errorf("%s: want 5m0s got %v", fname, se.maxage)
+	}
+	// An explicit maxage takes precedence.
+	se, err = s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if se.maxage != time.Minute {
+		t.Errorf("%s: want 1m0s got %v", fname, se.maxage)
+	}
+}
+
+func TestVerifyOwner(t *testing.T) {
+	const fname = "TestVerifyOwner"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.SetOwner("ann"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// Checking does not extend the session.
+	clock.Advance(50 * time.Second)
+	ok, err := s.VerifyOwner(se.ID(), "ann")
+	if !ok || err != nil {
+		t.Errorf("%s: want true <nil> got %v (%T, %+v)", fname, ok, err, err)
+	}
+	ok, err = s.VerifyOwner(se.ID(), "bob")
+	if ok || !errors.Is(err, ErrNotOwner) {
+		t.Errorf("%s: want false ErrNotOwner got %v (%T, %+v)", fname, ok,
+			err, err)
+	}
+	clock.Advance(11 * time.Second)
+	ok, err = s.VerifyOwner(se.ID(), "ann")
+	if ok || !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want false ErrNoSession got %v (%T, %+v)", fname, ok,
+			err, err)
+	}
+	ok, err = s.VerifyOwner(uuid.New(), "ann")
+	if ok || !errors.Is(err, ErrNoSession) || errors.Is(err, ErrNotOwner) {
+		t.Errorf("%s: want false ErrNoSession got %v (%T, %+v)", fname, ok,
+			err, err)
+	}
+}
+
+func TestCreateExclusive(t *testing.T) {
+	const fname = "TestCreateExclusive"
+	s := Init()
+	other, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := other.SetOwner("alice"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	first, prior, err := s.CreateExclusive(uuid.New(), 0, "bob")
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(prior) != 0 {
+		t.Errorf("%s: want no prior sessions got %v", fname, prior)
+	}
+	second, prior, err := s.CreateExclusive(uuid.New(), 0, "bob")
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(prior) != 1 || prior[0] != first.ID() {
+		t.Errorf("%s: want [%v] got %v", fname, first.ID(), prior)
+	}
+	if _, err := s.Restore(first.ID()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if owner, err := second.Owner(); err != nil || owner != "bob" {
+		t.Errorf("%s: want (bob, <nil>) got (%v, %v)", fname, owner, err)
+	}
+	// The sessions of other users are left alone.
+	if _, err := s.Restore(other.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// A failed creation destroys nothing.
+	_, prior, err = s.CreateExclusive(second.ID(), 0, "bob")
+	if err == nil {
+		t.Errorf("%s: want error got <nil>", fname)
+	}
+	if len(prior) != 0 {
+		t.Errorf("%s: want no prior sessions got %v", fname, prior)
+	}
+	if _, err := s.Restore(second.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestCreateExclusiveCapacity(t *testing.T) {
+	const fname = "TestCreateExclusiveCapacity"
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s := InitWith(Config{WAL: path})
+	s.MaxSessions(1)
+	first, _, err := s.CreateExclusive(uuid.New(), 0, "bob")
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// The prior session of the user makes room for the new one.
+	second, prior, err := s.CreateExclusive(uuid.New(), 0, "bob")
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(prior) != 1 || prior[0] != first.ID() {
+		t.Errorf("%s: want [%v] got %v", fname, first.ID(), prior)
+	}
+	if _, _, err := s.CreateExclusive(uuid.New(), 0, "alice"); !errors.Is(err, ErrCapacity) {
+		t.Errorf("%s: want ErrCapacity got (%T, %+v)", fname, err, err)
+	}
+
+	// The owner and the destruction are both logged.
+	r := InitWith(Config{WAL: path})
+	if _, err := r.Restore(first.ID()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if ok, err := r.VerifyOwner(second.ID(), "bob"); !ok || err != nil {
+		t.Errorf("%s: want (true, <nil>) got (%v, %v)", fname, ok, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestTag(t *testing.T) {
+	const fname = "TestTag"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock})
+	var ses []Session
+	for i := 0; i < 4; i++ {
+		se, err := s.Create(uuid.New(), 60)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		ses = append(ses, se)
+	}
+	// 0 admin, 1 admin and cohort, 2 cohort, 3 untagged.
+	tags := []struct {
+		se  Session
+		tag string
+	}{
+		{ses[0], "admin"}, {ses[1], "admin"}, {ses[1], "cohort"},
+		{ses[2], "cohort"}, {ses[2], "cohort"},
+	}
+	for _, tt := range tags {
+		if err := tt.se.Tag(tt.tag); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	want := map[string][]uuid.UUID{
+		"admin":  {ses[0].ID(), ses[1].ID()},
+		"cohort": {ses[1].ID(), ses[2].ID()},
+	}
+	for tag, ids := range want {
+		if got := s.SessionsWithTag(tag); !reflect.DeepEqual(got, ids) {
+			t.Errorf("%s: %s: want %v got %v", fname, tag, ids, got)
+		}
+	}
+	if err := ses[1].Untag("admin"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if got := s.SessionsWithTag("admin"); len(got) != 1 || got[0] != ses[0].ID() {
+		t.Errorf("%s: want [%v] got %v", fname, ses[0].ID(), got)
+	}
+
+	if n, err := s.DestroyTag("cohort"); n != 2 || err != nil {
+		t.Errorf("%s: want (2, <nil>) got (%d, %v)", fname, n, err)
+	}
+	if got := s.SessionsWithTag("cohort"); len(got) != 0 {
+		t.Errorf("%s: want none got %v", fname, got)
+	}
+	for _, i := range []int{0, 3} {
+		if _, err := s.Restore(ses[i].ID()); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+
+	// Expiry keeps the index consistent.
+	clock.Advance(2 * time.Minute)
+	s.sweep()
+	if got := s.SessionsWithTag("admin"); len(got) != 0 {
+		t.Errorf("%s: want none got %v", fname, got)
+	}
+	s.exec(func() {
+		if len(s.tagged) != 0 || len(s.tagsOf) != 0 {
+			t.Errorf("%s: want an empty index got %v %v", fname,
+				s.tagged, s.tagsOf)
+		}
+	})
+}
+
+func TestRegenerate(t *testing.T) {
+	const fname = "TestRegenerate"
+	s := Init()
+	parent, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	old, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	child, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Link(parent.ID(), old.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Link(old.ID(), child.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := old.Set("user", "bob"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := old.Tag("admin"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	se, err := old.Regenerate()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if se.ID() == old.ID() {
+		t.Errorf("%s: want a new SID got %v", fname, se.ID())
+	}
+	if v, err := se.Get("user"); err != nil || v != "bob" {
+		t.Errorf("%s: want (bob, <nil>) got (%v, %v)", fname, v, err)
+	}
+	if got := s.SessionsWithTag("admin"); len(got) != 1 || got[0] != se.ID() {
+		t.Errorf("%s: want [%v] got %v", fname, se.ID(), got)
+	}
+	_, err = s.Restore(old.ID())
+	if !errors.Is(err, ErrDestroyed) || !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrDestroyed got (%T, %+v)", fname, err, err)
+	}
+	if _, err := old.Get("user"); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if info, ok := s.Tombstone(old.ID()); !ok || info.Reason != ReasonRegen {
+		t.Errorf("%s: want %q got (%+v, %v)", fname, ReasonRegen, info, ok)
+	}
+	if _, err := s.Restore(child.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// The links moved with the session.
+	if err := s.Destroy(parent.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for _, sid := range []uuid.UUID{se.ID(), child.ID()} {
+		if _, err := s.Restore(sid); !errors.Is(err, ErrNoSession) {
+			t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+		}
+	}
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+}
+
+func TestRegenerateFailure(t *testing.T) {
+	const fname = "TestRegenerateFailure"
+	// A full store has room for the new SID in that of the old.
+	s := InitWith(Config{MaxSessions: 1})
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("k", "v")
+	se, err = se.Regenerate()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+
+	// Should the new session not be added the old remains.
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s = InitWith(Config{WAL: path})
+	se, err = s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("k", "v")
+	s.exec(func() {
+		s.wal.f.Close()
+	})
+	if _, err := se.Regenerate(); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	if v, err := se.Get("k"); err != nil || v != "v" {
+		t.Errorf("%s: want (v, <nil>) got (%v, %v)", fname, v, err)
+	}
+}
+
+func TestRegenerateRace(t *testing.T) {
+	const fname = "TestRegenerateRace"
+	const n = 200
+	ids := newIDs(n + 1)
+	s := Init()
+	s.IDSource(&listSource{ids: append([]uuid.UUID(nil), ids[1:]...)})
+	se, err := s.Create(ids[0], 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r; ; i = (i + 1) % n {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Restore the newer SID first: once it is seen, the
+				// older must be gone.
+				next, errNext := s.Restore(ids[i+1])
+				prev, errPrev := s.Restore(ids[i])
+				for _, err := range []error{errNext, errPrev} {
+					if err != nil && !errors.Is(err, ErrNoSession) {
+						t.Errorf("%s: want ErrNoSession got (%T, %+v)",
+							fname, err, err)
+					}
+				}
+				if errNext == nil && next.ID() != ids[i+1] {
+					t.Errorf("%s: want %v got %v", fname, ids[i+1], next.ID())
+				}
+				if errPrev == nil && prev.ID() != ids[i] {
+					t.Errorf("%s: want %v got %v", fname, ids[i], prev.ID())
+				}
+				if errNext == nil && errPrev == nil {
+					t.Errorf("%s: %v restored after %v", fname, ids[i], ids[i+1])
+				}
+			}
+		}(r)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// Exactly one SID of the chain is live at any time.
+			s.exec(func() {
+				var live int
+				for _, sid := range ids {
+					if _, ok := s.sessions[sid]; ok {
+						live++
+					}
+				}
+				if live != 1 {
+					t.Errorf("%s: want 1 live SID got %d", fname, live)
+				}
+			})
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		se, err = se.Regenerate()
+		if err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+	if se.ID() != ids[n] {
+		t.Errorf("%s: want %v got %v", fname, ids[n], se.ID())
+	}
+}
+
+func TestNoImplicitTouch(t *testing.T) {
+	const fname = "TestNoImplicitTouch"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock, NoImplicitTouch: true})
+	busy, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	kept, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for i := 0; i < 2; i++ {
+		clock.Advance(20 * time.Second)
+		if err := busy.Set("n", i); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if _, err := busy.Get("n"); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if err := kept.Touch(); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+
+	// Activity alone does not keep the session alive.
+	clock.Advance(30 * time.Second)
+	if err := busy.Set("n", 2); !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+	if err := kept.Set("n", 2); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+
+	// Touch is that which the session lives from.
+	clock.Advance(61 * time.Second)
+	if err := kept.Touch(); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestSweepPhase(t *testing.T) {
+	const fname = "TestSweepPhase"
+	const period = time.Minute
+	slept := make(chan time.Duration)
+	var calls int
+	InitWith(Config{
+		Period: period,
+		Rand:   rand.New(rand.NewSource(1)),
+		sleep: func(d time.Duration) {
+			calls++
+			if calls > 2 {
+				select {}
+			}
+			slept <- d
+		},
+	})
+	want := time.Duration(rand.New(rand.NewSource(1)).Int63n(int64(period)))
+	if d := <-slept; d != want {
+		t.Errorf("%s: want first sweep after %v got %v", fname, want, d)
+	}
+	if d := <-slept; d != period {
+		t.Errorf("%s: want next sweep after %v got %v", fname, period, d)
+	}
+}
+
+func TestSetManyR(t *testing.T) {
+	const fname = "TestSetManyR"
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	err = se.SetMany(map[string]interface{}{
+		"same":  []int{1, 2},
+		"other": "a",
+	})
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	res, err := se.SetManyR(map[string]interface{}{
+		"same":  []int{1, 2},
+		"other": "b",
+		"new":   1,
+	})
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	want := SetManyResult{"same": KeyUnchanged, "other": KeyOverwritten,
+		"new": KeyCreated}
+	for k, st := range want {
+		if res[k] != st {
+			t.Errorf("%s: %s: want %v got %v", fname, k, st, res[k])
+		}
+	}
+	if len(res) != len(want) {
+		t.Errorf("%s: want %v got %v", fname, want, res)
+	}
+	if v, _ := se.Get("other"); v != "b" {
+		t.Errorf("%s: want \"b\" got %v", fname, v)
+	}
+}
+
+func TestSetAll(t *testing.T) {
+	const fname = "TestSetAll"
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("kept", 1)
+	se.Set("over", 1)
+	n, err := se.SetAll(map[string]interface{}{"over": 2, "new": 3})
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if n != 2 {
+		t.Errorf("%s: want 2 got %d", fname, n)
+	}
+	want := map[string]interface{}{"kept": 1, "over": 2, "new": 3}
+	for k, v := range want {
+		if got, _ := se.Get(k); got != v {
+			t.Errorf("%s: %s: want %v got %v", fname, k, v, got)
+		}
+	}
+	s.Destroy(se.ID())
+	_, err = se.SetAll(map[string]interface{}{"k": 1})
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+
+	// Keys that normalize alike are written, and counted, once.
+	s = InitWith(Config{NormalizeKey: strings.ToLower})
+	se, err = s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	n, err = se.SetAll(map[string]interface{}{"Tier": "gold", "tier": "gold"})
+	if n != 1 || err != nil {
+		t.Errorf("%s: want (1, <nil>) got (%d, %v)", fname, n, err)
+	}
+}
+
+// TestReadYourWrites guards against any asynchronous write path, such as
+// coalesced touches, leaving a caller unable to see its own writes.
+func TestReadYourWrites(t *testing.T) {
+	const fname = "TestReadYourWrites"
+	s := Init()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			se, err := s.Create(uuid.New(), 0)
+			if err != nil {
+				t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+				return
+			}
+			for i := 0; i < 200; i++ {
+				if err := se.Set("n", i); err != nil {
+					t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+					return
+				}
+				restored, err := s.Restore(se.ID())
+				if err != nil {
+					t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+					return
+				}
+				if v, _ := restored.Get("n"); v != i {
+					t.Errorf("%s: want %d got %v", fname, i, v)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMaxCreates(t *testing.T) {
+	const fname = "TestMaxCreates"
+	const limit, callers = 3, 10
+	s := InitWith(Config{MaxCreates: limit})
+
+	// Stall the server such that creates remain in flight.
+	stall := make(chan struct{})
+	stalled := make(chan struct{})
+	go s.exec(func() {
+		close(stalled)
+		<-stall
+	})
+	<-stalled
+	results := make(chan error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Create(uuid.New(), 0)
+			results <- err
+		}()
+	}
+	// Those beyond the limit fail at once.
+	for i := 0; i < callers-limit; i++ {
+		if err := <-results; !errors.Is(err, ErrBusy) {
+			t.Errorf("%s: want ErrBusy got (%T, %+v)", fname, err, err)
+		}
+	}
+	// Waiting gives up with the context.
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, err := s.CreateContext(ctx, uuid.New(), 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%s: want DeadlineExceeded got (%T, %+v)", fname, err, err)
+	}
+
+	close(stall)
+	wg.Wait()
+	for i := 0; i < limit; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if _, err := s.CreateContext(context.Background(), uuid.New(), 0); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	const fname = "TestFingerprint"
+	s := Init()
+	a, _ := s.Create(uuid.New(), 0)
+	b, _ := s.Create(uuid.New(), 0)
+	a.Set("x", 1)
+	a.Set("y", []string{"p", "q"})
+	b.Set("y", []string{"p", "q"})
+	b.Set("x", 1)
+
+	fa, err := a.Fingerprint()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if again, _ := a.Fingerprint(); again != fa {
+		t.Errorf("%s: want %x got %x", fname, fa, again)
+	}
+	if fb, _ := b.Fingerprint(); fb != fa {
+		t.Errorf("%s: want %x got %x", fname, fa, fb)
+	}
+	a.Set("x", 2)
+	if changed, _ := a.Fingerprint(); changed == fa {
+		t.Errorf("%s: want fingerprint changed", fname)
+	}
+	a.Set("fn", func() {})
+	if _, err := a.Fingerprint(); err == nil {
+		t.Errorf("%s: want error got <nil>", fname)
+	}
+}
+
+func TestCompareAndTouch(t *testing.T) {
+	const fname = "TestCompareAndTouch"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock, NoImplicitTouch: true})
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("x", 1)
+	fp, err := se.Fingerprint()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// A change made concurrently since the fingerprint skips the touch.
+	clock.Advance(10 * time.Second)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := se.Set("x", 2); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}()
+	wg.Wait()
+	touched, err := se.CompareAndTouch(fp)
+	if err != nil || touched {
+		t.Errorf("%s: want (false, <nil>) got (%v, %v)", fname, touched, err)
+	}
+	if idle, _ := se.IdleTime(); idle != 10*time.Second {
+		t.Errorf("%s: want idle 10s got %v", fname, idle)
+	}
+
+	if fp, err = se.Fingerprint(); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	touched, err = se.CompareAndTouch(fp)
+	if err != nil || !touched {
+		t.Errorf("%s: want (true, <nil>) got (%v, %v)", fname, touched, err)
+	}
+	if idle, _ := se.IdleTime(); idle != 0 {
+		t.Errorf("%s: want idle 0s got %v", fname, idle)
+	}
+
+	se.Set("fn", func() {})
+	if _, err := se.CompareAndTouch(fp); err == nil {
+		t.Errorf("%s: want error got <nil>", fname)
+	}
+	s.Destroy(se.ID())
+	if _, err := se.CompareAndTouch(fp); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestOnNearExpiry(t *testing.T) {
+	const fname = "TestOnNearExpiry"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	var warned []uuid.UUID
+	s.OnNearExpiry(20*time.Second, func(sid uuid.UUID) {
+		warned = append(warned, sid)
+	})
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(30 * time.Second)
+	s.sweep()
+	if len(warned) != 0 {
+		t.Errorf("%s: want no warning got %v", fname, warned)
+	}
+	clock.Advance(15 * time.Second)
+	s.sweep()
+	clock.Advance(5 * time.Second)
+	s.sweep()
+	if len(warned) != 1 || warned[0] != se.ID() {
+		t.Errorf("%s: want [%s] got %v", fname, se.ID(), warned)
+	}
+
+	// Use clears the warning, such that it is given once again.
+	if _, err := s.Restore(se.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(45 * time.Second)
+	s.sweep()
+	if len(warned) != 2 {
+		t.Errorf("%s: want 2 warnings got %d", fname, len(warned))
+	}
+	clock.Advance(16 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	if len(warned) != 2 {
+		t.Errorf("%s: want 2 warnings got %d", fname, len(warned))
+	}
+}
+
+func TestOnExpireBatch(t *testing.T) {
+	const fname = "TestOnExpireBatch"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	var batches [][]uuid.UUID
+	s.OnExpireBatch(func(sids []uuid.UUID) {
+		batches = append(batches, sids)
+	})
+	ids := newIDs(50)
+	for _, id := range ids {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	clock.Advance(time.Hour)
+	s.sweep()
+	var got []uuid.UUID
+	s.exec(func() {
+		if len(batches) == 1 {
+			got = batches[0]
+		}
+	})
+	if len(got) != len(ids) {
+		t.Fatalf("%s: want one batch of %d got %v", fname, len(ids), batches)
+	}
+	seen := make(map[uuid.UUID]bool, len(got))
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			t.Errorf("%s: want %v in batch", fname, id)
+		}
+	}
+}
+
+func TestSweepSink(t *testing.T) {
+	const fname = "TestSweepSink"
+	clock := newFakeClock()
+	var mu sync.Mutex
+	var batches [][]uuid.UUID
+	s := InitWith(Config{
+		Clock:  clock,
+		Period: time.Hour,
+		SweepSink: SweepSinkFunc(func(sids []uuid.UUID) {
+			mu.Lock()
+			defer mu.Unlock()
+			batches = append(batches, sids)
+		}),
+	})
+	ids := newIDs(4)
+	for i, maxage := range []int{60, 600, 600, 60} {
+		if _, err := s.Create(ids[i], maxage); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	// The child goes with its expired parent, the destroyed session
+	// is not the sweeps.
+	if err := s.Link(ids[0], ids[2]); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Destroy(ids[3]); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(2 * time.Minute)
+	s.sweep()
+	s.sweep()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 1 {
+		t.Fatalf("%s: want 1 batch got %v", fname, batches)
+	}
+	got := map[uuid.UUID]bool{}
+	for _, sid := range batches[0] {
+		got[sid] = true
+	}
+	want := map[uuid.UUID]bool{ids[0]: true, ids[2]: true}
+	if !reflect.DeepEqual(got, want) || len(batches[0]) != 2 {
+		t.Errorf("%s: want %v got %v", fname, want, batches[0])
+	}
+}
+
+func TestOnEvictBatch(t *testing.T) {
+	const fname = "TestOnEvictBatch"
+	s := Init()
+	var single []uuid.UUID
+	var batches [][]uuid.UUID
+	s.OnEvict(func(sid uuid.UUID) {
+		single = append(single, sid)
+	})
+	s.OnEvictBatch(func(sids []uuid.UUID) {
+		batches = append(batches, sids)
+	})
+	for _, id := range newIDs(5) {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	s.Policy(EvictOldestCreated)
+	s.MaxSessions(2)
+	if _, err := s.Create(uuid.New(), 0); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	s.exec(func() {
+		if len(single) != 4 {
+			t.Errorf("%s: want 4 evictions got %v", fname, single)
+		}
+		if len(batches) != 1 || len(batches[0]) != 4 {
+			t.Errorf("%s: want one batch of 4 got %v", fname, batches)
+		}
+	})
+}
+
+func TestName(t *testing.T) {
+	const fname = "TestName"
+	rec := &recordLogger{}
+	s := InitWith(Config{Name: "auth", Logger: rec,
+		StatsInterval: 10 * time.Millisecond})
+	defer s.StatsInterval(0)
+	if s.Name() != "auth" {
+		t.Errorf("%s: want auth got %q", fname, s.Name())
+	}
+	deadline := time.Now().Add(time.Second)
+	var e map[string]interface{}
+	var ok bool
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		e, ok = rec.find("session stats")
+	}
+	if !ok {
+		t.Fatalf("%s: want a stats event got none", fname)
+	}
+	if e["pkg"] != "auth" {
+		t.Errorf("%s: want pkg=auth got %v", fname, e["pkg"])
+	}
+	if l := Init().label(); l != pkg {
+		t.Errorf("%s: want %s got %s", fname, pkg, l)
+	}
+}
+
+func TestClockJump(t *testing.T) {
+	const fname = "TestClockJump"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	id := uuid.New()
+	if _, err := s.Create(id, 60); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	s.sweep()
+
+	// Set back an hour, the session is neither reaped at once nor kept
+	// for an hour more.
+	clock.Advance(30 * time.Second)
+	clock.Advance(-time.Hour)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	clock.Advance(59 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	clock.Advance(2 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+
+	// An imported session last used in the future expires in time.
+	snap := Snapshot{ID: uuid.New(), Created: clock.Now(),
+		Modified: clock.Now().Add(time.Hour), MaxAge: time.Minute}
+	if _, err := s.Import(snap); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(61 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+}
+
+func TestRecentOps(t *testing.T) {
+	const fname = "TestRecentOps"
+	s := InitWith(Config{RecordOps: 4})
+	if ops := s.RecentOps(); len(ops) != 0 {
+		t.Errorf("%s: want no ops got %v", fname, ops)
+	}
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	id := se.ID()
+	se.Set("a", "secret")
+	se.Get("a")
+	s.Restore(id)
+	se.Del("a")
+	s.Destroy(id)
+
+	// The oldest are overwritten.
+	want := []Op{
+		{Kind: OpGet, SID: id, Key: "a"},
+		{Kind: OpRestore, SID: id},
+		{Kind: OpDel, SID: id, Key: "a"},
+		{Kind: OpDestroy, SID: id},
+	}
+	ops := s.RecentOps()
+	if len(ops) != len(want) {
+		t.Fatalf("%s: want %d ops got %v", fname, len(want), ops)
+	}
+	for i, op := range ops {
+		op.Time = time.Time{}
+		if op != want[i] {
+			t.Errorf("%s: want %+v got %+v", fname, want[i], op)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	const fname = "TestExpire"
+	s := Init()
+	var expired []uuid.UUID
+	s.OnExpire(func(sid uuid.UUID) {
+		expired = append(expired, sid)
+	})
+	ids := newIDs(2)
+	for _, id := range ids {
+		if _, err := s.Create(id, 3600); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if err := s.Destroy(ids[1]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Expire(ids[0]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(expired) != 1 || expired[0] != ids[0] {
+		t.Errorf("%s: want [%s] got %v", fname, ids[0], expired)
+	}
+	_, err := s.Restore(ids[0])
+	if !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+	if st := s.Stats(); st.Expired != 1 {
+		t.Errorf("%s: want 1 expired got %d", fname, st.Expired)
+	}
+	err = s.Expire(ids[0])
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestMaxValueBytes(t *testing.T) {
+	const fname = "TestMaxValueBytes"
+	s := InitWith(Config{MaxValueBytes: 8})
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("s", strings.Repeat("x", 8)); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	err = se.Set("s", strings.Repeat("x", 9))
+	if !errors.Is(err, ErrValueTooLarge) || !errors.Is(err, ErrCapacity) {
+		t.Errorf("%s: want ErrValueTooLarge got (%T, %+v)", fname, err, err)
+	}
+	err = se.Set("b", make([]byte, 9))
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("%s: want ErrValueTooLarge got (%T, %+v)", fname, err, err)
+	}
+	// The value held is unchanged and other types are exempt.
+	if v, _ := se.Get("s"); v != strings.Repeat("x", 8) {
+		t.Errorf("%s: want 8 bytes got %v", fname, v)
+	}
+	if err := se.Set("n", make([]int, 100)); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	const fname = "TestModifiedSince"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	ids := newIDs(4)
+	for _, id := range ids {
+		if _, err := s.Create(id, 3600); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	clock.Advance(time.Second)
+	cutoff := clock.Now()
+	for _, i := range []int{1, 3} {
+		if _, err := s.Restore(ids[i]); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	got := s.ModifiedSince(cutoff)
+	if len(got) != 2 || got[0] != ids[1] || got[1] != ids[3] {
+		t.Errorf("%s: want [%s %s] got %v", fname, ids[1], ids[3], got)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	const fname = "TestFreeze"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	id := uuid.New()
+	if _, err := s.Create(id, 60); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Freeze(id); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(time.Hour)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	if err := s.Unfreeze(id); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(59 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	clock.Advance(2 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	err := s.Freeze(id)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestPin(t *testing.T) {
+	const fname = "TestPin"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	id := uuid.New()
+	if _, err := s.Create(id, 1); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Pin(id); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.DestroyAfter(id, clock.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(24 * time.Hour)
+	s.sweep()
+	if _, err := s.Restore(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// A pinned session is not evicted.
+	s.Policy(EvictLRU)
+	s.MaxSessions(1)
+	if _, err := s.Create(uuid.New(), 0); !errors.Is(err, ErrCapacity) {
+		t.Errorf("%s: want ErrCapacity got (%T, %+v)", fname, err, err)
+	}
+	s.MaxSessions(0)
+
+	if err := s.Unpin(id); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	err := s.Pin(id)
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestExtendAll(t *testing.T) {
+	const fname = "TestExtendAll"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	for _, id := range newIDs(3) {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	clock.Advance(50 * time.Second)
+	if n := s.ExtendAll(time.Hour); n != 3 {
+		t.Errorf("%s: want 3 extended got %d", fname, n)
+	}
+	clock.Advance(30 * time.Minute)
+	s.sweep()
+	if n := s.count(); n != 3 {
+		t.Errorf("%s: want 3 sessions got %d", fname, n)
+	}
+	// Once the extension has lapsed the sessions expire as before.
+	clock.Advance(31 * time.Minute)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	if n := s.ExtendAll(time.Hour); n != 0 {
+		t.Errorf("%s: want 0 extended got %d", fname, n)
+	}
+}
+
+func TestDestroyMany(t *testing.T) {
+	const fname = "TestDestroyMany"
+	s := Init()
+	ids := newIDs(3)
+	for _, id := range ids {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	absent := uuid.New()
+	removed, errs := s.DestroyMany([]uuid.UUID{ids[0], absent, ids[2],
+		uuid.Nil})
+	if removed != 2 {
+		t.Errorf("%s: want 2 removed got %d", fname, removed)
+	}
+	if len(errs) != 2 {
+		t.Errorf("%s: want 2 errors got %v", fname, errs)
+	}
+	if err := errs[absent]; !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if err := errs[uuid.Nil]; !errors.Is(err, ErrPoorForm) {
+		t.Errorf("%s: want ErrPoorForm got (%T, %+v)", fname, err, err)
+	}
+	if n := s.count(); n != 1 {
+		t.Errorf("%s: want 1 session got %d", fname, n)
+	}
+	if _, err := s.Restore(ids[1]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestDestroyBadIndex(t *testing.T) {
+	const fname = "TestDestroyBadIndex"
+	s := Init()
+	ids := newIDs(4)
+	for _, id := range ids {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	// Corrupt the index of one session to point past the array and
+	// of another to point at its neighbour.
+	s.exec(func() {
+		se := s.sessions[ids[1]]
+		se.index = 99
+		s.sessions[ids[1]] = se
+		se = s.sessions[ids[2]]
+		se.index = 3
+		s.sessions[ids[2]] = se
+	})
+	for _, i := range []int{1, 2} {
+		if err := s.Destroy(ids[i]); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	for _, i := range []int{0, 3} {
+		if _, err := s.Restore(ids[i]); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	s.exec(func() {
+		if len(s.array) != 2 || s.array[0] != ids[0] || s.array[1] != ids[3] {
+			t.Errorf("%s: want [%s %s] got %v", fname, ids[0], ids[3],
+				s.array)
+		}
+		for i, id := range s.array {
+			if s.sessions[id].index != i {
+				t.Errorf("%s: want index %d got %d", fname, i,
+					s.sessions[id].index)
+			}
+		}
+	})
+}
+
+func TestIDsSorted(t *testing.T) {
+	const fname = "TestIDsSorted"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	ids := newIDs(3)
+	for _, id := range ids {
+		if _, err := s.Create(id, 3600); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		clock.Advance(time.Second)
+	}
+	// Use the first, such that the last used is not the last created.
+	s.Restore(ids[0])
+	tests := []struct {
+		by   SortBy
+		want []uuid.UUID
+	}{
+		{Unsorted, ids},
+		{CreatedAsc, ids},
+		{CreatedDesc, []uuid.UUID{ids[2], ids[1], ids[0]}},
+		{ModifiedAsc, []uuid.UUID{ids[1], ids[2], ids[0]}},
+		{ModifiedDesc, []uuid.UUID{ids[0], ids[2], ids[1]}},
+	}
+	for _, test := range tests {
+		got := s.IDs(test.by)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: %d: want %v got %v", fname, test.by, test.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: %d: want %v got %v", fname, test.by,
+					test.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	const fname = "TestRecoverPanic"
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("k", 1)
+	s.exec(func() {
+		s.testHook = func() { panic("broken") }
+	})
+	_, err = se.Get("k")
+	if !errors.Is(err, ErrInternal) {
+		t.Errorf("%s: want ErrInternal got (%T, %+v)", fname, err, err)
+	}
+	// The store carries on.
+	s.exec(func() {
+		s.testHook = nil
+	})
+	if v, err := se.Get("k"); v != 1 || err != nil {
+		t.Errorf("%s: want 1 <nil> got %v (%T, %+v)", fname, v, err, err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	const fname = "TestLink"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	ids := newIDs(5)
+	for _, id := range ids {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	parent, a, b, grand, other := ids[0], ids[1], ids[2], ids[3], ids[4]
+	for _, link := range [][2]uuid.UUID{{parent, a}, {parent, b}, {a, grand}} {
+		if err := s.Link(link[0], link[1]); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	err := s.Link(grand, parent)
+	if !errors.Is(err, ErrCycle) {
+		t.Errorf("%s: want ErrCycle got (%T, %+v)", fname, err, err)
+	}
+
+	// Destroying a child leaves its parent.
+	if err := s.Destroy(b); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Restore(parent); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// Destroying the parent takes its descendants.
+	if err := s.Destroy(parent); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for _, id := range []uuid.UUID{a, grand} {
+		_, err := s.Restore(id)
+		if !errors.Is(err, ErrNoSession) {
+			t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+		}
+	}
+	if got := s.IDs(); len(got) != 1 || got[0] != other {
+		t.Errorf("%s: want [%s] got %v", fname, other, got)
+	}
+
+	// Likewise when the parent times out.
+	child := uuid.New()
+	if _, err := s.Create(child, 3600); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Link(other, child); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(61 * time.Second)
+	s.sweep()
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	s.exec(func() {
+		if len(s.parents) != 0 || len(s.children) != 0 {
+			t.Errorf("%s: want no links got %v %v", fname, s.parents,
+				s.children)
+		}
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	const fname = "TestAggregate"
+	s := Init()
+	plans := []string{"pro", "free", "pro", "team", "pro"}
+	for i, plan := range plans {
+		se, err := s.Create(uuid.New(), 60)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		se.Set("plan", plan)
+		se.Set("spent", i+1)
+	}
+	sum := s.Aggregate(func(acc interface{}, data map[string]interface{}) interface{} {
+		return acc.(int) + data["spent"].(int)
+	}, 0)
+	if sum != 15 {
+		t.Errorf("%s: want 15 got %v", fname, sum)
+	}
+	count := s.Aggregate(func(acc interface{}, data map[string]interface{}) interface{} {
+		m := acc.(map[string]int)
+		m[data["plan"].(string)]++
+		// Changes to the copy must not reach the session.
+		data["plan"] = "none"
+		return m
+	}, map[string]int{}).(map[string]int)
+	want := map[string]int{"pro": 3, "free": 1, "team": 1}
+	if !reflect.DeepEqual(count, want) {
+		t.Errorf("%s: want %v got %v", fname, want, count)
+	}
+	after := s.Aggregate(func(acc interface{}, data map[string]interface{}) interface{} {
+		if data["plan"] == "none" {
+			return acc.(int) + 1
+		}
+		return acc
+	}, 0)
+	if after != 0 {
+		t.Errorf("%s: want 0 sessions altered got %v", fname, after)
+	}
+}
+
+// exercise runs a store through creation, use, failure and destruction,
+// such that it has cause to log every kind of event.
+func exercise(t *testing.T, s *Store) {
+	const fname = "exercise"
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("k", 1); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Restore(uuid.New()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	s.exec(func() {
+		s.testHook = func() { panic("broken") }
+	})
+	if _, err := se.Get("k"); !errors.Is(err, ErrInternal) {
+		t.Errorf("%s: want ErrInternal got (%T, %+v)", fname, err, err)
+	}
+	s.exec(func() {
+		s.testHook = nil
+	})
+	if err := s.Destroy(se.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestNoDiagnostics(t *testing.T) {
+	const fname = "TestNoDiagnostics"
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	exercise(t, Init())
+	os.Stdout, os.Stderr = stdout, stderr
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(out) > 0 {
+		t.Errorf("%s: want no output got %q", fname, out)
+	}
+
+	// Given Diagnostics the same events are passed on.
+	diag := &recordLogger{}
+	exercise(t, InitWith(Config{Diagnostics: diag}))
+	for _, event := range []string{"Session created", "recovered panic",
+		"session destroyed"} {
+		if _, ok := diag.find(event); !ok {
+			t.Errorf("%s: want event %q", fname, event)
+		}
+	}
+}
+
+func TestOnClose(t *testing.T) {
+	const fname = "TestOnClose"
+	s := Init()
+	ids := newIDs(4)
+	for i, id := range ids {
+		se, err := s.Create(id, 60)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		se.Set("n", i)
+	}
+	s.Destroy(ids[1])
+	var calls int
+	var got []Snapshot
+	s.OnClose(func(snaps []Snapshot) {
+		calls++
+		got = snaps
+	})
+	if err := s.Close(); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if calls != 1 {
+		t.Errorf("%s: want 1 call got %d", fname, calls)
+	}
+	live := []int{0, 2, 3}
+	if len(got) != len(live) {
+		t.Fatalf("%s: want %d snapshots got %d", fname, len(live), len(got))
+	}
+	for i, n := range live {
+		if got[i].ID != ids[n] || got[i].Data["n"] != n {
+			t.Errorf("%s: want %s n=%d got %s %v", fname, ids[n], n,
+				got[i].ID, got[i].Data)
+		}
+	}
+	if n := s.count(); n != 0 {
+		t.Errorf("%s: want 0 sessions got %d", fname, n)
+	}
+	_, err := s.Restore(ids[0])
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	_, err = s.Create(uuid.New(), 60)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("%s: want ErrClosed got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestCloseStops(t *testing.T) {
+	const fname = "TestCloseStops"
+	before := runtime.NumGoroutine()
+	s := InitWith(Config{Period: time.Hour})
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// The session server and the timer return without waiting out the
+	// period.
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("%s: want %d goroutines got %d", fname, before,
+				runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The store remains usable, closed.
+	if _, err := s.Restore(se.ID()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Create(uuid.New(), 60); !errors.Is(err, ErrClosed) {
+		t.Errorf("%s: want ErrClosed got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("k", "v"); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestAge(t *testing.T) {
+	const fname = "TestAge"
+	s := Init()
+	clock := newFakeClock()
+	s.Clock(clock)
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	var last time.Duration
+	for i := 1; i <= 3; i++ {
+		clock.Advance(10 * time.Second)
+		age, err := se.Age()
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if age <= last {
+			t.Errorf("%s: want more than %v got %v", fname, last, age)
+		}
+		last = age
+	}
+	idle, err := se.IdleTime()
+	if err != nil || idle != 30*time.Second {
+		t.Errorf("%s: want 30s <nil> got %v (%T, %+v)", fname, idle, err, err)
+	}
+	// A touch resets the idle time but not the age.
+	if _, err := s.Restore(se.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	idle, err = se.IdleTime()
+	if err != nil || idle != 0 {
+		t.Errorf("%s: want 0s <nil> got %v (%T, %+v)", fname, idle, err, err)
+	}
+	if age, _ := se.Age(); age != 30*time.Second {
+		t.Errorf("%s: want 30s got %v", fname, age)
+	}
+	clock.Advance(61 * time.Second)
+	_, err = se.IdleTime()
+	if !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+}
+
+// TestRestoreDuringDestroy races Restore against Destroy and Create of
+// the same SID, every Restore must return either a live session or an
+// error, never both nor neither.
+func TestRestoreDuringDestroy(t *testing.T) {
+	const fname = "TestRestoreDuringDestroy"
+	s := Init()
+	sid := uuid.New()
+	const rounds = 500
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			s.Create(sid, 60)
+			s.Destroy(sid)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			se, err := s.Restore(sid)
+			switch {
+			case err == nil && se.zero():
+				t.Errorf("%s: want a live session got %+v", fname, se)
+			case err != nil && !se.zero():
+				t.Errorf("%s: want the zero session got %+v", fname, se)
+			case err != nil && !errors.Is(err, ErrNoSession):
+				t.Errorf("%s: want ErrNoSession got (%T, %+v)",
+					fname, err, err)
+			}
+			if err != nil {
+				continue
+			}
+			// Once destroyed the session reports so.
+			if _, err := se.Get("k"); err != nil &&
+				!errors.Is(err, ErrNoSession) &&
+				!errors.Is(err, ErrNoData) {
+				t.Errorf("%s: want ErrNoSession got (%T, %+v)",
+					fname, err, err)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCompactAt(t *testing.T) {
+	const fname = "TestCompactAt"
+	s := InitWith(Config{CompactAt: 3, SweepBatch: 2})
+	ids := newIDs(8)
+	for _, id := range ids {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	check := func(want []uuid.UUID, holes int) {
+		t.Helper()
+		if got := s.IDs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %v got %v", fname, want, got)
+		}
+		s.exec(func() {
+			if s.holes != holes {
+				t.Errorf("%s: want %d holes got %d", fname, holes,
+					s.holes)
+			}
+			for i, id := range s.array {
+				if id == uuid.Nil {
+					continue
+				}
+				if se := s.sessions[id]; se.index != i {
+					t.Errorf("%s: %s: want index %d got %d",
+						fname, id, i, se.index)
+				}
+			}
+		})
+	}
+	s.Destroy(ids[1])
+	s.Destroy(ids[4])
+	check([]uuid.UUID{ids[0], ids[2], ids[3], ids[5], ids[6], ids[7]}, 2)
+	s.sweep()
+	// The third hole compacts the array.
+	s.Destroy(ids[0])
+	check([]uuid.UUID{ids[2], ids[3], ids[5], ids[6], ids[7]}, 0)
+	s.exec(func() {
+		if len(s.array) != 5 {
+			t.Errorf("%s: want 5 got %d", fname, len(s.array))
+		}
+	})
+	// The indices stay true for further destruction.
+	s.Destroy(ids[6])
+	if _, err := s.Restore(ids[7]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	check([]uuid.UUID{ids[2], ids[3], ids[5], ids[7]}, 1)
+	if n := len(s.Export()); n != 4 {
+		t.Errorf("%s: want 4 snapshots got %d", fname, n)
+	}
+}
+
+func TestClone(t *testing.T) {
+	const fname = "TestClone"
+	s := Init()
+	orig, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	type cart struct {
+		Items []string
+	}
+	orig.Set("name", "a")
+	orig.Set("tags", map[string]interface{}{"x": []int{1}})
+	orig.Set("cart", &cart{Items: []string{"book"}})
+	clone, err := orig.Clone()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if clone.ID() == orig.ID() || clone.MaxAge() != orig.MaxAge() {
+		t.Errorf("%s: want a new id and equal maxage got %s %v",
+			fname, clone.ID(), clone.MaxAge())
+	}
+	// Mutate the clone, and the values held within it.
+	clone.Set("name", "b")
+	v, _ := clone.Get("tags")
+	v.(map[string]interface{})["x"].([]int)[0] = 2
+	v, _ = clone.Get("cart")
+	v.(*cart).Items[0] = "pen"
+
+	orig, err = s.Restore(orig.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, _ := orig.Get("name"); v != "a" {
+		t.Errorf("%s: want a got %v", fname, v)
+	}
+	v, _ = orig.Get("tags")
+	if x := v.(map[string]interface{})["x"].([]int)[0]; x != 1 {
+		t.Errorf("%s: want 1 got %d", fname, x)
+	}
+	v, _ = orig.Get("cart")
+	if item := v.(*cart).Items[0]; item != "book" {
+		t.Errorf("%s: want book got %s", fname, item)
+	}
+	clone, err = s.Restore(clone.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, _ := clone.Get("name"); v != "b" {
+		t.Errorf("%s: want b got %v", fname, v)
+	}
+}
+
+func TestCloneCycle(t *testing.T) {
+	const fname = "TestCloneCycle"
+	s := Init()
+	type node struct {
+		Next *node
+		Name string
+	}
+	n := &node{Name: "a"}
+	n.Next = n
+	m := map[string]interface{}{}
+	m["self"] = m
+	now := time.Now()
+	orig, err := s.Import(Snapshot{
+		ID:       uuid.New(),
+		Created:  now,
+		Modified: now,
+		MaxAge:   1500 * time.Millisecond,
+		Data:     map[string]interface{}{"node": n, "map": m},
+	})
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clone, err := orig.Clone()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if clone.MaxAge() != 1500*time.Millisecond {
+		t.Errorf("%s: want 1.5s got %v", fname, clone.MaxAge())
+	}
+	v, _ := clone.Get("node")
+	cn := v.(*node)
+	if cn == n || cn.Next != cn || cn.Name != "a" {
+		t.Errorf("%s: want a copy referring to itself got %p %p", fname, cn, cn.Next)
+	}
+	v, _ = clone.Get("map")
+	cm := v.(map[string]interface{})
+	cm["k"] = 1
+	if _, ok := m["k"]; ok {
+		t.Errorf("%s: want the map copied", fname)
+	}
+	if _, ok := cm["self"].(map[string]interface{})["k"]; !ok {
+		t.Errorf("%s: want a copy referring to itself", fname)
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	const fname = "TestSnapshotJSON"
+	clock := newFakeClock()
+	src := InitWith(Config{Clock: clock})
+	se, err := src.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("k", "v")
+	clock.Advance(20 * time.Second)
+	snap := src.Export()[0]
+	b, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	var got Snapshot
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if got.ID != snap.ID || !got.Created.Equal(snap.Created) ||
+		!got.Modified.Equal(snap.Modified) || got.MaxAge != snap.MaxAge ||
+		got.Data["k"] != "v" {
+		t.Errorf("%s: want %+v got %+v", fname, snap, got)
+	}
+
+	// The session expires on time once loaded elsewhere.
+	dst := InitWith(Config{Clock: clock})
+	imported, err := dst.Import(got)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(30 * time.Second)
+	if idle, _ := imported.IdleTime(); idle != 50*time.Second {
+		t.Errorf("%s: want 50s got %v", fname, idle)
+	}
+	clock.Advance(11 * time.Second)
+	_, err = dst.Restore(snap.ID)
+	if !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestImportReplace(t *testing.T) {
+	const fname = "TestImportReplace"
+	s := Init()
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.Set("user", "bob")
+	kid, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Link(se.ID(), kid.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	snap, err := se.Snapshot()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Import(snap); !errors.Is(err, ErrExists) {
+		t.Errorf("%s: want ErrExists got (%T, %+v)", fname, err, err)
+	}
+	snap.Data = map[string]interface{}{"user": "annabel", "cart": 1}
+	snap.Tags = []string{"admin"}
+	if _, err := s.ImportReplace(snap); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se, err = s.Restore(snap.ID)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("user"); err != nil || v != "annabel" {
+		t.Errorf("%s: want (annabel, <nil>) got (%v, %v)", fname, v, err)
+	}
+	if sids := s.SessionsWithTag("admin"); len(sids) != 1 || sids[0] != snap.ID {
+		t.Errorf("%s: want [%v] got %v", fname, snap.ID, sids)
+	}
+	// The children of the session replaced are kept.
+	kidSnap, err := kid.Snapshot()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if kidSnap.Parent != snap.ID {
+		t.Errorf("%s: want %v got %v", fname, snap.ID, kidSnap.Parent)
+	}
+	// The size of the session replaced is no longer counted.
+	s.Destroy(kid.ID())
+	s.Destroy(snap.ID)
+	if n := s.Bytes(); n != 0 {
+		t.Errorf("%s: want 0 got %d", fname, n)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	const fname = "TestDiff"
+	before := Snapshot{Data: map[string]interface{}{
+		"user":  "bob",
+		"cart":  []string{"apple"},
+		"theme": "dark",
+		"seen":  []string{"home"},
+	}}
+	after := Snapshot{Data: map[string]interface{}{
+		"user": "bob",
+		"cart": []string{"apple", "pear"},
+		"lang": "fr",
+		"seen": []string{"home"},
+	}}
+	want := []Change{
+		{Key: "cart", Kind: Modified, Old: []string{"apple"},
+			New: []string{"apple", "pear"}},
+		{Key: "lang", Kind: Added, New: "fr"},
+		{Key: "theme", Kind: Removed, Old: "dark"},
+	}
+	got := Diff(before, after)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: want %+v got %+v", fname, want, got)
+	}
+	if got := Diff(after, after); len(got) != 0 {
+		t.Errorf("%s: want no changes got %+v", fname, got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	const fname = "TestReport"
+	s := Init()
+	for i := 0; i < 3; i++ {
+		if _, err := s.Create(uuid.New(), 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	s.sweep()
+	r, err := s.Report(time.Second)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if r.Sessions != 3 || r.LastSweep.IsZero() ||
+		r.QueueCapacity != defaultQueueSize {
+		t.Errorf("%s: want a populated report got %+v", fname, r)
+	}
+
+	// Stall the server.
+	stalled, release := make(chan struct{}), make(chan struct{})
+	go s.exec(func() {
+		close(stalled)
+		<-release
+	})
+	<-stalled
+	_, err = s.Report(20 * time.Millisecond)
+	if !errors.Is(err, ErrUnresponsive) {
+		t.Errorf("%s: want ErrUnresponsive got (%T, %+v)", fname, err, err)
+	}
+	close(release)
+	if _, err := s.Report(time.Second); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	const fname = "TestNormalizeKey"
+	s := InitWith(Config{NormalizeKey: strings.ToLower})
+	se, err := s.Create(uuid.New(), 60,
+		WithData(map[string]interface{}{"Plan": "pro"}))
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("Foo", 1); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("foo"); v != 1 || err != nil {
+		t.Errorf("%s: want 1 <nil> got %v (%T, %+v)", fname, v, err, err)
+	}
+	se.SetMany(map[string]interface{}{"BAR": 2})
+	se.Flash("Note", "hi")
+	keys, err := se.Keys()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	want := []string{"bar", "foo", "plan"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("%s: want %v got %v", fname, want, keys)
+	}
+	if f, _ := se.Flashes("NOTE"); len(f) != 1 {
+		t.Errorf("%s: want 1 flash got %v", fname, f)
+	}
+	if err := se.Del("FOO"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := se.Get("foo"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+}
+
+// TestDestroyOnly destroys the only session in the store, over and over,
+// with the array closed over at once and with it left to compaction.
+func TestDestroyOnly(t *testing.T) {
+	const fname = "TestDestroyOnly"
+	for _, compactAt := range []int{0, 1, 2} {
+		s := InitWith(Config{CompactAt: compactAt})
+		for i := 0; i < 10; i++ {
+			se, err := s.Create(uuid.New(), 60)
+			if err != nil {
+				t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+			}
+			if err := s.Destroy(se.ID()); err != nil {
+				t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+			}
+			if n := s.Len(); n != 0 {
+				t.Errorf("%s: CompactAt %d: want 0 got %d", fname,
+					compactAt, n)
+			}
+			s.exec(func() {
+				if len(s.array)-s.holes != 0 || s.index != len(s.array) {
+					t.Errorf("%s: CompactAt %d: want an empty array got %v index %d",
+						fname, compactAt, s.array, s.index)
+				}
+			})
+		}
+	}
+}
+
+func TestWindowIncrement(t *testing.T) {
+	const fname = "TestWindowIncrement"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock})
+	se, err := s.Create(uuid.New(), 3600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	start := clock.Now()
+	for want := int64(1); want <= 3; want++ {
+		n, reset, err := se.WindowIncrement("hits", time.Minute)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if n != want || !reset.Equal(start.Add(time.Minute)) {
+			t.Errorf("%s: want %d %v got %d %v", fname, want,
+				start.Add(time.Minute), n, reset)
+		}
+		clock.Advance(10 * time.Second)
+	}
+	// The window has passed.
+	clock.Advance(time.Minute)
+	n, reset, err := se.WindowIncrement("hits", time.Minute)
+	if err != nil || n != 1 || !reset.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("%s: want 1 %v <nil> got %d %v (%T, %+v)", fname,
+			clock.Now().Add(time.Minute), n, reset, err, err)
+	}
+	se.Set("other", "x")
+	_, _, err = se.WindowIncrement("other", time.Minute)
+	if !errors.Is(err, ErrNotWindow) {
+		t.Errorf("%s: want ErrNotWindow got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestWindowWAL(t *testing.T) {
+	const fname = "TestWindowWAL"
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s := InitWith(Config{WAL: path})
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, want, err := se.WindowIncrement("hits", time.Hour)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se.WindowIncrement("hits", time.Hour)
+
+	// The window is recovered as encoding/json decodes it.
+	r := InitWith(Config{WAL: path})
+	se, err = r.Restore(se.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	n, reset, err := se.WindowIncrement("hits", time.Hour)
+	if n != 3 || !reset.Equal(want) || err != nil {
+		t.Errorf("%s: want (3, %v, <nil>) got (%d, %v, %v)", fname, want,
+			n, reset, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+// spanRecorder is a Tracer that keeps its spans in memory, it traces
+// only contexts carrying a parent.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type parentKey struct{}
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	if ctx.Value(parentKey{}) == nil {
+		return ctx, nil
+	}
+	sp := &recordedSpan{name: name, attrs: map[string]interface{}{}}
+	r.mu.Lock()
+	r.spans = append(r.spans, sp)
+	r.mu.Unlock()
+	return context.WithValue(ctx, parentKey{}, sp), sp
+}
+
+func (sp *recordedSpan) SetAttribute(key string, value interface{}) {
+	sp.attrs[key] = value
+}
+
+func (sp *recordedSpan) End(err error) {
+	sp.err, sp.ended = err, true
+}
+
+func TestTracer(t *testing.T) {
+	const fname = "TestTracer"
+	rec := &spanRecorder{}
+	s := InitWith(Config{Tracer: rec})
+	sid := uuid.New()
+	if _, err := s.Create(sid, 60); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// Without a parent span nothing is traced.
+	if _, err := s.RestoreContext(context.Background(), sid); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if len(rec.spans) != 0 {
+		t.Fatalf("%s: want no spans got %d", fname, len(rec.spans))
+	}
+	ctx := context.WithValue(context.Background(), parentKey{}, "root")
+	if _, err := s.RestoreContext(ctx, sid); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	s.RestoreContext(ctx, uuid.New())
+	if len(rec.spans) != 2 {
+		t.Fatalf("%s: want 2 spans got %d", fname, len(rec.spans))
+	}
+	hit, miss := rec.spans[0], rec.spans[1]
+	if hit.name != "session.Restore" || !hit.ended || hit.err != nil {
+		t.Errorf("%s: want an ended session.Restore span got %+v", fname, hit)
+	}
+	if hit.attrs[AttrOp] != "Restore" || hit.attrs[AttrSID] != sid.String() ||
+		hit.attrs[AttrHit] != true {
+		t.Errorf("%s: want op, sid and hit got %v", fname, hit.attrs)
+	}
+	if _, ok := hit.attrs[AttrDuration].(time.Duration); !ok {
+		t.Errorf("%s: want a duration got %v", fname, hit.attrs)
+	}
+	if miss.attrs[AttrHit] != false || !errors.Is(miss.err, ErrNoSession) {
+		t.Errorf("%s: want a miss got %+v", fname, miss)
+	}
+
+	// Waiting upon a stalled server gives up with the context.
+	stall, stalled := make(chan struct{}), make(chan struct{})
+	go s.exec(func() {
+		close(stalled)
+		<-stall
+	})
+	<-stalled
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := s.RestoreContext(tctx, sid)
+	close(stall)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%s: want DeadlineExceeded got (%T, %+v)", fname, err, err)
+	}
+	if sp := rec.spans[len(rec.spans)-1]; !errors.Is(sp.err, context.DeadlineExceeded) {
+		t.Errorf("%s: want the span ended with the error got %+v", fname, sp)
+	}
+}
+
+func TestStatsByLabels(t *testing.T) {
+	const fname = "TestStatsByLabels"
+	s := InitWith(Config{LabelKeys: []string{"tier"}, MaxLabelValues: 2})
+	ctx := context.Background()
+	gold := WithLabels(ctx, Labels{"tier": "gold", "user": "bob"})
+	free := WithLabels(ctx, Labels{"tier": "free"})
+	for _, c := range []context.Context{gold, gold, free, ctx} {
+		se, err := s.CreateContext(c, uuid.New(), 60)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if _, err := s.RestoreContext(c, se.ID()); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if _, err := s.RestoreContext(free, uuid.New()); err == nil {
+		t.Fatalf("%s: want ErrNoSession got <nil>", fname)
+	}
+	// A third value of the key is counted as other.
+	trial := WithLabels(ctx, Labels{"tier": "trial"})
+	if _, err := s.CreateContext(trial, uuid.New(), 60); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	want := map[string]LabelStats{
+		"tier=gold":  {Created: 2, Restored: 2},
+		"tier=free":  {Created: 1, Restored: 1, Missed: 1},
+		"tier=other": {Created: 1},
+	}
+	if got := s.StatsByLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: want %v got %v", fname, want, got)
+	}
+}
+
+func TestTombstone(t *testing.T) {
+	const fname = "TestTombstone"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock, TombstoneRetention: time.Hour})
+	ids := newIDs(2)
+	for _, id := range ids {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if err := s.DestroyReason(ids[0], "logged out elsewhere"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	at := clock.Now()
+	info, ok := s.Tombstone(ids[0])
+	if !ok || info.ID != ids[0] || !info.At.Equal(at) ||
+		info.Reason != "logged out elsewhere" || info.Expired {
+		t.Errorf("%s: want the tombstone got %v %+v", fname, ok, info)
+	}
+	if _, err := s.Restore(ids[0]); !errors.Is(err, ErrDestroyed) {
+		t.Errorf("%s: want ErrDestroyed got (%T, %+v)", fname, err, err)
+	}
+
+	clock.Advance(61 * time.Second)
+	s.sweep()
+	info, ok = s.Tombstone(ids[1])
+	if !ok || info.Reason != ReasonExpired || !info.Expired {
+		t.Errorf("%s: want an expired tombstone got %v %+v", fname, ok, info)
+	}
+	if _, ok := s.Tombstone(uuid.New()); ok {
+		t.Errorf("%s: want no tombstone for an unknown SID", fname)
+	}
+
+	// Past the retention window both are forgotten.
+	clock.Advance(time.Hour + time.Second)
+	s.sweep()
+	for _, id := range ids {
+		if info, ok := s.Tombstone(id); ok {
+			t.Errorf("%s: want no tombstone got %+v", fname, info)
+		}
+	}
+	_, err := s.Restore(ids[0])
+	if !errors.Is(err, ErrNoSession) || errors.Is(err, ErrDestroyed) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestAuxiliaryBounds(t *testing.T) {
+	const fname = "TestAuxiliaryBounds"
+	clock := newFakeClock()
+	s := InitWith(Config{
+		Clock:              clock,
+		MaxTombstones:      10,
+		TombstoneRetention: time.Minute,
+		RecordOps:          10,
+		OpRetention:        time.Minute,
+	})
+	ids := newIDs(100)
+	for _, id := range ids {
+		if _, err := s.Create(id, 0); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if err := s.Destroy(id); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	s.exec(func() {
+		if n := len(s.tombs.info); n > 10 {
+			t.Errorf("%s: want at most 10 tombstones got %d", fname, n)
+		}
+	})
+	if ops := s.RecentOps(); len(ops) != 10 {
+		t.Errorf("%s: want 10 operations got %d", fname, len(ops))
+	}
+	// The most recent removals are still to be had.
+	last := ids[len(ids)-1]
+	if _, ok := s.Tombstone(last); !ok {
+		t.Errorf("%s: want tombstone of %v", fname, last)
+	}
+	if _, ok := s.Tombstone(ids[0]); ok {
+		t.Errorf("%s: want no tombstone of %v", fname, ids[0])
+	}
+	ops := s.RecentOps()
+	if op := ops[len(ops)-1]; op.Kind != OpDestroy || op.SID != last {
+		t.Errorf("%s: want Destroy %v got %v %v", fname, last, op.Kind,
+			op.SID)
+	}
+
+	// The sweep forgets those older than their retention.
+	clock.Advance(time.Minute + time.Second)
+	s.sweep()
+	s.exec(func() {
+		if n := len(s.tombs.info); n != 0 {
+			t.Errorf("%s: want 0 tombstones got %d", fname, n)
+		}
+	})
+	if ops := s.RecentOps(); len(ops) != 0 {
+		t.Errorf("%s: want 0 operations got %d", fname, len(ops))
+	}
+}
+
+func TestMoveKey(t *testing.T) {
+	const fname = "TestMoveKey"
+	s := Init()
+	guest, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	user, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	guest.Set("upload", "tok")
+	guest.Set("other", 1)
+	if err := s.MoveKey(guest.ID(), user.ID(), "upload"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := user.Get("upload"); v != "tok" || err != nil {
+		t.Errorf("%s: want tok <nil> got %v (%T, %+v)", fname, v, err, err)
+	}
+	if _, err := guest.Get("upload"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+	if v, _ := guest.Get("other"); v != 1 {
+		t.Errorf("%s: want 1 got %v", fname, v)
+	}
+	err = s.MoveKey(guest.ID(), user.ID(), "upload")
+	if !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+	s.Destroy(user.ID())
+	err = s.MoveKey(guest.ID(), user.ID(), "other")
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if v, _ := guest.Get("other"); v != 1 {
+		t.Errorf("%s: want 1 got %v", fname, v)
+	}
+
+	// A full store evicts neither session for the move.
+	s = Init()
+	s.Policy(EvictLRU)
+	ses := make([]Session, 2)
+	for i := range ses {
+		if ses[i], err = s.Create(uuid.New(), 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	ses[0].Set("upload", strings.Repeat("x", 64))
+	ses[1].Set("n", 1)
+	var full int
+	s.exec(func() {
+		full = s.bytes
+	})
+	s.MaxBytes(full)
+	if err := s.MoveKey(ses[0].ID(), ses[1].ID(), "upload"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for _, se := range ses {
+		if _, err := s.Restore(se.ID()); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+
+	// A move that can not be logged is not made.
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s = InitWith(Config{WAL: path})
+	for i := range ses {
+		if ses[i], err = s.Create(uuid.New(), 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	ses[0].Set("upload", "tok")
+	s.exec(func() {
+		s.wal.f.Close()
+	})
+	if err := s.MoveKey(ses[0].ID(), ses[1].ID(), "upload"); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+	if v, err := ses[0].Get("upload"); v != "tok" || err != nil {
+		t.Errorf("%s: want tok <nil> got %v (%T, %+v)", fname, v, err, err)
+	}
+	if _, err := ses[1].Get("upload"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestSwapData(t *testing.T) {
+	const fname = "TestSwapData"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock})
+	a, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(10 * time.Second)
+	b, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	a.Set("user", "ann")
+	a.Set("tier", "gold")
+	b.Set("user", "bob")
+	sizeA, _ := a.Size()
+	sizeB, _ := b.Size()
+	clock.Advance(10 * time.Second)
+
+	if err := s.SwapData(a.ID(), b.ID()); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for _, tt := range []struct {
+		se   Session
+		want map[string]interface{}
+		idle time.Duration
+	}{
+		{a, map[string]interface{}{"user": "bob"}, 10 * time.Second},
+		{b, map[string]interface{}{"user": "ann", "tier": "gold"}, 10 * time.Second},
+	} {
+		if idle, err := tt.se.IdleTime(); err != nil || idle != tt.idle {
+			t.Errorf("%s: want (%v, <nil>) got (%v, %v)", fname, tt.idle, idle, err)
+		}
+		snap, err := tt.se.Snapshot()
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if snap.ID != tt.se.ID() || !reflect.DeepEqual(snap.Data, tt.want) {
+			t.Errorf("%s: want %v %v got %v %v", fname, tt.se.ID(), tt.want,
+				snap.ID, snap.Data)
+		}
+	}
+	if age, _ := a.Age(); age != 20*time.Second {
+		t.Errorf("%s: want age 20s got %v", fname, age)
+	}
+	if n, _ := a.Size(); n != sizeB {
+		t.Errorf("%s: want size %d got %d", fname, sizeB, n)
+	}
+	if n, _ := b.Size(); n != sizeA {
+		t.Errorf("%s: want size %d got %d", fname, sizeA, n)
+	}
+
+	// A missing session leaves the other unchanged.
+	s.Destroy(b.ID())
+	if err := s.SwapData(a.ID(), b.ID()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if v, err := a.Get("user"); err != nil || v != "bob" {
+		t.Errorf("%s: want (bob, <nil>) got (%v, %v)", fname, v, err)
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	const fname = "TestRenameKey"
+	s := Init()
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("uid", 7); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	before, _ := se.Size()
+	if err := se.RenameKey("uid", "user_id"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("user_id"); err != nil || v != 7 {
+		t.Errorf("%s: want (7, <nil>) got (%v, %v)", fname, v, err)
+	}
+	if _, err := se.Get("uid"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+	if after, _ := se.Size(); after != before+len("user_id")-len("uid") {
+		t.Errorf("%s: want size %d got %d", fname,
+			before+len("user_id")-len("uid"), after)
+	}
+	if err := se.RenameKey("uid", "other"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+
+	// An existing key is only overwritten by ReplaceKey.
+	if err := se.Set("uid", 8); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.RenameKey("uid", "user_id"); !errors.Is(err, ErrConflict) {
+		t.Errorf("%s: want ErrConflict got (%T, %+v)", fname, err, err)
+	}
+	if err := se.ReplaceKey("uid", "user_id"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("user_id"); err != nil || v != 8 {
+		t.Errorf("%s: want (8, <nil>) got (%v, %v)", fname, v, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	const fname = "TestRefresh"
+	clock := newFakeClock()
+	s := InitWith(Config{Clock: clock})
+	held, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	clock.Advance(10 * time.Second)
+	other, err := s.Restore(held.ID())
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	other.Set("k", 1)
+	fresh, err := held.Refresh()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !fresh.Valid() || !fresh.modified.Equal(clock.Now()) ||
+		!fresh.created.Equal(held.created) {
+		t.Errorf("%s: want modified %v got %+v", fname, clock.Now(), fresh)
+	}
+	if held.modified.Equal(fresh.modified) {
+		t.Errorf("%s: want the held copy unchanged", fname)
+	}
+	s.Destroy(held.ID())
+	fresh, err = held.Refresh()
+	if !errors.Is(err, ErrDestroyed) || fresh.Valid() {
+		t.Errorf("%s: want ErrDestroyed got %v (%T, %+v)", fname,
+			fresh.Valid(), err, err)
+	}
+}
+
+// memPersister is a Persister that keeps its snapshots in memory.
+type memPersister struct {
+	mu    sync.Mutex
+	snaps map[uuid.UUID]Snapshot
+	saves int
+	// loadErr, if set, is returned by Load.
+	loadErr error
+}
+
+func newMemPersister() *memPersister {
+	return &memPersister{snaps: make(map[uuid.UUID]Snapshot)}
+}
+
+func (p *memPersister) Save(snaps []Snapshot) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.saves++
+	for _, snap := range snaps {
+		p.snaps[snap.ID] = snap
+	}
+	return nil
+}
+
+func (p *memPersister) Delete(sids []uuid.UUID) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, id := range sids {
+		delete(p.snaps, id)
+	}
+	return nil
+}
+
+func (p *memPersister) Load() ([]Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.loadErr != nil {
+		return nil, p.loadErr
+	}
+	var snaps []Snapshot
+	for _, snap := range p.snaps {
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+func (p *memPersister) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.snaps)
+}
+
+func TestWriteBehind(t *testing.T) {
+	const fname = "TestWriteBehind"
+	clock := newFakeClock()
+	p := newMemPersister()
+	s := InitWith(Config{Clock: clock, Persister: p})
+	ids := newIDs(3)
+	for i, id := range ids {
+		se, err := s.Create(id, 60)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		se.Set("n", i)
+	}
+	if p.len() != 0 {
+		t.Errorf("%s: want nothing written before a flush got %d", fname,
+			p.len())
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if p.len() != 3 {
+		t.Errorf("%s: want 3 sessions written got %d", fname, p.len())
+	}
+	// Nothing has changed, nothing is written.
+	s.Flush()
+	if p.saves != 1 {
+		t.Errorf("%s: want 1 save got %d", fname, p.saves)
+	}
+	s.Destroy(ids[0])
+	clock.Advance(10 * time.Second)
+	se, _ := s.Restore(ids[1])
+	se.Set("n", 10)
+	modified := clock.Now()
+	// Close flushes what remains.
+	if err := s.Close(); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// Restart.
+	clock.Advance(20 * time.Second)
+	s = InitWith(Config{Clock: clock, Persister: p})
+	if n := s.Len(); n != 2 {
+		t.Fatalf("%s: want 2 sessions got %d", fname, n)
+	}
+	for _, snap := range s.Export() {
+		switch snap.ID {
+		case ids[1]:
+			if snap.Data["n"] != 10 || !snap.Modified.Equal(modified) {
+				t.Errorf("%s: want n 10 modified %v got %+v", fname,
+					modified, snap)
+			}
+		case ids[2]:
+			if snap.Data["n"] != 2 {
+				t.Errorf("%s: want n 2 got %+v", fname, snap)
+			}
+		default:
+			t.Errorf("%s: want no session %s", fname, snap.ID)
+		}
+	}
+	// The sessions keep their remaining lifetimes.
+	clock.Advance(31 * time.Second)
+	if _, err := s.Restore(ids[2]); !errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrTimedOut got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Restore(ids[1]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	s.Flush()
+	if p.len() != 1 {
+		t.Errorf("%s: want the expired session deleted got %d", fname,
+			p.len())
+	}
+}
+
+func TestFlushOrder(t *testing.T) {
+	const fname = "TestFlushOrder"
+	p := newMemPersister()
+	s := InitWith(Config{Persister: p})
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				se.Set("n", g*50+i)
+				s.Flush()
+			}
+		}(g)
+	}
+	wg.Wait()
+	s.Flush()
+	want, _ := se.Get("n")
+	p.mu.Lock()
+	got := p.snaps[se.ID()].Data["n"]
+	p.mu.Unlock()
+	if got != want {
+		t.Errorf("%s: want %v got %v", fname, want, got)
+	}
+
+	// A Persister that can not be loaded fails the start of the store.
+	p.loadErr = errors.New("load failed")
+	if _, err := OpenWith(Config{Persister: p}); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+}
+
+func TestWAL(t *testing.T) {
+	const fname = "TestWAL"
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s := InitWith(Config{WAL: path})
+	ids := newIDs(3)
+	for _, id := range ids {
+		_, err := s.Create(id, 60, WithData(map[string]interface{}{
+			"user": "bob",
+		}))
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	se, _ := s.Restore(ids[0])
+	if err := se.Set("n", 1.0); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("tmp", "x"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Del("tmp"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Destroy(ids[1]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	want := s.Export()
+
+	// A crash may leave the last line incomplete.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	f.WriteString(`{"Put":{"ID":`)
+	f.Close()
+
+	// The first store is abandoned, as though the process had crashed.
+	r := InitWith(Config{WAL: path})
+	got := r.Export()
+	if len(got) != len(want) {
+		t.Fatalf("%s: want %d sessions got %d", fname, len(want), len(got))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Errorf("%s: want %v got %v", fname, want[i].ID, got[i].ID)
+		}
+		if c := Diff(want[i], got[i]); len(c) != 0 {
+			t.Errorf("%s: want no changes got %+v", fname, c)
+		}
+	}
+	if _, err := r.Restore(ids[1]); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestWALState(t *testing.T) {
+	const fname = "TestWALState"
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s := InitWith(Config{WAL: path})
+	ids := newIDs(2)
+	// The child is created before its parent.
+	child, parent := ids[0], ids[1]
+	for _, id := range ids {
+		if _, err := s.Create(id, 60); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	until := time.Now().Add(time.Hour).Round(0)
+	if err := s.DestroyAfter(child, until); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Freeze(child); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Pin(parent); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Link(parent, child); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se, _ := s.Restore(child)
+	for _, tag := range []string{"b", "a", "c"} {
+		if err := se.Tag(tag); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if err := se.Untag("c"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// The first store is abandoned, as though the process had crashed.
+	r := InitWith(Config{WAL: path})
+	r.exec(func() {
+		c, p := r.sessions[child], r.sessions[parent]
+		if !c.until.Equal(until) || !c.frozen || c.pinned {
+			t.Errorf("%s: want (%v, true, false) got (%v, %v, %v)", fname,
+				until, c.until, c.frozen, c.pinned)
+		}
+		if !p.pinned || p.frozen {
+			t.Errorf("%s: want (true, false) got (%v, %v)", fname,
+				p.pinned, p.frozen)
+		}
+		if got := r.parents[child]; got != parent {
+			t.Errorf("%s: want parent %v got %v", fname, parent, got)
+		}
+	})
+	if got := r.SessionsWithTag("a"); len(got) != 1 || got[0] != child {
+		t.Errorf("%s: want [%v] got %v", fname, child, got)
+	}
+	if got := r.SessionsWithTag("c"); len(got) != 0 {
+		t.Errorf("%s: want none got %v", fname, got)
+	}
+	snaps := r.Export()
+	if len(snaps) != 2 || snaps[0].ID != parent || snaps[1].ID != child {
+		t.Fatalf("%s: want the parent before the child got %+v", fname, snaps)
+	}
+	if tags := snaps[1].Tags; !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("%s: want [a b] got %v", fname, tags)
+	}
+
+	// A clone carries the state of its source.
+	se, _ = r.Restore(child)
+	clone, err := se.Clone()
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	snap, _ := clone.Snapshot()
+	if !snap.Until.Equal(until) || !snap.Frozen || snap.Parent != parent ||
+		!reflect.DeepEqual(snap.Tags, []string{"a", "b"}) {
+		t.Errorf("%s: want the state of the source got %+v", fname, snap)
+	}
+	if err := r.Destroy(parent); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := r.Restore(clone.ID()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("%s: want ErrNoSession got (%T, %+v)", fname, err, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	const fname = "TestWALCompact"
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s := InitWith(Config{WAL: path, WALCompact: 5})
+	id := uuid.New()
+	se, err := s.Create(id, 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := se.Set("n", float64(i)); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if n := bytes.Count(b, []byte("\n")); n > 5 {
+			t.Fatalf("%s: want at most 5 entries got %d", fname, n)
+		}
+	}
+	r := InitWith(Config{WAL: path})
+	se, err = r.Restore(id)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if v, err := se.Get("n"); err != nil || v != 19.0 {
+		t.Errorf("%s: want (19, <nil>) got (%v, %v)", fname, v, err)
+	}
+}
+
+func TestWALFailure(t *testing.T) {
+	const fname = "TestWALFailure"
+	dir := t.TempDir()
+	// A log that can not be read fails the start of the store.
+	if _, err := OpenWith(Config{WAL: dir}); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: want InitWith to panic", fname)
+			}
+		}()
+		InitWith(Config{WAL: dir})
+	}()
+
+	s, err := OpenWith(Config{WAL: filepath.Join(dir, "sessions.wal")})
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	se, err := s.Create(uuid.New(), 60)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Tag("k"); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// A change that can not be logged is not made.
+	s.exec(func() {
+		s.wal.f.Close()
+	})
+	if err := se.Set("k", "v"); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+	if _, err := se.Get("k"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+	if err := s.Destroy(se.ID()); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+	if n, errs := s.DestroyMany([]uuid.UUID{se.ID()}); n != 0 || errs[se.ID()] == nil {
+		t.Errorf("%s: want (0, error) got (%d, %v)", fname, n, errs)
+	}
+	if n, err := s.DestroyTag("k"); n != 0 || err == nil {
+		t.Errorf("%s: want (0, error) got (%d, %v)", fname, n, err)
+	}
+	if _, err := s.Restore(se.ID()); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := s.Create(uuid.New(), 60); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+}
+
+func TestSerializable(t *testing.T) {
+	const fname = "TestSerializable"
+	path := filepath.Join(t.TempDir(), "sessions.wal")
+	s := InitWith(Config{WAL: path, Serializable: JSONSerializable})
+	se, err := s.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	err = se.Set("callback", func() {})
+	if !errors.Is(err, ErrNotSerializable) {
+		t.Errorf("%s: want ErrNotSerializable got (%T, %+v)", fname, err, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), `"callback"`) {
+		t.Errorf("%s: want the key named got %v", fname, err)
+	}
+	if _, err := se.Get("callback"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+	err = se.SetMany(map[string]interface{}{"ok": 1, "ch": make(chan int)})
+	if !errors.Is(err, ErrNotSerializable) {
+		t.Errorf("%s: want ErrNotSerializable got (%T, %+v)", fname, err, err)
+	}
+	if _, err := se.Get("ok"); !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+	if err := se.Set("user", "bob"); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if err := GobSerializable(func() {}); err == nil {
+		t.Errorf("%s: want an error got <nil>", fname)
+	}
+}
+
+func TestUnserializablePolicy(t *testing.T) {
+	const fname = "TestUnserializablePolicy"
+	cyclic := map[string]interface{}{}
+	cyclic["self"] = cyclic
+	tests := []struct {
+		policy UnserializablePolicy
+		json   string
+		err    error
+	}{
+		{FailFast, "", ErrNotSerializable},
+		{SkipUnserializable, `{"name":"bob","score":7}`, nil},
+		{StringifyUnserializable,
+			`{"ch":"0x","cyclic":"map[string]interface {}","name":"bob","score":7}`, nil},
+	}
+	for _, tt := range tests {
+		s := InitWith(Config{Unserializable: tt.policy})
+		se, err := s.Create(uuid.New(), 60)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		ch := make(chan int)
+		se.Set("name", "bob")
+		se.Set("score", 7)
+		se.Set("ch", ch)
+		se.Set("cyclic", cyclic)
+
+		b, err := se.MarshalJSON()
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: %d: want %v got (%T, %+v)", fname, tt.policy,
+				tt.err, err, err)
+		}
+		if tt.err != nil {
+			if !strings.Contains(err.Error(), `"ch"`) {
+				t.Errorf("%s: want the key named got %v", fname, err)
+			}
+			continue
+		}
+		// A channel prints as its address, compared by its prefix.
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		if addr, ok := m["ch"].(string); ok && strings.HasPrefix(addr, "0x") {
+			m["ch"] = "0x"
+		}
+		if got, _ := json.Marshal(m); string(got) != tt.json {
+			t.Errorf("%s: %d: want %s got %s", fname, tt.policy, tt.json, b)
+		}
+		_, keys, err := se.EncodeJSON(tt.policy)
+		if err != nil || !reflect.DeepEqual(keys, []string{"ch", "cyclic"}) {
+			t.Errorf("%s: %d: want ([ch cyclic], <nil>) got (%v, %v)",
+				fname, tt.policy, keys, err)
+		}
+
+		// Exported snapshots are sanitized alike.
+		snap, keys, err := s.Export()[0].Sanitize(tt.policy)
+		if err != nil || len(keys) != 2 {
+			t.Errorf("%s: %d: want 2 keys got (%v, %v)", fname, tt.policy, keys, err)
+		}
+		if _, err := json.Marshal(snap); err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+}
+
+// TestCreateContextAbandoned gives up on a CreateContext whilst the
+// server is held up, the server must not then block upon answering it.
+func TestCreateContextAbandoned(t *testing.T) {
+	const fname = "TestCreateContextAbandoned"
+	// Keep the timer from queueing commands of its own.
+	idle := make(chan struct{})
+	s := InitWith(Config{sleep: func(time.Duration) {
+		close(idle)
+		select {}
+	}})
+	<-idle
+	stalled, release := make(chan struct{}), make(chan struct{})
+	go s.exec(func() {
+		close(stalled)
+		<-release
+	})
+	<-stalled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	sid := uuid.New()
+	go func() {
+		_, err := s.CreateContext(ctx, sid, 60)
+		done <- err
+	}()
+	// Let the request reach the queue before giving up on it.
+	for s.QueueDepth() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("%s: want context.Canceled got (%T, %+v)", fname, err, err)
+	}
+	close(release)
+	r, err := s.Report(time.Second)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if r.Sessions != 1 {
+		t.Errorf("%s: want the abandoned session created got %d", fname,
+			r.Sessions)
+	}
+	if _, err := s.Create(uuid.New(), 60); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+}
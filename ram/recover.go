@@ -0,0 +1,30 @@
+package ram
+
+import (
+	"fmt"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+// ErrInternal is returned when an operation upon a session fails
+// unexpectedly, such as by a bug in the store, rather than crashing the
+// program.
+var ErrInternal = errs.New(errs.Internal, "session store internal error")
+
+// recoverInto recovers a panic, logging it and setting *err to an error
+// matching ErrInternal, such that the session server survives it. The
+// session concerned may have been left partly updated. It is to be
+// deferred within the session server.
+func (s *Store) recoverInto(err *error, sid uuid.UUID) {
+	const fname = "Store.recoverInto"
+	r := recover()
+	if r == nil {
+		return
+	}
+	if d := s.diag; d != nil {
+		const event = "recovered panic"
+		d.Err(nil, s.label(), fname, event, "SID", sid, "panic", r)
+	}
+	*err = fmt.Errorf("%w: %v", ErrInternal, r)
+}
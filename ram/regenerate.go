@@ -0,0 +1,103 @@
+package ram
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Regenerate replaces the SID of the session with a newly minted one,
+// as is done on login to defeat session fixation, returning the session
+// under its new SID. Its data, owner, maxage, deadline, tags and links
+// are carried over and the session is touched. The old SID is then as
+// a destroyed session, with the tombstone reason ReasonRegen, and any
+// subscriptions made through it are closed.
+//
+// The exchange is made in one operation of the session server, such
+// that no other operation observes both SIDs, or neither: a concurrent
+// Restore of the old SID either succeeds, before the exchange, or
+// returns ErrNoSession, and a Restore of the new SID never succeeds
+// while the old SID remains. Should the new SID not be added the session
+// is left as it was under the old.
+func (s Session) Regenerate() (se Session, err error) {
+	const fname = "Session.Regenerate"
+	if s.zero() {
+		return Session{}, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	sto := s.sto
+	gone := sto.update(s.id, func(old Session) {
+		se, err = sto.regenerate(old)
+	})
+	if gone != nil {
+		err = gone
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// regenerate moves the session old to a newly minted SID. This function
+// is to be run only by the sessionServer function.
+func (s *Store) regenerate(old Session) (Session, error) {
+	const fname = "Store.regenerate"
+	se := old
+	se.id = s.ids.New()
+	for i := 1; i < mintAttempts && s.taken(se.id); i++ {
+		se.id = s.ids.New()
+	}
+	if invalid(se.id) {
+		return Session{}, ErrPoorForm
+	}
+	if s.taken(se.id) {
+		return Session{}, ErrExists
+	}
+
+	// The new session is added, with the tags and parent of the old,
+	// before the old is destroyed, such that should it fail the old
+	// remains as it was.
+	var tags []string
+	for tag := range s.tagsOf[old.id] {
+		tags = append(tags, tag)
+	}
+	sid := se.id
+	s.adopt(sid, tags, s.parents[old.id])
+	se, err := s.replace(se, old.id)
+	if err != nil {
+		s.disown(sid)
+		return Session{}, err
+	}
+	s.record(OpCreate, se.id, "")
+
+	// Take the children of the old SID before it is destroyed, that
+	// they are not destroyed with it.
+	kids := s.children[old.id]
+	delete(s.children, old.id)
+	s.record(OpDestroy, old.id, "")
+	s.destroy(old.id, fname)
+	s.bury(old.id, causeDestroyed, ReasonRegen)
+
+	for _, kid := range kids {
+		s.parents[kid] = se.id
+		// A change made by the store itself is made whether or not it
+		// may be logged.
+		if err := s.logPut(s.sessions[kid], nil); err != nil {
+			if d := s.diag; d != nil {
+				const event = "write-ahead log failed"
+				d.Err(err, s.label(), fname, event, "SID", kid)
+			}
+		}
+		s.markDirty(kid)
+	}
+	if len(kids) > 0 {
+		s.children[se.id] = kids
+	}
+	return se, nil
+}
+
+// taken reports whether the SID is that of a session in the store. This
+// function is to be run only by the sessionServer function.
+func (s *Store) taken(sid uuid.UUID) bool {
+	_, ok := s.sessions[sid]
+	return ok
+}
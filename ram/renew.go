@@ -0,0 +1,34 @@
+package ram
+
+import "fmt"
+
+// RenewIf touches the session, extending its life, only if pred returns
+// true when given a copy of the sessions data, reporting whether the
+// session was renewed. The test and the renewal are made in one
+// operation of the session server, pred is called from within the
+// server and as such must not itself use the store.
+func (s Session) RenewIf(pred func(data map[string]interface{}) bool) (renewed bool, err error) {
+	const fname = "Session.RenewIf"
+	if s.zero() {
+		return false, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	s.sto.exec(func() {
+		se, ok := s.sto.sessions[s.id]
+		if ok && s.sto.expired(se) {
+			s.sto.expire(s.id, fname)
+			ok = false
+		}
+		if !ok {
+			err = s.sto.goneErr(s.id)
+			return
+		}
+		if pred(se.snapshot().Data) {
+			command{cmd: touch, key: s.id, seStore: s.sto}.touch()
+			renewed = true
+		}
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
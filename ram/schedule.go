@@ -0,0 +1,139 @@
+package ram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DestroyAfter schedules the destruction of the session at the given
+// time regardless of its activity, such as for a pass that is valid
+// until midnight. The session is destroyed by the first timeout
+// verification or use at or after that time, its maxage still applies
+// until then. Scheduling does not touch the session, a later schedule
+// replaces an earlier one and the zero time cancels it.
+func (s *Store) DestroyAfter(sid uuid.UUID, at time.Time) error {
+	return s.alter("Store.DestroyAfter", sid, func(se *Session) {
+		se.until = at
+	})
+}
+
+// CancelDestroy cancels the scheduled destruction of the session.
+func (s *Store) CancelDestroy(sid uuid.UUID) error {
+	return s.DestroyAfter(sid, time.Time{})
+}
+
+// Freeze pauses the expiry of the session, such that it is kept however
+// long it goes unused, as when a background task acts on behalf of its
+// user. A scheduled destruction still applies. Freezing does not touch
+// the session.
+func (s *Store) Freeze(sid uuid.UUID) error {
+	return s.alter("Store.Freeze", sid, func(se *Session) {
+		se.frozen = true
+	})
+}
+
+// Unfreeze resumes the expiry of a frozen session, counting its maxage
+// from the present.
+func (s *Store) Unfreeze(sid uuid.UUID) error {
+	return s.alter("Store.Unfreeze", sid, func(se *Session) {
+		se.frozen = false
+		se.modified = s.clock.Now()
+	})
+}
+
+// ExtendAll adds by to the deadline of every live session in one
+// operation of the session server, as for a maintenance window during
+// which no user should be logged out, returning the number of sessions
+// extended. The last used time of each is moved forward by that amount,
+// as is any scheduled destruction, such that the extension lapses at the
+// next use; until then the idle time of a session may read as negative.
+// Sessions that have already expired are left to the timeout
+// verification, as are any whose extension can not be written to the
+// write-ahead log, the failure being reported to the diagnostics.
+func (s *Store) ExtendAll(by time.Duration) (n int) {
+	const fname = "Store.ExtendAll"
+	s.exec(func() {
+		s.each(func(se Session) {
+			if s.expired(se) {
+				return
+			}
+			se.modified = se.modified.Add(by)
+			if !se.until.IsZero() {
+				se.until = se.until.Add(by)
+			}
+			if err := s.logPut(se, nil); err != nil {
+				if d := s.diag; d != nil {
+					const event = "write-ahead log failed"
+					d.Err(err, s.label(), fname, event, "SID", se.id)
+				}
+				return
+			}
+			s.sessions[se.id] = se
+			s.markDirty(se.id)
+			n++
+		})
+	})
+	return
+}
+
+// Pin exempts the session from expiry, by its maxage or by a scheduled
+// destruction, and from eviction, such as for the session of a service
+// account. Unlike Freeze it is a standing policy, a pinned session is
+// kept until it is destroyed or unpinned. Pinning does not touch the
+// session.
+func (s *Store) Pin(sid uuid.UUID) error {
+	return s.alter("Store.Pin", sid, func(se *Session) {
+		se.pinned = true
+	})
+}
+
+// Unpin makes a pinned session subject to expiry and eviction once
+// more, counting its maxage from the present.
+func (s *Store) Unpin(sid uuid.UUID) error {
+	return s.alter("Store.Unpin", sid, func(se *Session) {
+		se.pinned = false
+		se.modified = s.clock.Now()
+	})
+}
+
+// alter applies fn to the live session sid from within the session
+// server without touching it, returning the reason if it is not live or
+// the error should the change not be written to the write-ahead log.
+func (s *Store) alter(fname string, sid uuid.UUID, fn func(se *Session)) (err error) {
+	if invalid(sid) {
+		return fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	s.exec(func() {
+		se, ok := s.sessions[sid]
+		if ok && s.expired(se) {
+			s.expire(sid, fname)
+			ok = false
+		}
+		if !ok {
+			err = s.goneErr(sid)
+			return
+		}
+		fn(&se)
+		if err = s.logPut(se, nil); err != nil {
+			return
+		}
+		s.sessions[sid] = se
+		s.markDirty(sid)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// deadline returns the time at which the session expires, the earlier of
+// the end of its maxage and its scheduled destruction.
+func deadline(se Session) time.Time {
+	d := se.modified.Add(se.maxage)
+	if !se.until.IsZero() && se.until.Before(d) {
+		return se.until
+	}
+	return d
+}
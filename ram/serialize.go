@@ -0,0 +1,121 @@
+package ram
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/8i8/session/errs"
+)
+
+// ErrNotSerializable is returned when a value is refused by the
+// Serializable check of the store.
+var ErrNotSerializable = errs.New(errs.NotSerializable, "value not serializable")
+
+// JSONSerializable returns an error if the value may not be encoded by
+// encoding/json, as the write-ahead log and snapshots are.
+func JSONSerializable(value interface{}) error {
+	_, err := json.Marshal(value)
+	return err
+}
+
+// GobSerializable returns an error if the value may not be encoded by
+// encoding/gob as an interface value, as the records of the file package
+// are. The concrete type must have been registered with gob.
+func GobSerializable(value interface{}) error {
+	return gob.NewEncoder(&bytes.Buffer{}).Encode(&value)
+}
+
+// unserializable is the error by which a value was refused.
+type unserializable struct {
+	key string
+	err error
+}
+
+func (u unserializable) Error() string {
+	return fmt.Sprintf("key %q: %s: %s", u.key, ErrNotSerializable, u.err)
+}
+
+func (u unserializable) Unwrap() error {
+	return u.err
+}
+
+// Is reports whether target matches ErrNotSerializable.
+func (u unserializable) Is(target error) bool {
+	return errors.Is(ErrNotSerializable, target)
+}
+
+// serializable applies the Serializable check of the store to the value
+// of key, if the store has one. This function is to be run only by the
+// sessionServer function.
+func (s *Store) serializable(key string, value interface{}) error {
+	if s.checkValue == nil {
+		return nil
+	}
+	if err := s.checkValue(value); err != nil {
+		return unserializable{key: key, err: err}
+	}
+	return nil
+}
+
+// UnserializablePolicy defines what becomes of a value that may not be
+// encoded as JSON when a session is encoded, as by MarshalJSON.
+type UnserializablePolicy int
+
+const (
+	// FailFast fails the encoding with an error matching
+	// ErrNotSerializable that names the key, it is the default.
+	FailFast UnserializablePolicy = iota
+	// SkipUnserializable omits the key.
+	SkipUnserializable
+	// StringifyUnserializable replaces the value by its fmt.Sprint
+	// form, or by its type should it refer to itself.
+	StringifyUnserializable
+)
+
+// Sanitize returns a copy of the snapshot whose data may be encoded as
+// JSON, along with the sorted keys of the values that could not be,
+// dealt with according to policy. Under FailFast the first such key in
+// order is returned with an error matching ErrNotSerializable.
+func (snap Snapshot) Sanitize(policy UnserializablePolicy) (Snapshot, []string, error) {
+	keys := make([]string, 0, len(snap.Data))
+	for k := range snap.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	data := make(map[string]interface{}, len(snap.Data))
+	var bad []string
+	for _, k := range keys {
+		v := snap.Data[k]
+		err := JSONSerializable(v)
+		if err == nil {
+			data[k] = v
+			continue
+		}
+		bad = append(bad, k)
+		switch policy {
+		case SkipUnserializable:
+		case StringifyUnserializable:
+			data[k] = stringify(v, err)
+		default:
+			return Snapshot{}, bad, unserializable{key: k, err: err}
+		}
+	}
+	snap.Data = data
+	return snap, bad, nil
+}
+
+// stringify returns the fmt.Sprint form of the value that encoding/json
+// refused for err, or its type should it refer to itself, which fmt
+// would follow without end.
+func stringify(v interface{}, err error) string {
+	var uv *json.UnsupportedValueError
+	if errors.As(err, &uv) && strings.Contains(uv.Str, "cycle") {
+		return fmt.Sprintf("%T", v)
+	}
+	return fmt.Sprint(v)
+}
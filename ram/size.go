@@ -0,0 +1,203 @@
+package ram
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+// ErrValueTooLarge is returned when a value exceeds the stores maximum
+// value size, it also matches ErrCapacity.
+var ErrValueTooLarge = errs.New(errs.Capacity, "value too large")
+
+// sizeOf returns an estimate of the memory used by a key value pair.
+// Strings and byte slices count their length, any other value counts
+// the size of its type alone, memory that it references is not
+// counted.
+func sizeOf(key, value interface{}) int {
+	return valueSize(key) + valueSize(value)
+}
+
+// valueSize returns an estimate of the memory used by v.
+func valueSize(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	return int(reflect.TypeOf(v).Size())
+}
+
+// MaxBytes sets the total size that the data of all sessions in the
+// store may reach, as estimated by the store. A Set or Create that
+// would exceed it either evicts sessions, in accordance with the
+// capacity policy, until the new data fits or, if the policy is
+// RejectNew, fails with ErrCapacity. A value of zero or less, the
+// default, removes the limit. The previous value is returned.
+func (s *Store) MaxBytes(n int) (previous int) {
+	s.exec(func() {
+		previous = s.maxBytes
+		s.maxBytes = n
+	})
+	return
+}
+
+// MaxValueBytes sets the greatest length of a string or byte slice that
+// may be stored under a single key, a Set that exceeds it fails with
+// ErrValueTooLarge. Values of any other type are exempt, as their size
+// is not known. A value of zero or less, the default, removes the
+// limit. The previous value is returned.
+func (s *Store) MaxValueBytes(n int) (previous int) {
+	s.exec(func() {
+		previous = s.maxValue
+		s.maxValue = n
+	})
+	return
+}
+
+// tooLarge returns true if the value exceeds the stores maximum value
+// size. This function is to be run only by the sessionServer function.
+func (s *Store) tooLarge(value interface{}) bool {
+	if s.maxValue <= 0 {
+		return false
+	}
+	switch v := value.(type) {
+	case string:
+		return len(v) > s.maxValue
+	case []byte:
+		return len(v) > s.maxValue
+	}
+	return false
+}
+
+// Bytes returns the estimated total size of the data of all sessions
+// in the store.
+func (s *Store) Bytes() (n int) {
+	s.exec(func() {
+		n = s.bytes
+	})
+	return
+}
+
+// Size returns the estimated size of the sessions data.
+func (s Session) Size() (n int, err error) {
+	const fname = "Session.Size"
+	if s.zero() {
+		return 0, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	s.sto.exec(func() {
+		se, ok := s.sto.sessions[s.id]
+		if !ok {
+			err = s.sto.goneErr(s.id)
+		}
+		n = se.size
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// fit ensures that delta further bytes may be added to the store,
+// evicting sessions other than those of keep if the capacity policy
+// permits it, returning false if they may not. This function is to be
+// run only by the sessionServer function.
+func (s *Store) fit(delta int, keep ...uuid.UUID) bool {
+	const fname = "Store.fit"
+	if s.maxBytes <= 0 || delta <= 0 {
+		return true
+	}
+	// Evicting every other session will not help.
+	size := delta
+	for _, id := range keep {
+		size += s.sessions[id].size
+	}
+	if size > s.maxBytes {
+		return false
+	}
+	defer s.hold()()
+	for s.bytes+delta > s.maxBytes {
+		if !s.evict(keep, fname) {
+			return false
+		}
+	}
+	return true
+}
+
+// resize adds delta to the recorded size of the session and the store.
+// This function is to be run only by the sessionServer function.
+func (s *Store) resize(id uuid.UUID, delta int) {
+	se, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	se.size += delta
+	s.sessions[id] = se
+	s.bytes += delta
+	s.markDirty(id)
+}
+
+// put stores the key value pair in the session, returning ErrCapacity
+// if it will not fit in the store, ErrValueTooLarge if the value
+// exceeds the maximum value size, ErrNotSerializable if it is refused
+// by the Serializable check of the store or ErrReservedKey if the key is
+// reserved. This function is to be run only by the sessionServer
+// function.
+func (s *Store) put(se Session, key string, value interface{}) error {
+	if reserved(key) {
+		return ErrReservedKey
+	}
+	return s.putKey(se, key, value)
+}
+
+// putKey is put, for any key, including those reserved by the store.
+// This function is to be run only by the sessionServer function.
+func (s *Store) putKey(se Session, key string, value interface{}) error {
+	if s.tooLarge(value) {
+		return ErrValueTooLarge
+	}
+	if err := s.serializable(key, value); err != nil {
+		return err
+	}
+	delta := sizeOf(key, value)
+	if old, ok := se.data[key]; ok {
+		delta -= sizeOf(key, old)
+	}
+	if !s.fit(delta, se.id) {
+		return ErrCapacity
+	}
+	err := s.logPut(se, func(data map[string]interface{}) {
+		data[key] = value
+	})
+	if err != nil {
+		return err
+	}
+	se.data[key] = value
+	s.resize(se.id, delta)
+	s.publish(se.id, key, value)
+	return nil
+}
+
+// remove deletes the key from the session, returning the error of the
+// write-ahead log, if any, in which case the key is kept. This function
+// is to be run only by the sessionServer function.
+func (s *Store) remove(se Session, key string) error {
+	old, ok := se.data[key]
+	if !ok {
+		return nil
+	}
+	err := s.logPut(se, func(data map[string]interface{}) {
+		delete(data, key)
+	})
+	if err != nil {
+		return err
+	}
+	delete(se.data, key)
+	s.resize(se.id, -sizeOf(key, old))
+	return nil
+}
@@ -0,0 +1,354 @@
+package ram
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/8i8/session/stamp"
+	"github.com/google/uuid"
+)
+
+// Snapshot is a copy of a session, detached from the store. Until is
+// its scheduled destruction, see DestroyAfter, Tags its tags in order
+// and Parent the session to which it is linked, if any, see Link.
+type Snapshot struct {
+	ID       uuid.UUID
+	Data     map[string]interface{}
+	Created  time.Time
+	Modified time.Time
+	MaxAge   time.Duration
+	Owner    string
+	Until    time.Time
+	Frozen   bool
+	Pinned   bool
+	Tags     []string
+	Parent   uuid.UUID
+}
+
+// snapshotJSON is the JSON form of a snapshot, its timestamps are
+// stamped such that they read back as the same instant wherever they
+// are decoded.
+type snapshotJSON struct {
+	ID       uuid.UUID
+	Data     map[string]interface{}
+	Created  stamp.Stamp
+	Modified stamp.Stamp
+	MaxAge   time.Duration
+	Owner    string
+	Until    stamp.Stamp `json:",omitempty"`
+	Frozen   bool        `json:",omitempty"`
+	Pinned   bool        `json:",omitempty"`
+	Tags     []string    `json:",omitempty"`
+	Parent   uuid.UUID
+}
+
+// MarshalJSON encodes the snapshot with its timestamps as stamps.
+func (snap Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(snapshotJSON{
+		ID:       snap.ID,
+		Data:     snap.Data,
+		Created:  stamp.Of(snap.Created),
+		Modified: stamp.Of(snap.Modified),
+		MaxAge:   snap.MaxAge,
+		Owner:    snap.Owner,
+		Until:    stamp.Of(snap.Until),
+		Frozen:   snap.Frozen,
+		Pinned:   snap.Pinned,
+		Tags:     snap.Tags,
+		Parent:   snap.Parent,
+	})
+}
+
+// UnmarshalJSON decodes a snapshot encoded by MarshalJSON, or by an
+// earlier version in which the timestamps were encoded as text.
+func (snap *Snapshot) UnmarshalJSON(b []byte) error {
+	var j snapshotJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*snap = Snapshot{
+		ID:       j.ID,
+		Data:     j.Data,
+		Created:  j.Created.Time(),
+		Modified: j.Modified.Time(),
+		MaxAge:   j.MaxAge,
+		Owner:    j.Owner,
+		Until:    j.Until.Time(),
+		Frozen:   j.Frozen,
+		Pinned:   j.Pinned,
+		Tags:     j.Tags,
+		Parent:   j.Parent,
+	}
+	return nil
+}
+
+// snapshot returns a copy of the session, the data map is copied such
+// that the snapshot may be used outside of the session server. Its tags
+// and parent are those held by the store for its SID. This function is
+// to be run only by the sessionServer function.
+func (s Session) snapshot() Snapshot {
+	data := make(map[string]interface{}, len(s.data))
+	for k, v := range s.data {
+		if key, ok := k.(string); ok {
+			data[key] = v
+		}
+	}
+	var tags []string
+	for tag := range s.sto.tagsOf[s.id] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return Snapshot{
+		ID:       s.id,
+		Data:     data,
+		Created:  s.created,
+		Modified: s.modified,
+		MaxAge:   s.maxage,
+		Owner:    s.owner,
+		Until:    s.until,
+		Frozen:   s.frozen,
+		Pinned:   s.pinned,
+		Tags:     tags,
+		Parent:   s.sto.parents[s.id],
+	}
+}
+
+// Snapshot returns a copy of the session, detached from the store, as
+// may be imported into another. The session is touched.
+func (s Session) Snapshot() (snap Snapshot, err error) {
+	const fname = "Session.Snapshot"
+	if s.zero() {
+		return snap, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		snap = se.snapshot()
+	})
+	if gone != nil {
+		return Snapshot{}, fmt.Errorf("%s: %w", fname, gone)
+	}
+	return
+}
+
+// MarshalJSON encodes the sessions data as a JSON object. A value that
+// may not be encoded is dealt with according to the Unserializable
+// policy of the store, by default failing the encoding, see
+// EncodeJSON.
+func (s Session) MarshalJSON() ([]byte, error) {
+	const fname = "Session.MarshalJSON"
+	if s.zero() {
+		return nil, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	b, _, err := s.EncodeJSON(s.sto.unserializable)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return b, nil
+}
+
+// EncodeJSON encodes the sessions data as a JSON object, dealing with
+// values that may not be encoded according to policy, and returns the
+// sorted keys of those values.
+func (s Session) EncodeJSON(policy UnserializablePolicy) (b []byte, keys []string, err error) {
+	const fname = "Session.EncodeJSON"
+	if s.zero() {
+		return nil, nil, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	var snap Snapshot
+	gone := s.sto.update(s.id, func(se Session) {
+		snap = se.snapshot()
+	})
+	if gone != nil {
+		return nil, nil, fmt.Errorf("%s: %w", fname, gone)
+	}
+	snap, keys, err = snap.Sanitize(policy)
+	if err != nil {
+		return nil, keys, fmt.Errorf("%s: %w", fname, err)
+	}
+	b, err = json.Marshal(snap.Data)
+	if err != nil {
+		return nil, keys, fmt.Errorf("%s: %w", fname, err)
+	}
+	return b, keys, nil
+}
+
+// Export returns a snapshot of every session in the store, in order of
+// creation save that a session follows the session to which it is
+// linked, such that they may be imported in turn. The data of a
+// snapshot may hold values that can not be encoded as JSON, see
+// Snapshot.Sanitize.
+func (s *Store) Export() (snaps []Snapshot) {
+	s.exec(func() {
+		snaps = make([]Snapshot, 0, len(s.array))
+		s.each(func(se Session) {
+			snaps = append(snaps, se.snapshot())
+		})
+	})
+	return lineage(snaps)
+}
+
+// lineage orders the snapshots such that each follows its parent, if
+// it is among them, keeping their order otherwise.
+func lineage(snaps []Snapshot) []Snapshot {
+	kids := make(map[uuid.UUID][]Snapshot)
+	has := make(map[uuid.UUID]bool, len(snaps))
+	for _, snap := range snaps {
+		has[snap.ID] = true
+	}
+	roots := make([]Snapshot, 0, len(snaps))
+	for _, snap := range snaps {
+		if has[snap.Parent] {
+			kids[snap.Parent] = append(kids[snap.Parent], snap)
+			continue
+		}
+		roots = append(roots, snap)
+	}
+	out := snaps[:0:0]
+	var walk func(snap Snapshot)
+	walk = func(snap Snapshot) {
+		out = append(out, snap)
+		for _, kid := range kids[snap.ID] {
+			walk(kid)
+		}
+	}
+	for _, snap := range roots {
+		walk(snap)
+	}
+	return out
+}
+
+// Import adds a session to the store from a snapshot, preserving its
+// data, timestamps, maxage, schedule and tags, returning ErrExists if
+// its SID is already in use. The session is linked to its parent should
+// that be in the store. A last used time later than the present, as
+// may come of clock skew between hosts, is taken to be the present. A
+// session that has already expired is imported, to be removed by the
+// next timeout verification, unless the store was configured to
+// RejectExpired in which case ErrTimedOut is returned.
+func (s *Store) Import(snap Snapshot) (Session, error) {
+	return s.importSnapshot("Store.Import", snap, false)
+}
+
+// ImportReplace is Import, replacing any session of the same SID in the
+// same operation of the session server, such that the SID is never
+// without a session. The children of the session replaced are kept.
+// Should the snapshot not be imported the session replaced is left as
+// it was.
+func (s *Store) ImportReplace(snap Snapshot) (Session, error) {
+	return s.importSnapshot("Store.ImportReplace", snap, true)
+}
+
+// importSnapshot adds the session of the snapshot to the store, in place
+// of any of the same SID if replace is set.
+func (s *Store) importSnapshot(fname string, snap Snapshot, replace bool) (se Session, err error) {
+	if invalid(snap.ID) {
+		return se, fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	data := make(valueStore, len(snap.Data))
+	var size int
+	for k, v := range snap.Data {
+		k = s.normalize(k)
+		data[k] = v
+		size += sizeOf(k, v)
+	}
+	s.exec(func() {
+		modified := snap.Modified
+		if now := s.clock.Now(); modified.After(now) {
+			modified = now
+		}
+		se = Session{
+			id:       snap.ID,
+			data:     data,
+			created:  snap.Created,
+			modified: modified,
+			sto:      s,
+			maxage:   snap.MaxAge,
+			owner:    snap.Owner,
+			until:    snap.Until,
+			frozen:   snap.Frozen,
+			pinned:   snap.Pinned,
+			active:   true,
+			size:     size,
+		}
+		if s.rejectExpired && s.expired(se) {
+			se, err = Session{}, ErrTimedOut
+			return
+		}
+		if s.taken(se.id) && replace {
+			se, err = s.overwrite(se, snap.Tags, snap.Parent)
+			return
+		}
+		if s.taken(se.id) {
+			se, err = Session{}, ErrExists
+			return
+		}
+		s.adopt(se.id, snap.Tags, snap.Parent)
+		if se, err = s.insert(se); err != nil {
+			s.disown(snap.ID)
+		}
+	})
+	if err != nil {
+		return se, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// overwrite puts se in the place of the session of the same SID, with
+// the tags and the parent given, keeping its children, returning the
+// error should it not fit or not be logged, in which case the session
+// is left as it was. This function is to be run only by the
+// sessionServer function.
+func (s *Store) overwrite(se Session, tags []string, parent uuid.UUID) (Session, error) {
+	old := s.sessions[se.id]
+	delta := se.size - old.size
+	if !s.fit(delta, se.id) {
+		return Session{}, ErrCapacity
+	}
+	var was []string
+	for tag := range s.tagsOf[se.id] {
+		was = append(was, tag)
+	}
+	wasParent := s.parents[se.id]
+	s.disown(se.id)
+	s.adopt(se.id, tags, parent)
+	if err := s.logPut(se, nil); err != nil {
+		s.disown(se.id)
+		s.adopt(se.id, was, wasParent)
+		return Session{}, err
+	}
+	se.index = old.index
+	s.sessions[se.id] = se
+	s.bytes += delta
+	s.markDirty(se.id)
+	s.lruTouch(se.id)
+	return se, nil
+}
+
+// adopt gives the session sid the tags and the parent given, ahead of
+// its insertion such that they are logged with it. It is linked to the
+// parent only should that be in the store and not be sid or one of its
+// descendants. This function is to be run only by the sessionServer
+// function.
+func (s *Store) adopt(sid uuid.UUID, tags []string, parent uuid.UUID) {
+	for _, tag := range tags {
+		s.tag(sid, tag)
+	}
+	if !s.taken(parent) {
+		return
+	}
+	for id, ok := parent, true; ok; id, ok = s.parents[id] {
+		if id == sid {
+			return
+		}
+	}
+	s.parents[sid] = parent
+	s.children[parent] = append(s.children[parent], sid)
+}
+
+// disown undoes adopt, should the session not have been inserted. This
+// function is to be run only by the sessionServer function.
+func (s *Store) disown(sid uuid.UUID) {
+	s.untagAll(sid)
+	s.detach(sid)
+}
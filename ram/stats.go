@@ -0,0 +1,136 @@
+package ram
+
+import (
+	"time"
+)
+
+// Logger receives the informational events of the store.
+type Logger interface {
+	Info(pkg, fname, event string, args ...interface{})
+}
+
+// diagLogger is the default Logger, it passes events on to the
+// Diagnostics of the store as debug events.
+type diagLogger struct {
+	d Diagnostics
+}
+
+// Info passes the event on as a debug event, if there is anywhere to
+// pass it.
+func (l diagLogger) Info(pkg, fname, event string, args ...interface{}) {
+	if l.d != nil {
+		l.d.Debug(nil, pkg, fname, event, args...)
+	}
+}
+
+// Logger sets the Logger that receives the stores informational events,
+// the default passes them on to the stores Diagnostics. The previous
+// Logger is returned.
+func (s *Store) Logger(l Logger) (previous Logger) {
+	s.exec(func() {
+		previous = s.logger
+		s.logger = l
+	})
+	return
+}
+
+// Name returns the name of the store given in its Config, empty if it
+// has none.
+func (s *Store) Name() string {
+	return s.name
+}
+
+// label returns the label of the stores log events, its name if it has
+// one or else the package name.
+func (s *Store) label() string {
+	if s == nil || s.name == "" {
+		return pkg
+	}
+	return s.name
+}
+
+// Stats are the session counts of a store.
+type Stats struct {
+	// Live is the number of sessions in the store.
+	Live int
+	// Peak is the greatest number of sessions held at once.
+	Peak int
+	// Created counts the sessions added to the store.
+	Created uint64
+	// Destroyed counts the sessions removed from the store, for any
+	// reason.
+	Destroyed uint64
+	// Expired counts the sessions removed for having timed out.
+	Expired uint64
+}
+
+// Len returns the number of sessions in the store.
+func (s *Store) Len() (n int) {
+	s.exec(func() {
+		n = len(s.sessions)
+	})
+	return
+}
+
+// Stats returns the session counts of the store.
+func (s *Store) Stats() (st Stats) {
+	s.exec(func() {
+		st = s.stats
+		st.Live = len(s.sessions)
+	})
+	return
+}
+
+// StatsInterval sets the interval at which the store writes a summary
+// of its session counts to its Logger, the live and peak counts along
+// with the number of sessions created, destroyed and expired since the
+// previous summary. A value of zero or less, the default, writes none,
+// as does a closed store. The previous value is returned.
+func (s *Store) StatsInterval(d time.Duration) (previous time.Duration) {
+	s.exec(func() {
+		previous = s.statsEvery
+		s.statsEvery = d
+		if s.statsStop != nil {
+			close(s.statsStop)
+			s.statsStop = nil
+		}
+		if d > 0 && !s.closed {
+			s.statsStop = make(chan struct{})
+			go s.logStats(d, s.statsStop)
+		}
+	})
+	return
+}
+
+// logStats writes a summary of the stores session counts every d until
+// stop is closed, to the Logger of the store should it have one.
+func (s *Store) logStats(d time.Duration, stop chan struct{}) {
+	const fname = "Store.logStats"
+	const event = "session stats"
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		var st, last Stats
+		var l Logger
+		s.exec(func() {
+			st, last = s.stats, s.statsLast
+			st.Live = len(s.sessions)
+			s.statsLast = st
+			l = s.logger
+		})
+		if l == nil {
+			continue
+		}
+		l.Info(s.label(), fname, event,
+			"live", st.Live,
+			"peak", st.Peak,
+			"created", st.Created-last.Created,
+			"destroyed", st.Destroyed-last.Destroyed,
+			"expired", st.Expired-last.Expired)
+	}
+}
@@ -0,0 +1,112 @@
+package ram
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// subscriptionBuffer is the number of values that a subscriber may fall
+// behind by before the oldest is dropped.
+const subscriptionBuffer = 16
+
+// subscription is a channel by which the values given to one key of a
+// session are delivered.
+type subscription struct {
+	ch     chan interface{}
+	closed bool
+}
+
+// Subscribe returns a channel that receives each new value given to key
+// by Set, or by any other method that stores a value whole, along with a
+// function that cancels the subscription. Delivery is lossy: a
+// subscriber that falls behind by more than 16 values loses the oldest,
+// such that it never holds up the store. The channel is closed on
+// cancellation or once the session has been removed.
+func (s Session) Subscribe(key string) (values <-chan interface{}, cancel func(), err error) {
+	const fname = "Session.Subscribe"
+	if s.zero() {
+		return nil, nil, fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	key = s.sto.normalize(key)
+	sub := &subscription{ch: make(chan interface{}, subscriptionBuffer)}
+	gone := s.sto.update(s.id, func(se Session) {
+		if s.sto.subs == nil {
+			s.sto.subs = make(map[uuid.UUID]map[string][]*subscription)
+		}
+		keys := s.sto.subs[s.id]
+		if keys == nil {
+			keys = make(map[string][]*subscription)
+			s.sto.subs[s.id] = keys
+		}
+		keys[key] = append(keys[key], sub)
+	})
+	if gone != nil {
+		return nil, nil, fmt.Errorf("%s: %w", fname, gone)
+	}
+	cancel = func() {
+		s.sto.exec(func() {
+			s.sto.unsubscribe(s.id, key, sub)
+		})
+	}
+	return sub.ch, cancel, nil
+}
+
+// unsubscribe removes the subscription and closes its channel, if it
+// has not already been. This function is to be run only by the
+// sessionServer function.
+func (s *Store) unsubscribe(sid uuid.UUID, key string, sub *subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	subs := s.subs[sid][key]
+	for i, o := range subs {
+		if o == sub {
+			subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) > 0 {
+		s.subs[sid][key] = subs
+		return
+	}
+	delete(s.subs[sid], key)
+	if len(s.subs[sid]) == 0 {
+		delete(s.subs, sid)
+	}
+}
+
+// publish delivers the value given to key to its subscribers, dropping
+// the oldest undelivered value of any that has fallen behind. This
+// function is to be run only by the sessionServer function.
+func (s *Store) publish(sid uuid.UUID, key string, value interface{}) {
+	for _, sub := range s.subs[sid][key] {
+		select {
+		case sub.ch <- value:
+			continue
+		default:
+		}
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- value:
+		default:
+		}
+	}
+}
+
+// unsubscribeAll closes every subscription to the session sid. This
+// function is to be run only by the sessionServer function.
+func (s *Store) unsubscribeAll(sid uuid.UUID) {
+	for _, subs := range s.subs[sid] {
+		for _, sub := range subs {
+			sub.closed = true
+			close(sub.ch)
+		}
+	}
+	delete(s.subs, sid)
+}
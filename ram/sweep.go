@@ -0,0 +1,248 @@
+package ram
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultSweepPressure is the number of sessions about to expire at
+// which an adaptive sweep period is shortened, when
+// Config.SweepPressure is not set.
+const defaultSweepPressure = 1
+
+// SweepOrder defines the order in which the timeout verification
+// destroys expired sessions.
+type SweepOrder int
+
+const (
+	// MapOrder destroys sessions in no particular order, it is the
+	// fastest and the default.
+	MapOrder SweepOrder = iota
+	// DeadlineOrder destroys sessions in order of their expiry, those
+	// that expire at the same time in order of creation, such that
+	// hooks are called in a reproducible sequence.
+	DeadlineOrder
+)
+
+// SweepSink receives the SIDs of the sessions removed by each timeout
+// verification, as such it may propagate the removals to an external
+// mirror of the store, as written by a Persister, such that the mirror
+// does not accumulate sessions that have expired. Unlike OnExpireBatch
+// the SIDs include those of the children removed with an expired
+// session, see Link, and Reaped is called once the verification is
+// complete from outside of the session server, such that it may take
+// its time and use the store. It is not called for a verification that
+// removes nothing.
+type SweepSink interface {
+	Reaped(sids []uuid.UUID)
+}
+
+// SweepSinkFunc adapts an ordinary function to a SweepSink.
+type SweepSinkFunc func(sids []uuid.UUID)
+
+// Reaped calls f(sids).
+func (f SweepSinkFunc) Reaped(sids []uuid.UUID) {
+	f(sids)
+}
+
+// SweepBatch sets the number of sessions that the timeout verification
+// examines each period. Rather than scanning the whole store at once,
+// which may hold up the session server for some time when the store is
+// large, each sweep continues from where the previous one stopped,
+// working round the array of sessions, such that every session is
+// examined at least once every len/n periods. The near expiry function
+// and an adaptive period are then also given only the sessions of the
+// batch, see OnNearExpiry and Config.SweepMin. A value of zero or less,
+// the default, examines every session on every sweep. The previous
+// value is returned.
+func (s *Store) SweepBatch(n int) (previous int) {
+	s.exec(func() {
+		previous = s.sweepBatch
+		s.sweepBatch = n
+	})
+	return
+}
+
+// sweepIncremental examines the next batch of sessions in the array,
+// destroying those that have timed out, and returns the SIDs of those
+// that remain. This function is to be run only by the sessionServer
+// function.
+func (s *Store) sweepIncremental(sender string) []uuid.UUID {
+	batch := make([]uuid.UUID, 0, s.sweepBatch)
+	for n := 0; n < s.sweepBatch && len(s.array) > 0; n++ {
+		if s.sweepPos >= len(s.array) {
+			s.sweepPos = 0
+		}
+		key := s.array[s.sweepPos]
+		se, ok := s.sessions[key]
+		if ok && s.expired(se) {
+			// The array closes over the removed session,
+			// leaving sweepPos on the next one, unless it is
+			// left as a hole to be passed over.
+			s.expire(key, sender)
+			continue
+		}
+		// A batch larger than the store comes round again to
+		// the sessions already taken.
+		if ok && len(batch) < len(s.sessions) {
+			batch = append(batch, key)
+		}
+		s.sweepPos++
+	}
+	return batch
+}
+
+// eachSwept calls fn for each session examined by a timeout
+// verification: those of batch, in incremental mode, where batch is not
+// nil, else every session in the store. This function is to be run only
+// by the sessionServer function.
+func (s *Store) eachSwept(batch []uuid.UUID, fn func(se Session)) {
+	if batch == nil {
+		s.each(fn)
+		return
+	}
+	for _, id := range batch {
+		if se, ok := s.sessions[id]; ok {
+			fn(se)
+		}
+	}
+}
+
+// Order sets the order in which a full sweep destroys expired sessions,
+// an incremental sweep always works in order of creation. The previous
+// value is returned.
+func (s *Store) Order(o SweepOrder) (previous SweepOrder) {
+	s.exec(func() {
+		previous = s.sweepOrder
+		s.sweepOrder = o
+	})
+	return
+}
+
+// sweepOrdered destroys every expired session in order of expiry, then
+// of creation. This function is to be run only by the sessionServer
+// function.
+func (s *Store) sweepOrdered(sender string) {
+	var expired []Session
+	s.each(func(se Session) {
+		if s.expired(se) {
+			expired = append(expired, se)
+		}
+	})
+	// The array is in order of creation, a stable sort by deadline
+	// suffices.
+	sort.SliceStable(expired, func(i, j int) bool {
+		return deadline(expired[i]).Before(deadline(expired[j]))
+	})
+	for _, se := range expired {
+		s.expire(se.id, sender)
+	}
+}
+
+// adaptive reports whether the sweep period of the store adapts to the
+// sessions that are about to expire.
+func (s *Store) adaptive() bool {
+	return s.sweepMin > 0 && s.sweepMax > 0
+}
+
+// nextSweep returns the time to wait until the next timeout
+// verification. This function is to be run only by the sessionServer
+// function.
+func (s *Store) nextSweep() time.Duration {
+	if s.adaptive() && s.sweepEvery > 0 {
+		return s.sweepEvery
+	}
+	return s.period
+}
+
+// adapt sets the time until the next timeout verification, in adaptive
+// mode, by the number of sessions that will expire before it: halving
+// it, down to the minimum, when there are at least the sweep pressure,
+// doubling it, up to the maximum, when there are none. Only the sessions
+// of the sweep are counted, see eachSwept. This function is to be run
+// only by the sessionServer function.
+func (s *Store) adapt(batch []uuid.UUID) {
+	if !s.adaptive() {
+		return
+	}
+	every := s.nextSweep()
+	now := s.clock.Now()
+	near := 0
+	s.eachSwept(batch, func(se Session) {
+		if se.pinned || se.frozen {
+			return
+		}
+		if deadline(se).Sub(now) < every {
+			near++
+		}
+	})
+	switch {
+	case near >= s.sweepPressure:
+		every /= 2
+	case near == 0:
+		every *= 2
+	}
+	if every < s.sweepMin {
+		every = s.sweepMin
+	}
+	if every > s.sweepMax {
+		every = s.sweepMax
+	}
+	s.sweepEvery = every
+}
+
+// NextSweep returns the time, by the clock of the store, at which the
+// timer is next to run the timeout verification, taking into account
+// changes to the period and, in adaptive mode, the period chosen by the
+// last sweep. The zero time is returned once the store is closed.
+func (s *Store) NextSweep() (at time.Time) {
+	s.exec(func() {
+		if !s.closed {
+			at = s.sweepAt
+		}
+	})
+	return
+}
+
+// plan records that the timer is to sleep for d before the next timeout
+// verification. This function is to be run only by the sessionServer
+// function.
+func (s *Store) plan(d time.Duration) {
+	s.sweepFrom = s.clock.Now()
+	s.sweepAt = s.sweepFrom.Add(d)
+	s.sweepMoved = false
+	// A change of period made since the last plan is already taken.
+	select {
+	case <-s.wake:
+	default:
+	}
+}
+
+// wait sleeps for d, then for as long again as a change of period has
+// put off the planned sweep.
+func (s *Store) wait(d time.Duration, sleep func(time.Duration)) {
+	for d > 0 {
+		sleep(d)
+		s.exec(func() {
+			d = 0
+			if s.sweepMoved {
+				d = s.sweepAt.Sub(s.clock.Now())
+				s.sweepMoved = false
+			}
+		})
+	}
+}
+
+// sleep is the default sleep of the timer, it returns early should the
+// period be changed or the store be closed.
+func (s *Store) sleep(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-s.wake:
+	case <-s.done:
+	}
+}
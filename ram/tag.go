@@ -0,0 +1,154 @@
+package ram
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// Tag marks the session with tag, such that it may be found or
+// destroyed along with the other sessions of that tag. A session may
+// carry any number of tags, a tag that it already carries is ignored.
+func (s Session) Tag(tag string) (err error) {
+	const fname = "Session.Tag"
+	if s.zero() {
+		return fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		if _, ok := s.sto.tagsOf[s.id][tag]; ok {
+			return
+		}
+		s.sto.tag(s.id, tag)
+		if err = s.sto.logPut(se, nil); err != nil {
+			s.sto.untag(s.id, tag)
+			return
+		}
+		s.sto.markDirty(s.id)
+	})
+	if gone != nil {
+		return fmt.Errorf("%s: %w", fname, gone)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return nil
+}
+
+// Untag removes tag from the session, a tag that it does not carry is
+// ignored.
+func (s Session) Untag(tag string) (err error) {
+	const fname = "Session.Untag"
+	if s.zero() {
+		return fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	gone := s.sto.update(s.id, func(se Session) {
+		if _, ok := s.sto.tagsOf[s.id][tag]; !ok {
+			return
+		}
+		s.sto.untag(s.id, tag)
+		if err = s.sto.logPut(se, nil); err != nil {
+			s.sto.tag(s.id, tag)
+			return
+		}
+		s.sto.markDirty(s.id)
+	})
+	if gone != nil {
+		return fmt.Errorf("%s: %w", fname, gone)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return nil
+}
+
+// SessionsWithTag returns the SIDs of the live sessions that carry tag,
+// in order of creation.
+func (s *Store) SessionsWithTag(tag string) (sids []uuid.UUID) {
+	s.exec(func() {
+		sids = s.withTag(tag)
+	})
+	return
+}
+
+// DestroyTag destroys every session that carries tag in one operation
+// of the session server, returning the number destroyed, including any
+// linked to them as children. Should the destruction of a session fail
+// to be logged, those that follow it are left in place and the error
+// is returned.
+func (s *Store) DestroyTag(tag string) (n int, err error) {
+	const fname = "Store.DestroyTag"
+	s.exec(func() {
+		before := len(s.sessions)
+		for _, sid := range s.withTag(tag) {
+			// A session may have gone with a linked parent.
+			if _, ok := s.sessions[sid]; !ok {
+				continue
+			}
+			if err = s.logDestroy(sid); err != nil {
+				break
+			}
+			s.record(OpDestroy, sid, "")
+			s.destroy(sid, fname)
+		}
+		n = before - len(s.sessions)
+	})
+	if err != nil {
+		return n, fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// withTag returns the SIDs of the sessions that carry tag in order of
+// creation. This function is to be run only by the sessionServer
+// function.
+func (s *Store) withTag(tag string) []uuid.UUID {
+	sids := make([]uuid.UUID, 0, len(s.tagged[tag]))
+	for sid := range s.tagged[tag] {
+		sids = append(sids, sid)
+	}
+	// The array, and so the index, is in order of creation.
+	sort.Slice(sids, func(i, j int) bool {
+		return s.sessions[sids[i]].index < s.sessions[sids[j]].index
+	})
+	return sids
+}
+
+// tag adds tag to the session sid. This function is to be run only by
+// the sessionServer function.
+func (s *Store) tag(sid uuid.UUID, tag string) {
+	if s.tagged == nil {
+		s.tagged = make(map[string]map[uuid.UUID]struct{})
+		s.tagsOf = make(map[uuid.UUID]map[string]struct{})
+	}
+	if s.tagged[tag] == nil {
+		s.tagged[tag] = make(map[uuid.UUID]struct{})
+	}
+	if s.tagsOf[sid] == nil {
+		s.tagsOf[sid] = make(map[string]struct{})
+	}
+	s.tagged[tag][sid] = struct{}{}
+	s.tagsOf[sid][tag] = struct{}{}
+}
+
+// untag removes tag from the session sid. This function is to be run
+// only by the sessionServer function.
+func (s *Store) untag(sid uuid.UUID, tag string) {
+	delete(s.tagsOf[sid], tag)
+	if len(s.tagsOf[sid]) == 0 {
+		delete(s.tagsOf, sid)
+	}
+	delete(s.tagged[tag], sid)
+	if len(s.tagged[tag]) == 0 {
+		delete(s.tagged, tag)
+	}
+}
+
+// untagAll removes every tag from the session sid, as it is removed
+// from the store. This function is to be run only by the sessionServer
+// function.
+func (s *Store) untagAll(sid uuid.UUID) {
+	for tag := range s.tagsOf[sid] {
+		s.untag(sid, tag)
+	}
+}
@@ -0,0 +1,207 @@
+package ram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/8i8/session/errs"
+	"github.com/google/uuid"
+)
+
+// ErrDestroyed is returned when using a session that has been
+// destroyed, rather than having timed out.
+var ErrDestroyed = errs.New(errs.Destroyed, "session destroyed")
+
+// defaultTombstones is the number of recently removed sessions whose
+// cause of removal is remembered.
+const defaultTombstones = 1024
+
+// cause is the reason for which a session was removed.
+type cause int
+
+const (
+	causeDestroyed cause = iota + 1
+	causeExpired
+)
+
+// Reasons recorded in the tombstones of sessions removed by the store
+// itself, or destroyed without a reason being given.
+const (
+	ReasonDestroyed = "destroyed"
+	ReasonExpired   = "expired"
+	ReasonEvicted   = "evicted"
+	ReasonCascade   = "parent removed"
+	ReasonClosed    = "store closed"
+	ReasonRegen     = "regenerated"
+)
+
+// tombstone is the record of the removal of a session.
+type tombstone struct {
+	cause  cause
+	at     time.Time
+	reason string
+}
+
+// TombstoneInfo describes the removal of a session.
+type TombstoneInfo struct {
+	ID uuid.UUID
+	// At is the time of removal, by the clock of the store.
+	At time.Time
+	// Reason is that given to DestroyReason, or one of the Reason
+	// constants.
+	Reason string
+	// Expired is true if the session timed out.
+	Expired bool
+}
+
+// tombstones is a ring recording the cause of removal of the most
+// recently removed sessions.
+type tombstones struct {
+	ids  []uuid.UUID
+	next int
+	slot map[uuid.UUID]int
+	info map[uuid.UUID]tombstone
+}
+
+func newTombstones(n int) *tombstones {
+	return &tombstones{
+		ids:  make([]uuid.UUID, n),
+		slot: make(map[uuid.UUID]int),
+		info: make(map[uuid.UUID]tombstone),
+	}
+}
+
+// add records the removal of id, overwriting the oldest record once the
+// ring is full.
+func (t *tombstones) add(id uuid.UUID, ts tombstone) {
+	if len(t.ids) == 0 {
+		return
+	}
+	if _, ok := t.slot[id]; ok {
+		t.info[id] = ts
+		return
+	}
+	old := t.ids[t.next]
+	if i, ok := t.slot[old]; ok && i == t.next {
+		t.remove(old)
+	}
+	t.ids[t.next] = id
+	t.slot[id] = t.next
+	t.info[id] = ts
+	t.next = (t.next + 1) % len(t.ids)
+}
+
+// remove forgets id, as a session of that id exists once more.
+func (t *tombstones) remove(id uuid.UUID) {
+	delete(t.slot, id)
+	delete(t.info, id)
+}
+
+// reap forgets the records of removals made before cutoff.
+func (t *tombstones) reap(cutoff time.Time) {
+	for id, ts := range t.info {
+		if ts.at.Before(cutoff) {
+			t.remove(id)
+		}
+	}
+}
+
+// trim forgets the tombstones and operations older than their retention
+// windows, it is called by the timeout verification. Both are otherwise
+// bounded in number by their rings. This function is to be run only by
+// the sessionServer function.
+func (s *Store) trim() {
+	now := s.clock.Now()
+	if keep := s.tombsKeep; keep > 0 {
+		s.tombs.reap(now.Add(-keep))
+	}
+	if keep := s.opLog.keep; keep > 0 {
+		s.opLog.reap(now.Add(-keep))
+	}
+}
+
+// bury records the removal of the session sid for the reason given.
+// This function is to be run only by the sessionServer function.
+func (s *Store) bury(sid uuid.UUID, c cause, reason string) {
+	s.tombs.add(sid, tombstone{cause: c, at: s.clock.Now(), reason: reason})
+}
+
+// Tombstone returns the record of the removal of the session sid, if it
+// was removed recently enough to be remembered: within the retention
+// window of the store, if it has one, and among the most recent
+// removals, by default 1024, see Config.MaxTombstones.
+func (s *Store) Tombstone(sid uuid.UUID) (info TombstoneInfo, ok bool) {
+	s.exec(func() {
+		var ts tombstone
+		ts, ok = s.tombs.info[sid]
+		info = TombstoneInfo{
+			ID:      sid,
+			At:      ts.at,
+			Reason:  ts.reason,
+			Expired: ts.cause == causeExpired,
+		}
+	})
+	if !ok {
+		return TombstoneInfo{}, false
+	}
+	return
+}
+
+// DestroyReason is Destroy, recording reason in the tombstone of the
+// session.
+func (s *Store) DestroyReason(sid uuid.UUID, reason string) (err error) {
+	const fname = "Store.DestroyReason"
+	if invalid(sid) {
+		return fmt.Errorf("%s: %w", fname, ErrPoorForm)
+	}
+	s.exec(func() {
+		if _, ok := s.sessions[sid]; !ok {
+			err = s.goneErr(sid)
+			return
+		}
+		if err = s.logDestroy(sid); err != nil {
+			return
+		}
+		s.record(OpDestroy, sid, "")
+		s.destroy(sid, fname)
+		s.bury(sid, causeDestroyed, reason)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// gone is the error returned for a session that has been removed, it
+// matches both its cause and ErrNoSession.
+type gone struct {
+	err error
+}
+
+func (g gone) Error() string {
+	return g.err.Error()
+}
+
+func (g gone) Unwrap() error {
+	return g.err
+}
+
+// Is reports whether target matches ErrNoSession.
+func (g gone) Is(target error) bool {
+	return errors.Is(ErrNoSession, target)
+}
+
+// goneErr returns the error for the absent session sid, ErrTimedOut if
+// it expired, ErrDestroyed if it was destroyed, both of which
+// also match ErrNoSession, or ErrNoSession if the store has no record
+// of it. This function is to be run only by the sessionServer function.
+func (s *Store) goneErr(sid uuid.UUID) error {
+	switch s.tombs.info[sid].cause {
+	case causeExpired:
+		return gone{ErrTimedOut}
+	case causeDestroyed:
+		return gone{ErrDestroyed}
+	}
+	return ErrNoSession
+}
@@ -0,0 +1,44 @@
+package ram
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Touch extends the life of the session without otherwise using it. In
+// a store of NoImplicitTouch it is, along with Restore and RenewIf, the
+// only means by which a session is kept alive.
+func (s Session) Touch() (err error) {
+	const fname = "Session.Touch"
+	if s.zero() {
+		return fmt.Errorf("%s: %w", fname, ErrInvalidSession)
+	}
+	s.sto.exec(func() {
+		_, err = command{cmd: touch, key: s.id, seStore: s.sto}.touch()
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return
+}
+
+// use returns the live session sid for an operation upon its data,
+// touching it unless the store is of NoImplicitTouch, returning the
+// reason if it is not live. This function is to be run only by the
+// sessionServer function.
+func (s *Store) use(sid uuid.UUID) (Session, error) {
+	const fname = "Store.use"
+	if !s.noImplicitTouch {
+		return command{cmd: touch, key: sid, seStore: s}.touch()
+	}
+	se, ok := s.sessions[sid]
+	if ok && s.expired(se) {
+		s.expire(sid, fname)
+		ok = false
+	}
+	if !ok {
+		return Session{}, s.goneErr(sid)
+	}
+	return se, nil
+}
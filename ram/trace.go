@@ -0,0 +1,67 @@
+package ram
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tracer starts the spans by which the operations of the store are
+// traced, it may be an adapter to OpenTelemetry or to any other tracing
+// system. Start is given the context passed to a context aware method
+// and returns the context of the new span, or a nil Span if the
+// operation is not to be traced, as when ctx carries no parent span.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation traced by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the operation.
+	SetAttribute(key string, value interface{})
+	// End ends the span, err is the outcome of the operation.
+	End(err error)
+}
+
+// Span attributes set on every traced operation.
+const (
+	AttrOp       = "session.op"
+	AttrSID      = "session.sid"
+	AttrHit      = "session.hit"
+	AttrDuration = "session.duration"
+)
+
+// span starts a span for the operation op upon sid, returning the
+// function by which it is ended with the outcome of the operation, or
+// nil if there is no Tracer or the Tracer declines to trace it. Without
+// a Tracer nothing is allocated.
+func (s *Store) span(ctx context.Context, op string, sid uuid.UUID) func(err error) {
+	if s.tracer == nil {
+		return nil
+	}
+	_, sp := s.tracer.Start(ctx, "session."+op)
+	if sp == nil {
+		return nil
+	}
+	start := time.Now()
+	return func(err error) {
+		sp.SetAttribute(AttrOp, op)
+		sp.SetAttribute(AttrSID, sid.String())
+		sp.SetAttribute(AttrHit, err == nil)
+		sp.SetAttribute(AttrDuration, time.Since(start))
+		sp.End(err)
+	}
+}
+
+// RestoreContext is Restore, traced as a child of the span of ctx when
+// the store has a Tracer, and counted by the labels of ctx, see
+// WithLabels. The error of ctx is returned should it be done before the
+// session server answers.
+func (s *Store) RestoreContext(ctx context.Context, sid uuid.UUID) (se Session, err error) {
+	if end := s.span(ctx, "Restore", sid); end != nil {
+		defer func() { end(err) }()
+	}
+	defer func() { s.countLabels(ctx, "Restore", err) }()
+	return s.restoreSession(ctx, "Store.RestoreContext", sid)
+}
@@ -0,0 +1,265 @@
+package ram
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// defaultWALCompact is the number of entries after which the write-ahead
+// log is compacted, when Config.WALCompact is not set.
+const defaultWALCompact = 1000
+
+// walEntry is a line of the write-ahead log, either the whole of a
+// session that has changed or the SID of one that has been removed.
+type walEntry struct {
+	Put *Snapshot `json:",omitempty"`
+	Del uuid.UUID
+}
+
+// wal is the write-ahead log of a store, it is used only by the session
+// server. Sessions are written as the JSON of their snapshots, as such
+// their values are recovered as encoding/json decodes them. The use of
+// a session is not itself a change, a recovered session is counted as
+// last used when it was last changed.
+type wal struct {
+	path      string
+	f         *os.File
+	sync      bool
+	size      int64
+	entries   int
+	compactAt int
+}
+
+// openWAL replays the write-ahead log at path, if there is one,
+// returning the sessions that it holds in the order in which they were
+// first written. A line left incomplete by a crash ends the replay,
+// along with whatever follows it.
+func openWAL(path string) (snaps []Snapshot, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	live := make(map[uuid.UUID]int)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<30)
+	for sc.Scan() {
+		var e walEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			break
+		}
+		if e.Put == nil {
+			if i, ok := live[e.Del]; ok {
+				snaps[i].ID = uuid.Nil
+				delete(live, e.Del)
+			}
+			continue
+		}
+		if i, ok := live[e.Put.ID]; ok {
+			snaps[i] = *e.Put
+			continue
+		}
+		live[e.Put.ID] = len(snaps)
+		snaps = append(snaps, *e.Put)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, snap := range snaps {
+		if snap.ID != uuid.Nil {
+			snaps[n] = snap
+			n++
+		}
+	}
+	return snaps[:n], nil
+}
+
+// append writes the entry to the end of the log. Should the write fail
+// the log is cut back to its previous length, such that a part written
+// line does not end the replay of those that follow it.
+func (w *wal) append(e walEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n, err := w.f.Write(append(b, '\n'))
+	if err == nil && w.sync {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			w.f.Truncate(w.size)
+		}
+		return err
+	}
+	w.size += int64(n)
+	w.entries++
+	return nil
+}
+
+// rewrite replaces the log with one that holds only the given sessions,
+// atomically, such that a crash part way leaves the previous log.
+func (w *wal) rewrite(snaps []Snapshot) error {
+	tmp := w.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for i := range snaps {
+		if err = enc.Encode(walEntry{Put: &snaps[i]}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, w.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if w.f != nil {
+		w.f.Close()
+	}
+	w.f, err = os.OpenFile(w.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	w.size = fi.Size()
+	w.entries = len(snaps)
+	return nil
+}
+
+// logPut writes the session, as it is to be once changed by edit, to
+// the write-ahead log, if the store keeps one. It is called ahead of the
+// change, which is not to be made should it return an error. This
+// function is to be run only by the sessionServer function.
+func (s *Store) logPut(se Session, edit func(data map[string]interface{})) error {
+	if s.wal == nil {
+		return nil
+	}
+	snap := se.snapshot()
+	if edit != nil {
+		edit(snap.Data)
+	}
+	return s.walWrite(walEntry{Put: &snap})
+}
+
+// logDel writes the removal of the session to the write-ahead log, if
+// the store keeps one, ahead of the removal. This function is to be run
+// only by the sessionServer function.
+func (s *Store) logDel(sid uuid.UUID) error {
+	if s.wal == nil {
+		return nil
+	}
+	if s.walDel == sid {
+		// Logged by logDestroy.
+		s.walDel = uuid.Nil
+		return nil
+	}
+	return s.walWrite(walEntry{Del: sid})
+}
+
+// logDestroy writes the removal of the session to the write-ahead log
+// ahead of its destruction at the request of the user, such that should
+// it fail the session may be kept and the error returned. This function
+// is to be run only by the sessionServer function.
+func (s *Store) logDestroy(sid uuid.UUID) error {
+	if s.wal == nil {
+		return nil
+	}
+	if err := s.walWrite(walEntry{Del: sid}); err != nil {
+		return err
+	}
+	s.walDel = sid
+	return nil
+}
+
+// walWrite appends the entry to the log, first compacting it should it
+// hold too many, whilst the sessions are as the log has them. A failure
+// to compact leaves the previous log in place, it is reported to the
+// diagnostics and the entry appended regardless. This function is to be
+// run only by the sessionServer function.
+func (s *Store) walWrite(e walEntry) error {
+	const fname = "Store.walWrite"
+	if s.wal.entries >= s.wal.compactAt {
+		if err := s.compactWAL(); err != nil {
+			if d := s.diag; d != nil {
+				const event = "write-ahead log compaction failed"
+				d.Err(err, s.label(), fname, event)
+			}
+		}
+	}
+	return s.wal.append(e)
+}
+
+// compactWAL replaces the log with a snapshot of every session. This
+// function is to be run only by the sessionServer function.
+func (s *Store) compactWAL() error {
+	snaps := make([]Snapshot, 0, len(s.sessions))
+	s.each(func(se Session) {
+		snaps = append(snaps, se.snapshot())
+	})
+	return s.wal.rewrite(snaps)
+}
+
+// CompactWAL replaces the write-ahead log of the store with a snapshot
+// of its sessions, as is otherwise done every Config.WALCompact
+// entries.
+func (s *Store) CompactWAL() (err error) {
+	s.exec(func() {
+		if s.wal != nil {
+			err = s.compactWAL()
+		}
+	})
+	return
+}
+
+// recoverWAL loads the sessions held by the write-ahead log at path into
+// the store and then starts a fresh log holding them. This function is
+// to be called only by OpenWith.
+func (s *Store) recoverWAL(path string, compactAt int, sync bool) error {
+	snaps, err := openWAL(path)
+	if err != nil {
+		return err
+	}
+	for _, snap := range lineage(snaps) {
+		_, err := s.Import(snap)
+		if err != nil && !errors.Is(err, ErrTimedOut) {
+			return err
+		}
+	}
+	if compactAt <= 0 {
+		compactAt = defaultWALCompact
+	}
+	w := &wal{path: path, sync: sync, compactAt: compactAt}
+	s.exec(func() {
+		s.wal = w
+		if err = s.compactWAL(); err != nil {
+			s.wal = nil
+		}
+	})
+	return err
+}
@@ -0,0 +1,86 @@
+package ram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/8i8/session/errs"
+)
+
+// ErrNotWindow is returned by WindowIncrement when the key holds a value
+// other than a Window.
+var ErrNotWindow = errs.New(errs.NotWindow, "value is not a window counter")
+
+// Window is the value kept by WindowIncrement, a count of the events
+// since the start of the current window.
+type Window struct {
+	Count int64
+	Start time.Time
+}
+
+// WindowIncrement counts an event in the fixed window held under key,
+// such as for rate limiting, returning the count within the window and
+// the time at which the window ends. Should the window have ended, or
+// the key be unset, a new window starts now with a count of one. The
+// test and the increment are made in one operation of the session
+// server, by its clock. ErrNotWindow is returned if the key holds some
+// other value.
+func (s Session) WindowIncrement(key string, window time.Duration) (count int64, resetAt time.Time, err error) {
+	const fname = "Session.WindowIncrement"
+	fail := func(err error) (int64, time.Time, error) {
+		return 0, time.Time{}, fmt.Errorf("%s: %w", fname, err)
+	}
+	if s.zero() {
+		return fail(ErrInvalidSession)
+	}
+	key = s.sto.normalize(key)
+	gone := s.sto.update(s.id, func(se Session) {
+		now := s.sto.clock.Now()
+		w, ok := windowOf(se.data[key])
+		if _, set := se.data[key]; set && !ok {
+			err = ErrNotWindow
+			return
+		}
+		if !ok || !now.Before(w.Start.Add(window)) {
+			w = Window{Start: now}
+		}
+		w.Count++
+		s.sto.record(OpSet, s.id, key)
+		if err = s.sto.put(se, key, w); err != nil {
+			return
+		}
+		count, resetAt = w.Count, w.Start.Add(window)
+	})
+	if gone != nil {
+		err = gone
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return
+}
+
+// windowOf returns the Window held by v, which may be in the form of a
+// map given it by encoding/json, as when a session is recovered from
+// the write-ahead log.
+func windowOf(v interface{}) (Window, bool) {
+	switch v := v.(type) {
+	case Window:
+		return v, true
+	case map[string]interface{}:
+		n, ok := v["Count"].(float64)
+		if !ok {
+			return Window{}, false
+		}
+		str, ok := v["Start"].(string)
+		if !ok {
+			return Window{}, false
+		}
+		start, err := time.Parse(time.RFC3339Nano, str)
+		if err != nil {
+			return Window{}, false
+		}
+		return Window{Count: int64(n), Start: start}, true
+	}
+	return Window{}, false
+}
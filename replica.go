@@ -0,0 +1,125 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// ReplicaManager is a session manager that serves restores from a pool
+// of read replicas of a primary manager, such as read only clients of a
+// replicated remote store, and sends creation and destruction to the
+// primary. Replicas are used in turn.
+//
+// A replica may lag the primary. For lag after a session is created,
+// restores are sent to the primary for the given staleness tolerance.
+// A restore that fails on a replica, whether for an error or because
+// the replica has yet to see the session, is retried on the primary.
+//
+// A session restored from a replica belongs to that replica, as such
+// it is for reading. Use RestorePrimary to restore a session for
+// writing.
+type ReplicaManager struct {
+	Manager
+	replicas []Manager
+	lag      time.Duration
+	next     uint32
+	now      func() time.Time
+	mu       sync.Mutex
+	recent   map[uuid.UUID]time.Time
+	// pruneAt is the number of recent creations at which those older
+	// than the tolerance are next pruned.
+	pruneAt int
+}
+
+// NewReplicaManager returns a ReplicaManager that writes to primary and
+// reads from replicas, sending the restores of sessions created within
+// lag to the primary.
+func NewReplicaManager(primary Manager, lag time.Duration, replicas ...Manager) *ReplicaManager {
+	return &ReplicaManager{
+		Manager:  primary,
+		replicas: replicas,
+		lag:      lag,
+		now:      time.Now,
+		recent:   make(map[uuid.UUID]time.Time),
+		pruneAt:  minPrune,
+	}
+}
+
+// Create makes the session in the primary.
+func (r *ReplicaManager) Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error) {
+	se, err := r.Manager.Create(sid, maxage, opts...)
+	if err != nil {
+		return se, err
+	}
+	r.created(sid)
+	return se, nil
+}
+
+// Restore restores the session from the next replica, or from the
+// primary if it was created recently, if there are no replicas or if
+// the replica fails.
+func (r *ReplicaManager) Restore(sid uuid.UUID) (ram.Session, error) {
+	if len(r.replicas) == 0 || r.isRecent(sid) {
+		return r.Manager.Restore(sid)
+	}
+	n := atomic.AddUint32(&r.next, 1)
+	replica := r.replicas[int(n-1)%len(r.replicas)]
+	if se, err := replica.Restore(sid); err == nil {
+		return se, nil
+	}
+	return r.Manager.Restore(sid)
+}
+
+// RestorePrimary restores the session from the primary, for writing.
+func (r *ReplicaManager) RestorePrimary(sid uuid.UUID) (ram.Session, error) {
+	return r.Manager.Restore(sid)
+}
+
+// Destroy destroys the session in the primary.
+func (r *ReplicaManager) Destroy(sid uuid.UUID) error {
+	r.mu.Lock()
+	delete(r.recent, sid)
+	r.mu.Unlock()
+	return r.Manager.Destroy(sid)
+}
+
+// isRecent reports whether the session was created within the
+// staleness tolerance.
+func (r *ReplicaManager) isRecent(sid uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at, ok := r.recent[sid]
+	if ok && r.now().Sub(at) >= r.lag {
+		delete(r.recent, sid)
+		ok = false
+	}
+	return ok
+}
+
+// created records the creation of the session, pruning the creations
+// older than the tolerance whenever the record doubles in size.
+func (r *ReplicaManager) created(sid uuid.UUID) {
+	if r.lag <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.recent[sid] = now
+	if len(r.recent) < r.pruneAt {
+		return
+	}
+	for id, at := range r.recent {
+		if now.Sub(at) >= r.lag {
+			delete(r.recent, id)
+		}
+	}
+	r.pruneAt = 2 * len(r.recent)
+	if r.pruneAt < minPrune {
+		r.pruneAt = minPrune
+	}
+}
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReplicaManager(t *testing.T) {
+	const fname = "TestReplicaManager"
+	primary := &countingManager{Manager: NewManager(RAM)}
+	replicas := []*countingManager{
+		{Manager: NewManager(RAM)},
+		{Manager: NewManager(RAM)},
+	}
+	r := NewReplicaManager(primary, time.Second, replicas[0], replicas[1])
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	sid := uuid.New()
+	if _, err := r.Create(sid, 3600); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := replicas[0].Restore(sid); !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want the write on the primary alone got (%T, %+v)",
+			fname, err, err)
+	}
+	replicas[0].restores = 0
+
+	// Just created, the session is read from the primary.
+	if _, err := r.Restore(sid); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if primary.restores != 1 || replicas[0].restores+replicas[1].restores != 0 {
+		t.Errorf("%s: want 1 restore of the primary got %d", fname,
+			primary.restores)
+	}
+
+	// Once replicated, reads are shared between the replicas.
+	for _, rep := range replicas {
+		rep.Manager.Create(sid, 3600)
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 4; i++ {
+		if _, err := r.Restore(sid); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+	}
+	if primary.restores != 1 || replicas[0].restores != 2 ||
+		replicas[1].restores != 2 {
+		t.Errorf("%s: want 1 2 2 restores got %d %d %d", fname,
+			primary.restores, replicas[0].restores, replicas[1].restores)
+	}
+
+	// A replica that lags is passed over for the primary.
+	replicas[0].Manager.Destroy(sid)
+	if _, err := r.Restore(sid); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if primary.restores != 2 {
+		t.Errorf("%s: want 2 restores of the primary got %d", fname,
+			primary.restores)
+	}
+
+	if err := r.Destroy(sid); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := r.RestorePrimary(sid); !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+}
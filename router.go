@@ -0,0 +1,172 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// ErrNoNodes is returned by a Router that has no backends.
+var ErrNoNodes = errors.New("router has no nodes")
+
+// defaultReplicas is the number of points that each node is given on
+// the ring when none is specified.
+const defaultReplicas = 128
+
+// Router is a Manager that shares sessions between several backend
+// managers, such as clients of separate session server processes. Each
+// SID belongs to the backend that follows its hash on a consistent hash
+// ring, such that adding or removing a node moves only the sessions of
+// that node's share of the ring.
+type Router struct {
+	mu       sync.RWMutex
+	replicas int
+	nodes    map[string]Manager
+	ring     []uint64
+	owner    map[uint64]string
+}
+
+// NewRouter returns a Router with no nodes that places each node at
+// replicas points on the ring, more points spread the sessions more
+// evenly. A value of zero or less uses a default.
+func NewRouter(replicas int) *Router {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	return &Router{
+		replicas: replicas,
+		nodes:    make(map[string]Manager),
+		owner:    make(map[uint64]string),
+	}
+}
+
+// hash returns the position of b on the ring.
+func hash(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// Add adds the backend m to the ring under name, replacing any backend
+// of the same name. Sessions whose SIDs now belong to m are not moved,
+// they are no longer found until migrated or recreated.
+func (r *Router) Add(name string, m Manager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[name]; !ok {
+		for i := 0; i < r.replicas; i++ {
+			p := hash([]byte(name + "#" + strconv.Itoa(i)))
+			r.owner[p] = name
+			r.ring = append(r.ring, p)
+		}
+		sort.Slice(r.ring, func(i, j int) bool {
+			return r.ring[i] < r.ring[j]
+		})
+	}
+	r.nodes[name] = m
+}
+
+// Remove removes the backend name from the ring, its SIDs passing to the
+// nodes that follow it.
+func (r *Router) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[name]; !ok {
+		return
+	}
+	delete(r.nodes, name)
+	ring := r.ring[:0]
+	for _, p := range r.ring {
+		if r.owner[p] == name {
+			delete(r.owner, p)
+			continue
+		}
+		ring = append(ring, p)
+	}
+	r.ring = ring
+}
+
+// Node returns the name of the backend to which sid belongs, empty if
+// there are none.
+func (r *Router) Node(sid uuid.UUID) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, _ := r.lookup(sid)
+	return name
+}
+
+// lookup returns the backend to which sid belongs, the caller holds the
+// lock.
+func (r *Router) lookup(sid uuid.UUID) (string, Manager) {
+	if len(r.ring) == 0 {
+		return "", nil
+	}
+	h := hash(sid[:])
+	i := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i] >= h
+	})
+	if i == len(r.ring) {
+		i = 0
+	}
+	name := r.owner[r.ring[i]]
+	return name, r.nodes[name]
+}
+
+// route returns the backend to which sid belongs.
+func (r *Router) route(sid uuid.UUID) (Manager, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, m := r.lookup(sid)
+	if m == nil {
+		return nil, ErrNoNodes
+	}
+	return m, nil
+}
+
+// Create makes the session in the backend to which sid belongs.
+func (r *Router) Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error) {
+	const fname = "Router.Create"
+	m, err := r.route(sid)
+	if err != nil {
+		return ram.Session{}, fmt.Errorf("%s: %w", fname, err)
+	}
+	return m.Create(sid, maxage, opts...)
+}
+
+// Restore restores the session from the backend to which sid belongs.
+func (r *Router) Restore(sid uuid.UUID) (ram.Session, error) {
+	const fname = "Router.Restore"
+	m, err := r.route(sid)
+	if err != nil {
+		return ram.Session{}, fmt.Errorf("%s: %w", fname, err)
+	}
+	return m.Restore(sid)
+}
+
+// Destroy destroys the session in the backend to which sid belongs.
+func (r *Router) Destroy(sid uuid.UUID) error {
+	const fname = "Router.Destroy"
+	m, err := r.route(sid)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return m.Destroy(sid)
+}
+
+// Period sets the timeout verification period of every backend,
+// returning the previous period of one of them.
+func (r *Router) Period(t time.Duration) (previous time.Duration) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, m := range r.nodes {
+		previous = m.Period(t)
+	}
+	return
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRouter(t *testing.T) {
+	const fname = "TestRouter"
+	r := NewRouter(0)
+	_, err := r.Create(uuid.New(), 0)
+	if !errors.Is(err, ErrNoNodes) {
+		t.Errorf("%s: want ErrNoNodes got (%T, %+v)", fname, err, err)
+	}
+	names := []string{"a", "b", "c", "d"}
+	backends := make(map[string]Manager)
+	for _, name := range names {
+		backends[name] = NewManager(RAM)
+		r.Add(name, backends[name])
+	}
+
+	// A session is made in, and found in, its own backend alone.
+	id := uuid.New()
+	if _, err := r.Create(id, 0); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := r.Restore(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	for name, m := range backends {
+		_, err := m.Restore(id)
+		if own := name == r.Node(id); own != (err == nil) {
+			t.Errorf("%s: %s: owner %v got (%T, %+v)", fname, name, own,
+				err, err)
+		}
+	}
+
+	const n = 2000
+	ids := make([]uuid.UUID, n)
+	before := make([]string, n)
+	for i := range ids {
+		ids[i] = uuid.New()
+		before[i] = r.Node(ids[i])
+		if again := r.Node(ids[i]); again != before[i] {
+			t.Fatalf("%s: want %s got %s", fname, before[i], again)
+		}
+	}
+
+	// Adding a fifth node takes about a fifth of the SIDs, all from
+	// the others to itself.
+	r.Add("e", NewManager(RAM))
+	var moved int
+	for i, id := range ids {
+		after := r.Node(id)
+		if after == before[i] {
+			continue
+		}
+		moved++
+		if after != "e" {
+			t.Errorf("%s: want move to e got %s", fname, after)
+		}
+	}
+	if moved == 0 || moved > n*35/100 {
+		t.Errorf("%s: want about %d moved got %d", fname, n/5, moved)
+	}
+
+	// Removing it restores the previous placement.
+	r.Remove("e")
+	for i, id := range ids {
+		if after := r.Node(id); after != before[i] {
+			t.Errorf("%s: want %s got %s", fname, before[i], after)
+			break
+		}
+	}
+}
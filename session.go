@@ -1,12 +1,48 @@
 package session
 
 import (
+	"errors"
+	"sync"
 	"time"
 
+	"github.com/8i8/session/errs"
 	"github.com/8i8/session/ram"
 	"github.com/google/uuid"
 )
 
+// Error is the error type returned by the session providers, Code
+// identifies the kind of failure.
+type Error = errs.Error
+type Code = errs.Code
+
+// The errors returned by the session providers wrap an Error, which
+// matches these by its code, such that errors.Is(err, ErrNotFound)
+// holds whichever provider returned err.
+var (
+	ErrNotFound  = errs.New(errs.NotFound, "session not found")
+	ErrExists    = errs.New(errs.Exists, "session already exists")
+	ErrTimedOut  = errs.New(errs.TimedOut, "session timed out")
+	ErrInvalidID = errs.New(errs.InvalidID, "invalid session id")
+	ErrNoData    = errs.New(errs.NoData, "data not found in session")
+	ErrCapacity  = errs.New(errs.Capacity, "session store at capacity")
+	ErrConflict  = errs.New(errs.Conflict, "session data conflict")
+	ErrRejected  = errs.New(errs.Rejected, "session creation rejected")
+
+	ErrInvalidSession  = errs.New(errs.InvalidSession, "invalid session")
+	ErrDestroyed       = errs.New(errs.Destroyed, "session destroyed")
+	ErrNotOwner        = errs.New(errs.NotOwner, "session not owned by user")
+	ErrBusy            = errs.New(errs.Busy, "session store busy")
+	ErrInternal        = errs.New(errs.Internal, "session store internal error")
+	ErrNotSerializable = errs.New(errs.NotSerializable, "value not serializable")
+	ErrUnavailable     = errs.New(errs.Unavailable, "session backend unavailable")
+	ErrClosed          = errs.New(errs.Closed, "session store closed")
+	ErrCycle           = errs.New(errs.Cycle, "session link would form a cycle")
+	ErrNotWindow       = errs.New(errs.NotWindow, "value not a window counter")
+	ErrUnresponsive    = errs.New(errs.Unresponsive, "session store unresponsive")
+	ErrOtherStore      = errs.New(errs.OtherStore, "sessions of different stores")
+	ErrReservedKey     = errs.New(errs.Reserved, "key reserved by the session store")
+)
+
 // Sessioner maintains users session data whilst they are logged into
 // the application.
 type Sessioner interface {
@@ -16,9 +52,49 @@ type Sessioner interface {
 	Valid() (ok bool)
 }
 
+// Haser is implemented by sessions that can report whether they hold a
+// key without returning its value, see Has.
+type Haser interface {
+	Has(key string) (ok bool, err error)
+}
+
+// Has reports whether the session holds a value paired with key, by its
+// own Has if it is a Haser. Errors concerning the session itself are
+// still returned.
+func Has(s Sessioner, key string) (ok bool, err error) {
+	if h, ok := s.(Haser); ok {
+		return h.Has(key)
+	}
+	_, err = s.Get(key)
+	if errors.Is(err, ErrNoData) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Defaulter is implemented by sessions that can return a default for a
+// key that they do not hold, see GetDefault.
+type Defaulter interface {
+	GetDefault(key string, def interface{}) (value interface{}, err error)
+}
+
+// GetDefault returns the value paired with key in the session, or def
+// should the session hold no such key, by its own GetDefault if it is a
+// Defaulter. Errors concerning the session itself are still returned.
+func GetDefault(s Sessioner, key string, def interface{}) (value interface{}, err error) {
+	if d, ok := s.(Defaulter); ok {
+		return d.GetDefault(key, def)
+	}
+	value, err = s.Get(key)
+	if errors.Is(err, ErrNoData) {
+		return def, nil
+	}
+	return
+}
+
 // Provider administers concrete sessions, in all but longevity.
 type Provider interface {
-	Create(sid uuid.UUID, maxage int) (ram.Session, error)
+	Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error)
 	Restore(sid uuid.UUID) (ram.Session, error)
 	Destroy(sid uuid.UUID) error
 }
@@ -43,19 +119,18 @@ const (
 	RAM MemType = iota
 )
 
-// manager contains a session provider.
+// manager contains a session provider, which may be swapped for
+// another whilst in use, see swap.go.
 type manager struct {
 	Manager
+	mu       sync.RWMutex
+	draining Manager
 }
 
-// NewManager returns a session manager.
+// NewManager returns a session manager with default settings, see
+// Builder to configure one.
 func NewManager(mem MemType) Manager {
-	var m manager
-	switch mem {
-	case RAM:
-		m.Manager = ram.Init()
-	}
-	return m
+	return Builder().WithMemType(mem).Build()
 }
 
 // OptMgrFunc is a function used to set options on the session manager.
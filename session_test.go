@@ -4,6 +4,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/8i8/session/errs"
 	"github.com/8i8/session/ram"
 	"github.com/google/uuid"
 )
@@ -71,8 +72,8 @@ func TestActivate(t *testing.T) {
 		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
 	}
 	sess2, err := m.Create(id, 0)
-	if !errors.Is(err, ram.ErrNoSession) {
-		t.Errorf("%s: want %q got %q", fname, ram.ErrNoSession,
+	if !errors.Is(err, ram.ErrExists) {
+		t.Errorf("%s: want %q got %q", fname, ram.ErrExists,
 			err)
 	}
 	sess2, err = m.Restore(id)
@@ -180,3 +181,173 @@ func TestInterface(t *testing.T) {
 		t.Errorf("%s: want %q got %q", fname, str, str2)
 	}
 }
+
+func TestErrors(t *testing.T) {
+	const fname = "TestErrors"
+	m := NewManager(RAM)
+	id := uuid.New()
+	sess, err := m.Create(id, 0)
+	if err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+
+	// Already exists.
+	_, err = m.Create(id, 0)
+	if !errors.Is(err, ErrExists) {
+		t.Errorf("%s: want ErrExists got (%T, %+v)", fname, err, err)
+	}
+
+	// Invalid id.
+	_, err = m.Create(uuid.Nil, 0)
+	if !errors.Is(err, ErrInvalidID) {
+		t.Errorf("%s: want ErrInvalidID got (%T, %+v)", fname, err, err)
+	}
+	_, err = m.Restore(uuid.Nil)
+	if !errors.Is(err, ErrInvalidID) {
+		t.Errorf("%s: want ErrInvalidID got (%T, %+v)", fname, err, err)
+	}
+	err = m.Destroy(uuid.Nil)
+	if !errors.Is(err, ErrInvalidID) {
+		t.Errorf("%s: want ErrInvalidID got (%T, %+v)", fname, err, err)
+	}
+
+	// Not found.
+	_, err = sess.Get("missing")
+	if !errors.Is(err, ErrNoData) {
+		t.Errorf("%s: want ErrNoData got (%T, %+v)", fname, err, err)
+	}
+	if err = m.Destroy(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err = m.Restore(id)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+	err = m.Destroy(id)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+	_, err = sess.Get("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+
+	// Destroyed.
+	err = sess.Set("key", 1)
+	if !errors.Is(err, ErrDestroyed) || errors.Is(err, ErrTimedOut) {
+		t.Errorf("%s: want ErrDestroyed got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestErrorsMapping(t *testing.T) {
+	const fname = "TestErrorsMapping"
+	tests := []struct {
+		ram, public error
+	}{
+		{ram.ErrNoSession, ErrNotFound},
+		{ram.ErrExists, ErrExists},
+		{ram.ErrTimedOut, ErrTimedOut},
+		{ram.ErrPoorForm, ErrInvalidID},
+		{ram.ErrNoData, ErrNoData},
+		{ram.ErrCapacity, ErrCapacity},
+		{ram.ErrInvalidSession, ErrInvalidSession},
+		{ram.ErrDestroyed, ErrDestroyed},
+		{ram.ErrNotOwner, ErrNotOwner},
+		{ram.ErrBusy, ErrBusy},
+		{ram.ErrInternal, ErrInternal},
+		{ram.ErrNotSerializable, ErrNotSerializable},
+		{ram.ErrClosed, ErrClosed},
+		{ram.ErrCycle, ErrCycle},
+		{ram.ErrNotWindow, ErrNotWindow},
+		{ram.ErrUnresponsive, ErrUnresponsive},
+		{ram.ErrOtherStore, ErrOtherStore},
+		{ram.ErrReservedKey, ErrReservedKey},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.ram, test.public) {
+			t.Errorf("%s: want %q to match %q", fname, test.ram,
+				test.public)
+		}
+	}
+	distinct := []struct {
+		ram, public error
+	}{
+		{ram.ErrNoSession, ErrExists},
+		{ram.ErrClosed, ErrRejected},
+		{ram.ErrRejected, ram.ErrClosed},
+		{ram.ErrCycle, ErrConflict},
+		{ram.ErrNotWindow, ErrConflict},
+		{ram.ErrUnresponsive, ErrBusy},
+		{ram.ErrReservedKey, ErrInvalidID},
+	}
+	for _, test := range distinct {
+		if errors.Is(test.ram, test.public) {
+			t.Errorf("%s: want %q not to match %q", fname, test.ram,
+				test.public)
+		}
+	}
+	var e *Error
+	if !errors.As(ram.ErrNoData, &e) || e.Code != errs.NoData {
+		t.Errorf("%s: want code NoData got %+v", fname, e)
+	}
+}
+
+// getter is a Sessioner that is neither a Defaulter nor a Haser.
+type getter struct {
+	Sessioner
+	data map[string]interface{}
+}
+
+func (g getter) Get(key string) (interface{}, error) {
+	v, ok := g.data[key]
+	if !ok {
+		return nil, ram.ErrNoData
+	}
+	return v, nil
+}
+
+func TestGetDefault(t *testing.T) {
+	const fname = "TestGetDefault"
+	m := NewManager(RAM)
+	sess, err := m.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	sess.Set("flag", true)
+	g := getter{data: map[string]interface{}{"flag": true}}
+	for _, s := range []Sessioner{sess, g} {
+		if v, err := GetDefault(s, "flag", false); v != true || err != nil {
+			t.Errorf("%s: want (true, <nil>) got (%v, %v)", fname, v, err)
+		}
+		if v, err := GetDefault(s, "missing", "def"); v != "def" || err != nil {
+			t.Errorf("%s: want (def, <nil>) got (%v, %v)", fname, v, err)
+		}
+	}
+	m.Destroy(sess.ID())
+	if _, err := GetDefault(sess, "flag", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+}
+
+func TestHas(t *testing.T) {
+	const fname = "TestHas"
+	m := NewManager(RAM)
+	sess, err := m.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	sess.Set("flag", true)
+	g := getter{data: map[string]interface{}{"flag": true}}
+	for _, s := range []Sessioner{sess, g} {
+		if ok, err := Has(s, "flag"); !ok || err != nil {
+			t.Errorf("%s: want (true, <nil>) got (%v, %v)", fname, ok, err)
+		}
+		if ok, err := Has(s, "missing"); ok || err != nil {
+			t.Errorf("%s: want (false, <nil>) got (%v, %v)", fname, ok, err)
+		}
+	}
+	m.Destroy(sess.ID())
+	if _, err := Has(sess, "flag"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+}
@@ -0,0 +1,280 @@
+package sessiontest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/8i8/session"
+	"github.com/google/uuid"
+)
+
+// RunConformance runs the behaviours that every session provider is to
+// share against managers made by newManager, one for each behaviour,
+// such that a provider may be shown to behave as the others do. Errors
+// are matched by the sentinels of the session package. The behaviours
+// that concern expiry wait upon the clock for a few seconds, they are
+// run in parallel with one another.
+func RunConformance(t *testing.T, newManager func() session.Manager) {
+	for _, c := range conformance {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if c.slow {
+				t.Parallel()
+			}
+			c.fn(t, newManager())
+		})
+	}
+}
+
+// behaviour is a single check of the conformance suite.
+type behaviour struct {
+	name string
+	slow bool
+	fn   func(t *testing.T, m session.Manager)
+}
+
+var conformance = []behaviour{
+	{name: "CreateReturnsSession", fn: createReturnsSession},
+	{name: "CreateExisting", fn: createExisting},
+	{name: "RestoreCreated", fn: restoreCreated},
+	{name: "RestoreMissing", fn: restoreMissing},
+	{name: "RestoreKeepsData", fn: restoreKeepsData},
+	{name: "DestroyRemoves", fn: destroyRemoves},
+	{name: "DestroyMissing", fn: destroyMissing},
+	{name: "DestroyedHandle", fn: destroyedHandle},
+	{name: "RecreateDestroyed", fn: recreateDestroyed},
+	{name: "InvalidID", fn: invalidID},
+	{name: "GetMissing", fn: getMissing},
+	{name: "DelRemovesKey", fn: delRemovesKey},
+	{name: "SessionsIsolated", fn: sessionsIsolated},
+	{name: "ConcurrentCreate", fn: concurrentCreate},
+	{name: "Expiry", slow: true, fn: expiry},
+	{name: "RestoreExtends", slow: true, fn: restoreExtends},
+}
+
+// mustCreate creates a session of the given maxage, failing the test
+// should it not be created.
+func mustCreate(t *testing.T, m session.Manager, maxage int) uuid.UUID {
+	t.Helper()
+	sid := uuid.New()
+	if _, err := m.Create(sid, maxage); err != nil {
+		t.Fatalf("Create: want <nil> got (%T, %+v)", err, err)
+	}
+	return sid
+}
+
+func createReturnsSession(t *testing.T, m session.Manager) {
+	sid := uuid.New()
+	se, err := m.Create(sid, 0)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if se.ID() != sid {
+		t.Errorf("want ID %v got %v", sid, se.ID())
+	}
+	if !se.Valid() {
+		t.Errorf("want a valid session")
+	}
+}
+
+func createExisting(t *testing.T, m session.Manager) {
+	sid := mustCreate(t, m, 0)
+	if _, err := m.Create(sid, 0); !errors.Is(err, session.ErrExists) {
+		t.Errorf("want ErrExists got (%T, %+v)", err, err)
+	}
+}
+
+func restoreCreated(t *testing.T, m session.Manager) {
+	sid := mustCreate(t, m, 0)
+	se, err := m.Restore(sid)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if se.ID() != sid {
+		t.Errorf("want ID %v got %v", sid, se.ID())
+	}
+}
+
+func restoreMissing(t *testing.T, m session.Manager) {
+	_, err := m.Restore(uuid.New())
+	if !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("want ErrNotFound got (%T, %+v)", err, err)
+	}
+}
+
+func restoreKeepsData(t *testing.T, m session.Manager) {
+	sid := uuid.New()
+	se, err := m.Create(sid, 0)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if err := se.Set("user", "bob"); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	se, err = m.Restore(sid)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if v, err := se.Get("user"); err != nil || v != "bob" {
+		t.Errorf("want (bob, <nil>) got (%v, %v)", v, err)
+	}
+}
+
+func destroyRemoves(t *testing.T, m session.Manager) {
+	sid := mustCreate(t, m, 0)
+	if err := m.Destroy(sid); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	_, err := m.Restore(sid)
+	if !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("want ErrNotFound got (%T, %+v)", err, err)
+	}
+}
+
+func destroyMissing(t *testing.T, m session.Manager) {
+	err := m.Destroy(uuid.New())
+	if !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("want ErrNotFound got (%T, %+v)", err, err)
+	}
+	sid := mustCreate(t, m, 0)
+	if err := m.Destroy(sid); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if err := m.Destroy(sid); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("want ErrNotFound got (%T, %+v)", err, err)
+	}
+}
+
+func destroyedHandle(t *testing.T, m session.Manager) {
+	sid := uuid.New()
+	se, err := m.Create(sid, 0)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if err := m.Destroy(sid); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if err := se.Set("k", 1); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("want ErrNotFound got (%T, %+v)", err, err)
+	}
+	if _, err := se.Get("k"); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("want ErrNotFound got (%T, %+v)", err, err)
+	}
+	if se.Valid() {
+		t.Errorf("want the handle invalid")
+	}
+}
+
+func recreateDestroyed(t *testing.T, m session.Manager) {
+	sid := mustCreate(t, m, 0)
+	if err := m.Destroy(sid); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if _, err := m.Create(sid, 0); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if _, err := m.Restore(sid); err != nil {
+		t.Errorf("want <nil> got (%T, %+v)", err, err)
+	}
+}
+
+func invalidID(t *testing.T, m session.Manager) {
+	if _, err := m.Create(uuid.Nil, 0); !errors.Is(err, session.ErrInvalidID) {
+		t.Errorf("Create: want ErrInvalidID got (%T, %+v)", err, err)
+	}
+	if _, err := m.Restore(uuid.Nil); !errors.Is(err, session.ErrInvalidID) {
+		t.Errorf("Restore: want ErrInvalidID got (%T, %+v)", err, err)
+	}
+	if err := m.Destroy(uuid.Nil); !errors.Is(err, session.ErrInvalidID) {
+		t.Errorf("Destroy: want ErrInvalidID got (%T, %+v)", err, err)
+	}
+}
+
+func getMissing(t *testing.T, m session.Manager) {
+	se, err := m.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if _, err := se.Get("absent"); !errors.Is(err, session.ErrNoData) {
+		t.Errorf("want ErrNoData got (%T, %+v)", err, err)
+	}
+}
+
+func delRemovesKey(t *testing.T, m session.Manager) {
+	se, err := m.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if err := se.Set("k", 1); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if err := se.Del("k"); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if _, err := se.Get("k"); !errors.Is(err, session.ErrNoData) {
+		t.Errorf("want ErrNoData got (%T, %+v)", err, err)
+	}
+}
+
+func sessionsIsolated(t *testing.T, m session.Manager) {
+	a, err := m.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	b, err := m.Create(uuid.New(), 0)
+	if err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if err := a.Set("k", "a"); err != nil {
+		t.Fatalf("want <nil> got (%T, %+v)", err, err)
+	}
+	if _, err := b.Get("k"); !errors.Is(err, session.ErrNoData) {
+		t.Errorf("want ErrNoData got (%T, %+v)", err, err)
+	}
+}
+
+func concurrentCreate(t *testing.T, m session.Manager) {
+	const n = 50
+	sids := make([]uuid.UUID, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range sids {
+		sids[i] = uuid.New()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = m.Create(sids[i], 0)
+		}(i)
+	}
+	wg.Wait()
+	for i, sid := range sids {
+		if errs[i] != nil {
+			t.Errorf("Create: want <nil> got (%T, %+v)", errs[i], errs[i])
+			continue
+		}
+		if _, err := m.Restore(sid); err != nil {
+			t.Errorf("Restore: want <nil> got (%T, %+v)", err, err)
+		}
+	}
+}
+
+func expiry(t *testing.T, m session.Manager) {
+	sid := mustCreate(t, m, 1)
+	time.Sleep(1500 * time.Millisecond)
+	_, err := m.Restore(sid)
+	if !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("want ErrNotFound got (%T, %+v)", err, err)
+	}
+}
+
+func restoreExtends(t *testing.T, m session.Manager) {
+	sid := mustCreate(t, m, 2)
+	for i := 0; i < 2; i++ {
+		time.Sleep(1200 * time.Millisecond)
+		if _, err := m.Restore(sid); err != nil {
+			t.Fatalf("want <nil> got (%T, %+v)", err, err)
+		}
+	}
+}
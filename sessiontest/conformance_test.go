@@ -0,0 +1,14 @@
+package sessiontest_test
+
+import (
+	"testing"
+
+	"github.com/8i8/session"
+	"github.com/8i8/session/sessiontest"
+)
+
+func TestRAMConformance(t *testing.T) {
+	sessiontest.RunConformance(t, func() session.Manager {
+		return session.NewManager(session.RAM)
+	})
+}
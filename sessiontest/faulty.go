@@ -0,0 +1,117 @@
+// Package sessiontest provides support for testing code that uses the
+// session package.
+package sessiontest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/8i8/session"
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// Op identifies a method of a session manager.
+type Op int
+
+const (
+	Create Op = iota
+	Restore
+	Destroy
+)
+
+// Fault is the failure injected into a call, the call is delayed by
+// Delay and then, if Err is set, fails with Err rather than reaching the
+// underlying manager.
+type Fault struct {
+	Err   error
+	Delay time.Duration
+}
+
+// FaultyManager is a session manager that injects programmed failures
+// and latency into the calls made to it, passing the calls that are not
+// to fail on to an underlying manager.
+type FaultyManager struct {
+	session.Manager
+	mu     sync.Mutex
+	queue  map[Op][]Fault
+	policy func(op Op, sid uuid.UUID) Fault
+	calls  map[Op]int
+}
+
+// NewFaultyManager returns a FaultyManager that passes calls on to m, if
+// m is nil a new RAM manager is used.
+func NewFaultyManager(m session.Manager) *FaultyManager {
+	if m == nil {
+		m = session.NewManager(session.RAM)
+	}
+	return &FaultyManager{
+		Manager: m,
+		queue:   make(map[Op][]Fault),
+		calls:   make(map[Op]int),
+	}
+}
+
+// Fail queues faults for the next calls of op, one per call in order.
+// Queued faults take precedence over the policy.
+func (f *FaultyManager) Fail(op Op, faults ...Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queue[op] = append(f.queue[op], faults...)
+}
+
+// Policy sets a function that decides the fault of every call for which
+// none is queued, a zero Fault lets the call through untouched.
+func (f *FaultyManager) Policy(fn func(op Op, sid uuid.UUID) Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.policy = fn
+}
+
+// Calls returns the number of times that op has been called.
+func (f *FaultyManager) Calls(op Op) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// fault counts the call and applies its fault, returning its error.
+func (f *FaultyManager) fault(op Op, sid uuid.UUID) error {
+	f.mu.Lock()
+	f.calls[op]++
+	var ft Fault
+	if q := f.queue[op]; len(q) > 0 {
+		ft, f.queue[op] = q[0], q[1:]
+	} else if f.policy != nil {
+		ft = f.policy(op, sid)
+	}
+	f.mu.Unlock()
+	if ft.Delay > 0 {
+		time.Sleep(ft.Delay)
+	}
+	return ft.Err
+}
+
+// Create creates a session unless a fault is programmed.
+func (f *FaultyManager) Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error) {
+	if err := f.fault(Create, sid); err != nil {
+		return ram.Session{}, err
+	}
+	return f.Manager.Create(sid, maxage, opts...)
+}
+
+// Restore restores a session unless a fault is programmed.
+func (f *FaultyManager) Restore(sid uuid.UUID) (ram.Session, error) {
+	if err := f.fault(Restore, sid); err != nil {
+		return ram.Session{}, err
+	}
+	return f.Manager.Restore(sid)
+}
+
+// Destroy destroys a session unless a fault is programmed.
+func (f *FaultyManager) Destroy(sid uuid.UUID) error {
+	if err := f.fault(Destroy, sid); err != nil {
+		return err
+	}
+	return f.Manager.Destroy(sid)
+}
@@ -0,0 +1,67 @@
+package sessiontest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/8i8/session"
+	"github.com/8i8/session/sessiontest"
+	"github.com/google/uuid"
+)
+
+// login is an example handler, retrying a failed Create once.
+func login(m session.Manager) error {
+	var err error
+	for i := 0; i < 2; i++ {
+		if _, err = m.Create(uuid.New(), 0); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// restore is an example handler that gives up on a slow store.
+func restore(m session.Manager, sid uuid.UUID, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Restore(sid)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return context.DeadlineExceeded
+	}
+}
+
+func ExampleFaultyManager_Fail() {
+	m := sessiontest.NewFaultyManager(nil)
+	m.Fail(sessiontest.Create, sessiontest.Fault{
+		Err: errors.New("store unavailable"),
+	})
+	err := login(m)
+	fmt.Println(err, m.Calls(sessiontest.Create))
+
+	// Output: <nil> 2
+}
+
+func ExampleFaultyManager_Policy() {
+	m := sessiontest.NewFaultyManager(nil)
+	sid := uuid.New()
+	if _, err := m.Create(sid, 0); err != nil {
+		fmt.Println(err)
+	}
+	m.Policy(func(op sessiontest.Op, id uuid.UUID) sessiontest.Fault {
+		if op == sessiontest.Restore {
+			return sessiontest.Fault{Delay: 100 * time.Millisecond}
+		}
+		return sessiontest.Fault{}
+	})
+	err := restore(m, sid, 10*time.Millisecond)
+	fmt.Println(errors.Is(err, context.DeadlineExceeded))
+
+	// Output: true
+}
@@ -0,0 +1,52 @@
+// Package stamp defines the form in which the session providers persist
+// timestamps, such that a record written by one version of the package,
+// or of Go, is read back as the same instant by another. A time.Time
+// carries a location and a monotonic clock reading, neither of which
+// survive serialisation intact, its encodings also differ between gob,
+// JSON and text. A Stamp is the instant alone.
+package stamp
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Stamp is an instant in nanoseconds since the Unix epoch, UTC, zero
+// being the zero time.Time. It spans the years 1678 to 2262.
+type Stamp int64
+
+// Of returns the stamp of t.
+func Of(t time.Time) Stamp {
+	if t.IsZero() {
+		return 0
+	}
+	return Stamp(t.UnixNano())
+}
+
+// Time returns the instant of the stamp in UTC.
+func (s Stamp) Time() time.Time {
+	if s == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(s)).UTC()
+}
+
+// UnmarshalJSON decodes a stamp from a JSON number, or from a string in
+// the RFC 3339 form in which a time.Time is encoded, such that records
+// written before timestamps were stamped may still be read.
+func (s *Stamp) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var t time.Time
+		if err := t.UnmarshalJSON(b); err != nil {
+			return err
+		}
+		*s = Of(t)
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*s = Stamp(n)
+	return nil
+}
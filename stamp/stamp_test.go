@@ -0,0 +1,47 @@
+package stamp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	const fname = "TestRoundTrip"
+	loc := time.FixedZone("UTC+5:30", 5*3600+1800)
+	// time.Now carries a monotonic reading, In a location.
+	for _, want := range []time.Time{
+		time.Now(),
+		time.Now().In(loc),
+		time.Date(2262, 1, 1, 0, 0, 0, 1, loc),
+		{},
+	} {
+		b, err := json.Marshal(Of(want))
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		var s Stamp
+		if err := json.Unmarshal(b, &s); err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		if got := s.Time(); !got.Equal(want) || got.IsZero() != want.IsZero() {
+			t.Errorf("%s: want %v got %v", fname, want, got)
+		}
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	const fname = "TestLegacy"
+	want := time.Date(2021, 3, 4, 5, 6, 7, 8, time.FixedZone("", -3600))
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	var s Stamp
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if !s.Time().Equal(want) {
+		t.Errorf("%s: want %v got %v", fname, want, s.Time())
+	}
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+// ErrDraining is returned when a provider is swapped whilst a previous
+// swap is still draining.
+var ErrDraining = errors.New("previous provider still draining")
+
+// Swapper is implemented by the managers returned by NewManager and
+// Build, whose underlying provider may be replaced whilst in use.
+type Swapper interface {
+	SwapProvider(next Manager, drain bool) error
+}
+
+// current returns the provider that new operations are directed to.
+func (m *manager) current() Manager {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.Manager
+}
+
+// old returns the provider being drained, if any.
+func (m *manager) old() Manager {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.draining
+}
+
+// Create makes the session with the current provider.
+func (m *manager) Create(sid uuid.UUID, maxage int, opts ...ram.CreateOption) (ram.Session, error) {
+	return m.current().Create(sid, maxage, opts...)
+}
+
+// Restore restores the session from the current provider or, whilst it
+// is being drained and the session has yet to be moved, from the
+// previous one.
+func (m *manager) Restore(sid uuid.UUID) (ram.Session, error) {
+	se, err := m.current().Restore(sid)
+	if old := m.old(); old != nil && errors.Is(err, ErrNotFound) {
+		return old.Restore(sid)
+	}
+	return se, err
+}
+
+// Destroy destroys the session in the current provider and, whilst it
+// is being drained, in the previous one.
+func (m *manager) Destroy(sid uuid.UUID) error {
+	err := m.current().Destroy(sid)
+	if old := m.old(); old != nil && errors.Is(err, ErrNotFound) {
+		return old.Destroy(sid)
+	}
+	return err
+}
+
+// Period sets the timeout verification period of the current provider.
+func (m *manager) Period(t time.Duration) time.Duration {
+	return m.current().Period(t)
+}
+
+// SwapProvider directs all new operations to next in place of the
+// current provider. If drain is set the sessions of the current
+// provider are then migrated to next and removed, sessions that are yet
+// to be migrated being restored from the current provider in the
+// meantime. Once drained, the previous provider is closed if it
+// implements io.Closer. A SID that is in use in both providers is left
+// in the previous one and an error wrapping ErrExists is returned.
+// Writes made to a session through the previous provider during its
+// own migration may be lost.
+func (m *manager) SwapProvider(next Manager, drain bool) error {
+	const fname = "manager.SwapProvider"
+	m.mu.Lock()
+	if m.draining != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("%s: %w", fname, ErrDraining)
+	}
+	prev := m.Manager
+	m.Manager = next
+	if drain {
+		m.draining = prev
+	}
+	m.mu.Unlock()
+	if !drain {
+		return nil
+	}
+
+	_, err := Migrate(prev, next, true)
+	m.mu.Lock()
+	m.draining = nil
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	if c, ok := provider(prev).(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("%s: %w", fname, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/8i8/session/ram"
+	"github.com/google/uuid"
+)
+
+func TestSwapProvider(t *testing.T) {
+	const fname = "TestSwapProvider"
+	m := NewManager(RAM)
+	old := provider(m).(*ram.Store)
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+	for i, id := range ids {
+		se, err := m.Create(id, 0)
+		if err != nil {
+			t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+		}
+		se.Set("n", i)
+	}
+
+	next := NewManager(RAM)
+	if err := m.(Swapper).SwapProvider(next, true); err != nil {
+		t.Fatalf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	// Existing sessions were moved.
+	if n := len(old.Export()); n != 0 {
+		t.Errorf("%s: want 0 sessions in old store got %d", fname, n)
+	}
+	for i, id := range ids {
+		se, err := next.Restore(id)
+		if err != nil {
+			t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+			continue
+		}
+		if v, _ := se.Get("n"); v != i {
+			t.Errorf("%s: want %d got %v", fname, i, v)
+		}
+	}
+	// New operations reach the new store.
+	id := uuid.New()
+	if _, err := m.Create(id, 0); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	if _, err := next.Restore(id); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err := old.Restore(id)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+	if err := m.Destroy(ids[0]); err != nil {
+		t.Errorf("%s: want <nil> got (%T, %+v)", fname, err, err)
+	}
+	_, err = next.Restore(ids[0])
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: want ErrNotFound got (%T, %+v)", fname, err, err)
+	}
+}